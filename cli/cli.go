@@ -0,0 +1,378 @@
+// Package cli implements the ballistic command-line tool.
+//
+// Build your own main with the models registered to decode records:
+//
+//	func main() {
+//		_ = ballistic.DefaultRegistry.Register("event", 1, func() ballistic.DataModel { return &Event{} })
+//		if err := cli.Run(os.Args[1:], os.Stdout, ballistic.DefaultRegistry); err != nil {
+//			fmt.Fprintln(os.Stderr, err)
+//			os.Exit(1)
+//		}
+//	}
+//
+// The -model flags are only defined when the registry has models,
+// the ballistic binary registers none and dumps the records as hex.
+// Encrypted spools are read with the keys given to RunOptions:
+//
+//	err := cli.RunOptions(os.Args[1:], os.Stdout, cli.Options{Registry: registry, Keys: keys})
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"github.com/farwydi/ballistic/sender"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const usage = `usage: ballistic <command> [flags] [files]
+
+commands:
+  inspect FILE...              print the header, checksum status and record counts
+  dump [-model NAME] [-hex] [-all] FILE
+                               print the records as hex or decoded as JSON
+  verify FILE...               check framing and checksum, fails on a broken file
+  repair [-o OUT] FILE         salvage complete pending records into a fresh file
+  compact FILE...              drop consumed records, the file must not be in use
+  replay -dsn DSN [-model NAME] [-batch N] [-rate N] [-dry-run] PATH...
+                               publish the pending records of workspaces or files
+
+-model is defined by the builds registering models, see the cli package.
+`
+
+// ErrUsage is returned when the arguments are invalid.
+var ErrUsage = errors.New("invalid usage")
+
+// Options are the registry decoding the records and the keys of the encrypted files.
+type Options struct {
+	Registry *ballistic.Registry
+	Keys     file.KeyProvider
+}
+
+// keys returns the key providers of the file functions.
+func (o Options) keys() []file.KeyProvider {
+	if o.Keys == nil {
+		return nil
+	}
+	return []file.KeyProvider{o.Keys}
+}
+
+type command func(args []string, out io.Writer, opts Options) error
+
+var commands = map[string]command{
+	"inspect": inspect,
+	"dump":    dump,
+	"verify":  verify,
+	"repair":  repair,
+	"compact": compact,
+	"replay":  replay,
+}
+
+// Run executes the command given in args.
+func Run(args []string, out io.Writer, registry *ballistic.Registry) error {
+	return RunOptions(args, out, Options{Registry: registry})
+}
+
+// RunOptions executes the command given in args with the options.
+func RunOptions(args []string, out io.Writer, opts Options) error {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(out, usage)
+		return ErrUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		_, _ = fmt.Fprint(out, usage)
+		return fmt.Errorf("%w: unknown command %q", ErrUsage, args[0])
+	}
+
+	if opts.Registry == nil {
+		opts.Registry = ballistic.DefaultRegistry
+	}
+
+	return cmd(args[1:], out, opts)
+}
+
+func newFlagSet(name string, out io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	return fs
+}
+
+// modelFlag defines the -model flag if the registry has models, records can't be decoded otherwise.
+func modelFlag(fs *flag.FlagSet, registry *ballistic.Registry, usage string) *string {
+	if len(registry.Names()) == 0 {
+		return new(string)
+	}
+	return fs.String("model", "", usage)
+}
+
+func checksum(info file.Info) string {
+	if info.Sum == info.ActualSum {
+		return fmt.Sprintf("ok (%#08x)", info.Sum)
+	}
+	return fmt.Sprintf("mismatch (stored %#08x, actual %#08x)", info.Sum, info.ActualSum)
+}
+
+func inspect(args []string, out io.Writer, opts Options) error {
+	fs := newFlagSet("inspect", out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: inspect needs a file", ErrUsage)
+	}
+
+	for _, path := range fs.Args() {
+		info, err := file.WalkFile(path, nil, opts.keys()...)
+		if err != nil {
+			return err
+		}
+
+		framing := "ok"
+		if info.Err != nil {
+			framing = info.Err.Error()
+		}
+
+		_, _ = fmt.Fprintf(out, "file:       %s\n", path)
+		if m, err := file.ReadManifest(filepath.Dir(path)); err == nil {
+			if entry, ok := m.Lookup(path); ok {
+				_, _ = fmt.Fprintf(out, "sql:        %s\n", entry.SQL)
+			}
+		}
+		_, _ = fmt.Fprintf(out, "header:     format %d, model %q, version %d\n",
+			info.Header.Format, info.Header.Model, info.Header.Version)
+		if info.Header.Codec != "" {
+			_, _ = fmt.Fprintf(out, "codec:      %s\n", info.Header.Codec)
+		}
+		if info.Header.Compression != "" {
+			_, _ = fmt.Fprintf(out, "compressed: %s, %d blocks\n", info.Header.Compression, info.Blocks)
+		}
+		if info.Header.KeyID != "" {
+			_, _ = fmt.Fprintf(out, "encrypted:  key %q\n", info.Header.KeyID)
+		}
+		_, _ = fmt.Fprintf(out, "size:       %d bytes (valid %d)\n", info.Size, info.ValidSize)
+		_, _ = fmt.Fprintf(out, "checksum:   %s\n", checksum(info))
+		_, _ = fmt.Fprintf(out, "framing:    %s\n", framing)
+		if info.SkipRecords > 0 {
+			_, _ = fmt.Fprintf(out, "skip-ahead: %d (+%d records of the block)\n", info.SkipAhead, info.SkipRecords)
+		} else {
+			_, _ = fmt.Fprintf(out, "skip-ahead: %d\n", info.SkipAhead)
+		}
+		_, _ = fmt.Fprintf(out, "records:    %d (pending %d, consumed %d)\n",
+			info.Records, info.Pending, info.Records-info.Pending)
+		_, _ = fmt.Fprintf(out, "pending:    %d bytes\n", info.PendingBytes)
+		if strings.HasSuffix(path, ".carapted") {
+			_, _ = fmt.Fprintln(out, "note:       file was marked as broken by the loader")
+		}
+		_, _ = fmt.Fprintln(out)
+	}
+
+	return nil
+}
+
+func dump(args []string, out io.Writer, opts Options) error {
+	registry := opts.Registry
+	fs := newFlagSet("dump", out)
+	modelName := modelFlag(fs, registry, "registered model used to decode records as JSON (default from the header)")
+	hexOnly := fs.Bool("hex", false, "print records as hex even if the model is known")
+	all := fs.Bool("all", false, "include consumed records")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: dump needs exactly one file", ErrUsage)
+	}
+
+	header, err := file.ReadHeader(fs.Arg(0))
+	if err != nil && !*hexOnly {
+		return err
+	}
+	if *modelName == "" && !*hexOnly {
+		if _, err := registry.Version(header.Model); err == nil {
+			*modelName = header.Model
+		}
+	}
+	if *hexOnly {
+		*modelName = ""
+	}
+
+	enc := json.NewEncoder(out)
+	n := 0
+	info, err := file.WalkFile(fs.Arg(0), func(rec file.Record) error {
+		if rec.Consumed && !*all {
+			return nil
+		}
+		n++
+
+		if rec.Err != nil {
+			_, err := fmt.Fprintf(out, "%d\t%s\t%v\n", rec.Offset, hex.EncodeToString(rec.Data), rec.Err)
+			return err
+		}
+
+		if *modelName == "" {
+			_, err := fmt.Fprintf(out, "%d\tv%d\t%s\n", rec.Offset, rec.Version, hex.EncodeToString(rec.Data))
+			return err
+		}
+
+		model, err := file.Decode(registry, *modelName, header, rec)
+		if err != nil {
+			return fmt.Errorf("record at %d: %w", rec.Offset, err)
+		}
+		return enc.Encode(model)
+	}, opts.keys()...)
+	if err != nil {
+		return err
+	}
+
+	if info.Err != nil {
+		return fmt.Errorf("dumped %d records: %w", n, info.Err)
+	}
+	return nil
+}
+
+func verify(args []string, out io.Writer, opts Options) error {
+	fs := newFlagSet("verify", out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: verify needs a file", ErrUsage)
+	}
+
+	broken := 0
+	for _, path := range fs.Args() {
+		info, err := file.WalkFile(path, nil, opts.keys()...)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Err != nil:
+			broken++
+			_, _ = fmt.Fprintf(out, "%s: %v\n", path, info.Err)
+		case !info.Valid():
+			broken++
+			_, _ = fmt.Fprintf(out, "%s: checksum %s\n", path, checksum(info))
+		default:
+			_, _ = fmt.Fprintf(out, "%s: ok\n", path)
+		}
+	}
+
+	if broken > 0 {
+		return fmt.Errorf("%d of %d files are broken", broken, fs.NArg())
+	}
+	return nil
+}
+
+func repair(args []string, out io.Writer, opts Options) error {
+	fs := newFlagSet("repair", out)
+	dst := fs.String("o", "", "output file (default FILE.repaired)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: repair needs exactly one file", ErrUsage)
+	}
+
+	src := fs.Arg(0)
+	if *dst == "" {
+		*dst = filepath.Join(filepath.Dir(src), filepath.Base(src)+".repaired")
+	}
+
+	info, err := file.Salvage(src, *dst, opts.keys()...)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "%s: salvaged %d records into %s\n", src, info.Pending, *dst)
+	if info.Err != nil {
+		_, _ = fmt.Fprintf(out, "%s: dropped the tail: %v\n", src, info.Err)
+	}
+	return nil
+}
+
+func compact(args []string, out io.Writer, opts Options) error {
+	fs := newFlagSet("compact", out)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: compact needs a file", ErrUsage)
+	}
+
+	for _, path := range fs.Args() {
+		before, after, err := file.Compact(path, opts.keys()...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		_, _ = fmt.Fprintf(out, "%s: %d -> %d bytes\n", path, before, after)
+	}
+	return nil
+}
+
+func replay(args []string, out io.Writer, opts Options) error {
+	registry := opts.Registry
+	fs := newFlagSet("replay", out)
+	driver := fs.String("driver", "clickhouse", "database/sql driver name")
+	dsn := fs.String("dsn", "", "data source name")
+	modelName := modelFlag(fs, registry, "registered model for every file (default matched by file name)")
+	batch := fs.Int("batch", sender.ConfigDefault.SendLimit, "records per transaction")
+	rate := fs.Int("rate", 0, "rows per second, 0 is unlimited")
+	dryRun := fs.Bool("dry-run", false, "decode the records without publishing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: replay needs a workspace or a file", ErrUsage)
+	}
+	if *dsn == "" && !*dryRun {
+		return fmt.Errorf("%w: replay needs -dsn", ErrUsage)
+	}
+
+	var connect *sql.DB
+	if !*dryRun {
+		var err error
+		connect, err = sql.Open(*driver, *dsn)
+		if err != nil {
+			return err
+		}
+		defer connect.Close()
+	}
+
+	stats, err := sender.Replay(context.Background(), connect, fs.Args(), sender.ReplayConfig{
+		Registry:      registry,
+		Keys:          opts.Keys,
+		Model:         *modelName,
+		BatchSize:     *batch,
+		RowsPerSecond: *rate,
+		DryRun:        *dryRun,
+		Progress: func(p sender.ReplayProgress) {
+			switch {
+			case !p.Done:
+				_, _ = fmt.Fprintf(out, "%s: %d/%d\n", p.File, p.Sent, p.Pending)
+			case p.Err != nil:
+				_, _ = fmt.Fprintf(out, "%s: done %d records as %s, broken tail: %v\n", p.File, p.Sent, p.Model, p.Err)
+			default:
+				_, _ = fmt.Fprintf(out, "%s: done %d records as %s\n", p.File, p.Sent, p.Model)
+			}
+		},
+	})
+
+	action := "replayed"
+	if *dryRun {
+		action = "dry run"
+	}
+	_, _ = fmt.Fprintf(out, "%s: %d records in %d batches from %d files\n",
+		action, stats.Records, stats.Batches, stats.Files)
+
+	return err
+}
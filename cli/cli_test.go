@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testModel struct {
+	N int
+}
+
+func (t *testModel) SQL() string {
+	return "INSERT INTO test (n) VALUES (?)"
+}
+
+func (t *testModel) ToExec() []interface{} {
+	return []interface{}{t.N}
+}
+
+func (t *testModel) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, t)
+}
+
+func (t testModel) MarshalBinary() (data []byte, err error) {
+	return json.Marshal(t)
+}
+
+func TestRun(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("test", 1, func() ballistic.DataModel {
+		return &testModel{}
+	}))
+
+	path := filepath.Join(tempDir, "1_0.bd")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	q, err := file.NewQueue(f, &testModel{}, file.Config{Registry: registry})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&testModel{N: 1}))
+	require.NoError(t, q.Push(&testModel{N: 2}))
+	require.NoError(t, f.Close())
+
+	var out bytes.Buffer
+	require.NoError(t, Run([]string{"inspect", path}, &out, registry))
+	assert.Contains(t, out.String(), fmt.Sprintf(`header:     format %d, model "test", version 1`, file.FormatVersion))
+	assert.Contains(t, out.String(), "records:    2 (pending 2, consumed 0)")
+
+	out.Reset()
+	require.NoError(t, Run([]string{"dump", path}, &out, registry))
+	assert.Equal(t, "{\"N\":1}\n{\"N\":2}\n", out.String())
+
+	out.Reset()
+	require.NoError(t, Run([]string{"dump", "-hex", path}, &out, registry))
+	assert.Contains(t, out.String(), "7b224e223a317d")
+
+	out.Reset()
+	require.NoError(t, Run([]string{"verify", path}, &out, registry))
+
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, stat.Size()-1))
+	assert.Error(t, Run([]string{"verify", path}, &out, registry))
+
+	out.Reset()
+	require.NoError(t, Run([]string{"repair", path}, &out, registry))
+	assert.Contains(t, out.String(), "salvaged 1 records")
+	require.NoError(t, Run([]string{"verify", path + ".repaired"}, &out, registry))
+
+	assert.ErrorIs(t, Run([]string{"unknown"}, &out, registry), ErrUsage)
+
+	// Without models the records are dumped as hex
+	assert.Error(t, Run([]string{"dump", "-model", "test", path + ".repaired"}, &out, ballistic.NewRegistry()))
+	out.Reset()
+	require.NoError(t, Run([]string{"dump", path + ".repaired"}, &out, ballistic.NewRegistry()))
+	assert.Contains(t, out.String(), "7b224e223a317d")
+}
+
+func TestRunEncrypted(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("test", 1, func() ballistic.DataModel {
+		return &testModel{}
+	}))
+	keys := file.StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	opts := Options{Registry: registry, Keys: keys}
+
+	path := filepath.Join(tempDir, "1_0.bd")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	q, err := file.NewQueue(f, &testModel{}, file.Config{Registry: registry, Keys: keys})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&testModel{N: 1}))
+	require.NoError(t, q.Push(&testModel{N: 2}))
+	_, err = q.Eject(1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Without the keys the records can't be read
+	var out bytes.Buffer
+	require.NoError(t, Run([]string{"dump", path}, &out, registry))
+	assert.Contains(t, out.String(), file.ErrUnknownKey.Error())
+
+	out.Reset()
+	require.NoError(t, RunOptions([]string{"inspect", path}, &out, opts))
+	assert.Contains(t, out.String(), "records:    2 (pending 1, consumed 1)")
+
+	out.Reset()
+	require.NoError(t, RunOptions([]string{"dump", "-all", path}, &out, opts))
+	assert.Equal(t, "{\"N\":1}\n{\"N\":2}\n", out.String())
+
+	require.NoError(t, RunOptions([]string{"verify", path}, &out, opts))
+
+	out.Reset()
+	require.NoError(t, RunOptions([]string{"repair", path}, &out, opts))
+	assert.Contains(t, out.String(), "salvaged 1 records")
+
+	require.NoError(t, RunOptions([]string{"compact", path}, &out, opts))
+	out.Reset()
+	require.NoError(t, RunOptions([]string{"dump", path}, &out, opts))
+	assert.Equal(t, "{\"N\":2}\n", out.String())
+
+	out.Reset()
+	require.NoError(t, RunOptions([]string{"replay", "-dry-run", "-model", "test", path}, &out, opts))
+	assert.Contains(t, out.String(), "dry run: 1 records in 1 batches from 1 files")
+}
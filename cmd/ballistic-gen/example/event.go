@@ -0,0 +1,21 @@
+// Package example shows the code generated by ballistic-gen.
+package example
+
+import (
+	"time"
+)
+
+//go:generate go run github.com/farwydi/ballistic/cmd/ballistic-gen -type Event -table test.table_1
+
+type Event struct {
+	RecordTime time.Time `ch:"record_time"`
+	StringVal  string    `ch:"string_val"`
+	BoolVar    bool      `ch:"bool_var"`
+	Int32Val   int32     `ch:"int_32_val"`
+	UInt64Val  uint64    `ch:"u_int_64_val"`
+	Float32Val float32   `ch:"float_32_val"`
+	Float64Val float64   `ch:"float_64_val"`
+	Bytes      []byte    `ch:"bytes"`
+	Skipped    string    `ch:"-"`
+	Untagged   string
+}
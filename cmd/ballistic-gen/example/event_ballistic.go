@@ -0,0 +1,113 @@
+// Code generated by ballistic-gen; DO NOT EDIT.
+
+package example
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+func (m *Event) SQL() string {
+	return "INSERT INTO test.table_1 (record_time, string_val, bool_var, int_32_val, u_int_64_val, float_32_val, float_64_val, bytes) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+}
+
+func (m *Event) ToExec() []interface{} {
+	var b2 uint8
+	if m.BoolVar {
+		b2 = 1
+	}
+	return []interface{}{m.RecordTime, m.StringVal, b2, m.Int32Val, m.UInt64Val, m.Float32Val, m.Float64Val, m.Bytes}
+}
+
+// MarshalBinary encodes the tagged fields in column order, the same way as the tagged package:
+// varints for integers, fixed size floats, length prefixed strings, bytes and times.
+func (m *Event) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 70+len(m.StringVal)+len(m.Bytes))
+	var tmp [binary.MaxVarintLen64]byte
+	{
+		data, err := m.RecordTime.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(data)))]...)
+		buf = append(buf, data...)
+	}
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(m.StringVal)))]...)
+	buf = append(buf, m.StringVal...)
+	if m.BoolVar {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = append(buf, tmp[:binary.PutVarint(tmp[:], int64(m.Int32Val))]...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], m.UInt64Val)]...)
+	binary.BigEndian.PutUint32(tmp[:4], math.Float32bits(m.Float32Val))
+	buf = append(buf, tmp[:4]...)
+	binary.BigEndian.PutUint64(tmp[:8], math.Float64bits(m.Float64Val))
+	buf = append(buf, tmp[:8]...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(m.Bytes)))]...)
+	buf = append(buf, m.Bytes...)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes data encoded by MarshalBinary.
+func (m *Event) UnmarshalBinary(data []byte) error {
+	if size, n := binary.Uvarint(data); n <= 0 || uint64(len(data)-n) < size {
+		return io.ErrUnexpectedEOF
+	} else {
+		b := data[n : n+int(size)]
+		if err := m.RecordTime.UnmarshalBinary(b); err != nil {
+			return err
+		}
+		data = data[n+int(size):]
+	}
+	if size, n := binary.Uvarint(data); n <= 0 || uint64(len(data)-n) < size {
+		return io.ErrUnexpectedEOF
+	} else {
+		b := data[n : n+int(size)]
+		m.StringVal = string(b)
+		data = data[n+int(size):]
+	}
+	if len(data) < 1 {
+		return io.ErrUnexpectedEOF
+	}
+	m.BoolVar = data[0] != 0
+	data = data[1:]
+	if x, n := binary.Varint(data); n <= 0 {
+		return io.ErrUnexpectedEOF
+	} else if int64(int32(x)) != x {
+		return errors.New("Event.Int32Val overflows int32")
+	} else {
+		m.Int32Val = int32(x)
+		data = data[n:]
+	}
+	if x, n := binary.Uvarint(data); n <= 0 {
+		return io.ErrUnexpectedEOF
+	} else {
+		m.UInt64Val = x
+		data = data[n:]
+	}
+	if len(data) < 4 {
+		return io.ErrUnexpectedEOF
+	}
+	m.Float32Val = math.Float32frombits(binary.BigEndian.Uint32(data))
+	data = data[4:]
+	if len(data) < 8 {
+		return io.ErrUnexpectedEOF
+	}
+	m.Float64Val = math.Float64frombits(binary.BigEndian.Uint64(data))
+	data = data[8:]
+	if size, n := binary.Uvarint(data); n <= 0 || uint64(len(data)-n) < size {
+		return io.ErrUnexpectedEOF
+	} else {
+		b := data[n : n+int(size)]
+		m.Bytes = append([]byte(nil), b...)
+		data = data[n+int(size):]
+	}
+	if len(data) != 0 {
+		return errors.New("Event: trailing bytes")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Code generated by ballistic-gen; DO NOT EDIT.
+
+package example
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBallistic(t *testing.T) {
+	m := &Event{
+		RecordTime: time.Date(2021, 4, 29, 20, 1, 34, 561, time.UTC),
+		StringVal:  "test",
+		BoolVar:    true,
+		Int32Val:   int32(-7),
+		UInt64Val:  uint64(7),
+		Float32Val: float32(1.5),
+		Float64Val: float64(1.5),
+		Bytes:      []byte{1, 2},
+	}
+
+	args := m.ToExec()
+	if n := strings.Count(m.SQL(), "?"); n != len(args) {
+		t.Fatalf("%d placeholders for %d arguments", n, len(args))
+	}
+
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &Event{}
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !got.RecordTime.Equal(m.RecordTime) {
+		t.Errorf("RecordTime: got %v, want %v", got.RecordTime, m.RecordTime)
+	}
+	if got.StringVal != m.StringVal {
+		t.Errorf("StringVal: got %v, want %v", got.StringVal, m.StringVal)
+	}
+	if got.BoolVar != m.BoolVar {
+		t.Errorf("BoolVar: got %v, want %v", got.BoolVar, m.BoolVar)
+	}
+	if got.Int32Val != m.Int32Val {
+		t.Errorf("Int32Val: got %v, want %v", got.Int32Val, m.Int32Val)
+	}
+	if got.UInt64Val != m.UInt64Val {
+		t.Errorf("UInt64Val: got %v, want %v", got.UInt64Val, m.UInt64Val)
+	}
+	if got.Float32Val != m.Float32Val {
+		t.Errorf("Float32Val: got %v, want %v", got.Float32Val, m.Float32Val)
+	}
+	if got.Float64Val != m.Float64Val {
+		t.Errorf("Float64Val: got %v, want %v", got.Float64Val, m.Float64Val)
+	}
+	if !bytes.Equal(got.Bytes, m.Bytes) {
+		t.Errorf("Bytes: got %v, want %v", got.Bytes, m.Bytes)
+	}
+
+	if err := got.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("short data is decoded")
+	}
+}
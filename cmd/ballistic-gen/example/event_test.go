@@ -0,0 +1,61 @@
+package example
+
+import (
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/tagged"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var _ ballistic.DataModel = (*Event)(nil)
+
+func TestTaggedCompatibility(t *testing.T) {
+	e := &Event{
+		RecordTime: time.Date(2021, 4, 29, 20, 1, 34, 561, time.UTC),
+		StringVal:  "test",
+		BoolVar:    true,
+		Int32Val:   -7,
+		UInt64Val:  1 << 60,
+		Float32Val: 1.5,
+		Float64Val: 2.25,
+		Bytes:      []byte{1, 2},
+		Skipped:    "skipped",
+	}
+	row := tagged.Must("test.table_1", e)
+
+	assert.Equal(t, row.SQL(), e.SQL())
+	assert.Equal(t, row.ToExec(), e.ToExec())
+
+	// Records written by one are read by the other
+	generated, err := e.MarshalBinary()
+	require.NoError(t, err)
+	reflected, err := row.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, reflected, generated)
+
+	decoded := &Event{}
+	require.NoError(t, decoded.UnmarshalBinary(reflected))
+	assert.Equal(t, "", decoded.Skipped)
+	decoded.Skipped = e.Skipped
+	assert.Equal(t, e, decoded)
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	e := &Event{RecordTime: time.Now(), StringVal: "test", Bytes: []byte{1, 2}}
+	row := tagged.Must("test.table_1", e)
+
+	b.Run("generated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = e.MarshalBinary()
+		}
+	})
+	b.Run("tagged", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = row.MarshalBinary()
+		}
+	})
+}
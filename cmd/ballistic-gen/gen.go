@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+const header = "// Code generated by ballistic-gen; DO NOT EDIT.\n\n"
+
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) line(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(w, format, args...)
+	w.WriteByte('\n')
+}
+
+func (s *structInfo) has(kinds ...fieldKind) bool {
+	for _, f := range s.fields {
+		for _, k := range kinds {
+			if f.kind == k {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// generate writes the DataModel methods of the struct stored into the table.
+func generate(s *structInfo, table string) ([]byte, error) {
+	var w writer
+	w.WriteString(header)
+	w.line("package %s", s.pkg)
+	w.line("")
+	w.line("import (")
+	w.line("%q", "encoding/binary")
+	w.line("%q", "errors")
+	w.line("%q", "io")
+	if s.has(kindFloat32, kindFloat64) {
+		w.line("%q", "math")
+	}
+	w.line(")")
+	w.line("")
+
+	columns := make([]string, len(s.fields))
+	for i, f := range s.fields {
+		columns[i] = f.column
+	}
+	sql := "INSERT INTO " + table +
+		" (" + strings.Join(columns, ", ") + ")" +
+		" VALUES (" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	w.line("func (m *%s) SQL() string {", s.name)
+	w.line("return %q", sql)
+	w.line("}")
+	w.line("")
+
+	// ToExec, bools are passed to the driver as 0 and 1
+	w.line("func (m *%s) ToExec() []interface{} {", s.name)
+	args := make([]string, len(s.fields))
+	for i, f := range s.fields {
+		args[i] = "m." + f.name
+		if f.kind == kindBool {
+			args[i] = fmt.Sprintf("b%d", i)
+			w.line("var b%d uint8", i)
+			w.line("if m.%s {", f.name)
+			w.line("b%d = 1", i)
+			w.line("}")
+		}
+	}
+	w.line("return []interface{}{%s}", strings.Join(args, ", "))
+	w.line("}")
+	w.line("")
+
+	generateMarshal(&w, s)
+	generateUnmarshal(&w, s)
+
+	return format.Source(w.Bytes())
+}
+
+func generateMarshal(w *writer, s *structInfo) {
+	// The capacity fits the whole record
+	size := 0
+	var dynamic []string
+	for _, f := range s.fields {
+		switch f.kind {
+		case kindBool:
+			size++
+		case kindInt, kindUint:
+			size += 10
+		case kindFloat32:
+			size += 4
+		case kindFloat64:
+			size += 8
+		case kindString, kindBytes:
+			size += 10
+			dynamic = append(dynamic, "len(m."+f.name+")")
+		case kindTime:
+			size += 1 + 16
+		}
+	}
+
+	w.line("// MarshalBinary encodes the tagged fields in column order, the same way as the tagged package:")
+	w.line("// varints for integers, fixed size floats, length prefixed strings, bytes and times.")
+	w.line("func (m *%s) MarshalBinary() ([]byte, error) {", s.name)
+	w.line("buf := make([]byte, 0, %s)", strings.Join(append([]string{fmt.Sprint(size)}, dynamic...), "+"))
+	w.line("var tmp [binary.MaxVarintLen64]byte")
+	for _, f := range s.fields {
+		v := "m." + f.name
+		switch f.kind {
+		case kindBool:
+			w.line("if %s {", v)
+			w.line("buf = append(buf, 1)")
+			w.line("} else {")
+			w.line("buf = append(buf, 0)")
+			w.line("}")
+		case kindInt:
+			w.line("buf = append(buf, tmp[:binary.PutVarint(tmp[:], %s)]...)", convert("int64", f.goType, v))
+		case kindUint:
+			w.line("buf = append(buf, tmp[:binary.PutUvarint(tmp[:], %s)]...)", convert("uint64", f.goType, v))
+		case kindFloat32:
+			w.line("binary.BigEndian.PutUint32(tmp[:4], math.Float32bits(%s))", v)
+			w.line("buf = append(buf, tmp[:4]...)")
+		case kindFloat64:
+			w.line("binary.BigEndian.PutUint64(tmp[:8], math.Float64bits(%s))", v)
+			w.line("buf = append(buf, tmp[:8]...)")
+		case kindString, kindBytes:
+			w.line("buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(%s)))]...)", v)
+			w.line("buf = append(buf, %s...)", v)
+		case kindTime:
+			w.line("{")
+			w.line("data, err := %s.MarshalBinary()", v)
+			w.line("if err != nil {")
+			w.line("return nil, err")
+			w.line("}")
+			w.line("buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(data)))]...)")
+			w.line("buf = append(buf, data...)")
+			w.line("}")
+		}
+	}
+	w.line("return buf, nil")
+	w.line("}")
+	w.line("")
+}
+
+func generateUnmarshal(w *writer, s *structInfo) {
+	w.line("// UnmarshalBinary decodes data encoded by MarshalBinary.")
+	w.line("func (m *%s) UnmarshalBinary(data []byte) error {", s.name)
+	for _, f := range s.fields {
+		v := "m." + f.name
+		switch f.kind {
+		case kindBool:
+			w.line("if len(data) < 1 {")
+			w.line("return io.ErrUnexpectedEOF")
+			w.line("}")
+			w.line("%s = data[0] != 0", v)
+			w.line("data = data[1:]")
+		case kindInt, kindUint:
+			decode, wide := "binary.Varint", "int64"
+			if f.kind == kindUint {
+				decode, wide = "binary.Uvarint", "uint64"
+			}
+			w.line("if x, n := %s(data); n <= 0 {", decode)
+			w.line("return io.ErrUnexpectedEOF")
+			if f.goType != wide {
+				w.line("} else if %s(%s(x)) != x {", wide, f.goType)
+				w.line("return errors.New(%q)", s.name+"."+f.name+" overflows "+f.goType)
+			}
+			w.line("} else {")
+			w.line("%s = %s", v, convert(f.goType, wide, "x"))
+			w.line("data = data[n:]")
+			w.line("}")
+		case kindFloat32:
+			w.line("if len(data) < 4 {")
+			w.line("return io.ErrUnexpectedEOF")
+			w.line("}")
+			w.line("%s = math.Float32frombits(binary.BigEndian.Uint32(data))", v)
+			w.line("data = data[4:]")
+		case kindFloat64:
+			w.line("if len(data) < 8 {")
+			w.line("return io.ErrUnexpectedEOF")
+			w.line("}")
+			w.line("%s = math.Float64frombits(binary.BigEndian.Uint64(data))", v)
+			w.line("data = data[8:]")
+		case kindString, kindBytes, kindTime:
+			w.line("if size, n := binary.Uvarint(data); n <= 0 || uint64(len(data)-n) < size {")
+			w.line("return io.ErrUnexpectedEOF")
+			w.line("} else {")
+			w.line("b := data[n : n+int(size)]")
+			switch f.kind {
+			case kindString:
+				w.line("%s = string(b)", v)
+			case kindBytes:
+				w.line("%s = append([]byte(nil), b...)", v)
+			case kindTime:
+				w.line("if err := %s.UnmarshalBinary(b); err != nil {", v)
+				w.line("return err")
+				w.line("}")
+			}
+			w.line("data = data[n+int(size):]")
+			w.line("}")
+		}
+	}
+	w.line("if len(data) != 0 {")
+	w.line("return errors.New(%q)", s.name+": trailing bytes")
+	w.line("}")
+	w.line("return nil")
+	w.line("}")
+}
+
+// convert returns the expression v of type from converted to type to.
+func convert(to, from, v string) string {
+	if to == from {
+		return v
+	}
+	return to + "(" + v + ")"
+}
+
+// generateTest writes a round trip test of the generated methods.
+func generateTest(s *structInfo) ([]byte, error) {
+	var w writer
+	w.WriteString(header)
+	w.line("package %s", s.pkg)
+	w.line("")
+	w.line("import (")
+	if s.has(kindBytes) {
+		w.line("%q", "bytes")
+	}
+	w.line("%q", "strings")
+	w.line("%q", "testing")
+	if s.has(kindTime) {
+		w.line("%q", "time")
+	}
+	w.line(")")
+	w.line("")
+
+	w.line("func Test%sBallistic(t *testing.T) {", s.name)
+	w.line("m := &%s{", s.name)
+	for _, f := range s.fields {
+		w.line("%s: %s,", f.name, sample(f))
+	}
+	w.line("}")
+	w.line("")
+	w.line("args := m.ToExec()")
+	w.line("if n := strings.Count(m.SQL(), \"?\"); n != len(args) {")
+	w.line("t.Fatalf(\"%%d placeholders for %%d arguments\", n, len(args))")
+	w.line("}")
+	w.line("")
+	w.line("data, err := m.MarshalBinary()")
+	w.line("if err != nil {")
+	w.line("t.Fatal(err)")
+	w.line("}")
+	w.line("got := &%s{}", s.name)
+	w.line("if err := got.UnmarshalBinary(data); err != nil {")
+	w.line("t.Fatal(err)")
+	w.line("}")
+	for _, f := range s.fields {
+		switch f.kind {
+		case kindBytes:
+			w.line("if !bytes.Equal(got.%s, m.%s) {", f.name, f.name)
+		case kindTime:
+			w.line("if !got.%s.Equal(m.%s) {", f.name, f.name)
+		default:
+			w.line("if got.%s != m.%s {", f.name, f.name)
+		}
+		w.line("t.Errorf(\"%s: got %%v, want %%v\", got.%s, m.%s)", f.name, f.name, f.name)
+		w.line("}")
+	}
+	w.line("")
+	w.line("if err := got.UnmarshalBinary(data[:len(data)-1]); err == nil {")
+	w.line("t.Error(\"short data is decoded\")")
+	w.line("}")
+	w.line("}")
+
+	return format.Source(w.Bytes())
+}
+
+func sample(f field) string {
+	switch f.kind {
+	case kindBool:
+		return "true"
+	case kindInt:
+		return f.goType + "(-7)"
+	case kindUint:
+		return f.goType + "(7)"
+	case kindFloat32, kindFloat64:
+		return f.goType + "(1.5)"
+	case kindString:
+		return `"test"`
+	case kindBytes:
+		return "[]byte{1, 2}"
+	case kindTime:
+		return "time.Date(2021, 4, 29, 20, 1, 34, 561, time.UTC)"
+	}
+	return ""
+}
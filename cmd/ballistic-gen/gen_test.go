@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ballistic-gen")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src, err := ioutil.ReadFile(filepath.Join("example", "event.go"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "event.go"), src, 0644))
+
+	require.NoError(t, run(dir, "Event", "test.table_1", "", true))
+
+	// The committed example is up to date
+	for _, name := range []string{"event_ballistic.go", "event_ballistic_test.go"} {
+		expected, err := ioutil.ReadFile(filepath.Join("example", name))
+		require.NoError(t, err)
+		actual, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(actual), name)
+	}
+}
+
+func TestParseStruct(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ballistic-gen")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "model.go"), []byte(`package model
+
+import t "time"
+
+type Model struct {
+	A, B   int    `+"`ch:\"a\"`"+`
+	Time   t.Time `+"`ch:\"time\"`"+`
+	Letter rune   `+"`ch:\"letter\"`"+`
+	hidden string `+"`ch:\"hidden\"`"+`
+	Free   string
+}
+
+type Empty struct {
+	A string
+}
+
+type Broken struct {
+	M map[string]int `+"`ch:\"m\"`"+`
+}
+
+type NotStruct int
+`), 0644))
+
+	s, err := parseStruct(dir, "Model")
+	require.NoError(t, err)
+	assert.Equal(t, "model", s.pkg)
+	assert.Equal(t, []field{
+		{name: "A", column: "a", goType: "int", kind: kindInt},
+		{name: "B", column: "a", goType: "int", kind: kindInt},
+		{name: "Time", column: "time", goType: "time.Time", kind: kindTime},
+		{name: "Letter", column: "letter", goType: "rune", kind: kindInt},
+	}, s.fields)
+
+	_, err = parseStruct(dir, "Empty")
+	assert.EqualError(t, err, `Empty has no fields tagged with "ch"`)
+	_, err = parseStruct(dir, "Broken")
+	assert.EqualError(t, err, "Broken.M: unsupported type map[string]int")
+	_, err = parseStruct(dir, "NotStruct")
+	assert.Error(t, err)
+	_, err = parseStruct(dir, "Missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+// Command ballistic-gen generates ballistic.DataModel methods for a struct with ch tags:
+// SQL, ToExec and a compact MarshalBinary/UnmarshalBinary without reflection or JSON,
+// plus a test checking them. The encoding is the one of the tagged package.
+//
+//	//go:generate ballistic-gen -type Event -table db.events
+//
+// writes event_ballistic.go and event_ballistic_test.go next to the source.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "struct type name")
+	table := flag.String("table", "", "table name of the INSERT statement")
+	dir := flag.String("dir", ".", "package directory")
+	output := flag.String("output", "", "output file (default <type>_ballistic.go)")
+	noTest := flag.Bool("notest", false, "don't generate the test")
+	flag.Parse()
+
+	if *typeName == "" || *table == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*dir, *typeName, *table, *output, !*noTest); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "ballistic-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, typeName, table, output string, withTest bool) error {
+	s, err := parseStruct(dir, typeName)
+	if err != nil {
+		return err
+	}
+
+	code, err := generate(s, table)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = filepath.Join(dir, strings.ToLower(typeName)+"_ballistic.go")
+	}
+	err = ioutil.WriteFile(output, code, 0644)
+	if err != nil {
+		return err
+	}
+
+	if !withTest {
+		return nil
+	}
+
+	test, err := generateTest(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(strings.TrimSuffix(output, ".go")+"_test.go", test, 0644)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// tagName is the struct tag holding the column name, the same as tagged.TagName.
+const tagName = "ch"
+
+type fieldKind int
+
+const (
+	kindBool fieldKind = iota
+	kindInt
+	kindUint
+	kindFloat32
+	kindFloat64
+	kindString
+	kindBytes
+	kindTime
+)
+
+type field struct {
+	name   string
+	column string
+	// goType is the declared type, the target of integer conversions.
+	goType string
+	kind   fieldKind
+}
+
+type structInfo struct {
+	pkg    string
+	name   string
+	fields []field
+}
+
+// parseStruct finds the type in the non-test files of the package directory.
+func parseStruct(dir, typeName string) (*structInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := parser.ParseFile(fset, path, src, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		spec := findType(f, typeName)
+		if spec == nil {
+			continue
+		}
+
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a struct", typeName)
+		}
+
+		s := &structInfo{
+			pkg:  f.Name.Name,
+			name: typeName,
+		}
+		s.fields, err = parseFields(st, timePackage(f))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%w", typeName, err)
+		}
+		if len(s.fields) == 0 {
+			return nil, fmt.Errorf("%s has no fields tagged with %q", typeName, tagName)
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("type %s not found in %s", typeName, dir)
+}
+
+func findType(f *ast.File, name string) *ast.TypeSpec {
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			if ts := spec.(*ast.TypeSpec); ts.Name.Name == name {
+				return ts
+			}
+		}
+	}
+	return nil
+}
+
+// timePackage returns the name the file imports the time package under.
+func timePackage(f *ast.File) string {
+	for _, imp := range f.Imports {
+		if path, _ := strconv.Unquote(imp.Path.Value); path != "time" {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+		return "time"
+	}
+	return ""
+}
+
+func parseFields(st *ast.StructType, timePkg string) ([]field, error) {
+	var fields []field
+	for _, f := range st.Fields.List {
+		if f.Tag == nil {
+			continue
+		}
+		tag, err := strconv.Unquote(f.Tag.Value)
+		if err != nil {
+			return nil, err
+		}
+		column, ok := reflect.StructTag(tag).Lookup(tagName)
+		if !ok || column == "-" {
+			continue
+		}
+
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", column)
+		}
+
+		goType, kind, err := kindOf(f.Type, timePkg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Names[0].Name, err)
+		}
+
+		for _, name := range f.Names {
+			if !name.IsExported() {
+				continue
+			}
+			fields = append(fields, field{
+				name:   name.Name,
+				column: column,
+				goType: goType,
+				kind:   kind,
+			})
+		}
+	}
+	return fields, nil
+}
+
+func kindOf(expr ast.Expr, timePkg string) (string, fieldKind, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "bool":
+			return t.Name, kindBool, nil
+		case "int", "int8", "int16", "int32", "int64", "rune":
+			return t.Name, kindInt, nil
+		case "uint", "uint8", "uint16", "uint32", "uint64", "byte":
+			return t.Name, kindUint, nil
+		case "float32":
+			return t.Name, kindFloat32, nil
+		case "float64":
+			return t.Name, kindFloat64, nil
+		case "string":
+			return t.Name, kindString, nil
+		}
+	case *ast.ArrayType:
+		if elem, ok := t.Elt.(*ast.Ident); ok && t.Len == nil && (elem.Name == "byte" || elem.Name == "uint8") {
+			return "[]byte", kindBytes, nil
+		}
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && timePkg != "" && pkg.Name == timePkg && t.Sel.Name == "Time" {
+			return "time.Time", kindTime, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unsupported type %s", types(expr))
+}
+
+// types prints a type expression for error messages.
+func types(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return types(t.X) + "." + t.Sel.Name
+	case *ast.StarExpr:
+		return "*" + types(t.X)
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "[]" + types(t.Elt)
+		}
+		return "[...]" + types(t.Elt)
+	case *ast.MapType:
+		return "map[" + types(t.Key) + "]" + types(t.Value)
+	}
+	return fmt.Sprintf("%T", expr)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	_ "github.com/ClickHouse/clickhouse-go"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/cli"
+	"os"
+)
+
+// main runs the cli without models, build your own main registering them to decode records, see cli.
+func main() {
+	if err := cli.Run(os.Args[1:], os.Stdout, ballistic.DefaultRegistry); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "ballistic:", err)
+		os.Exit(1)
+	}
+}
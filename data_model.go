@@ -2,6 +2,9 @@ package ballistic
 
 import "encoding"
 
+// DataModel is a row of the query returned by SQL.
+// The binary encoding is the one memory queues and size limits rely on,
+// rows without one are wrapped by a codec, see file.Coded.
 type DataModel interface {
 	encoding.BinaryMarshaler
 	encoding.BinaryUnmarshaler
@@ -9,3 +12,63 @@ type DataModel interface {
 	SQL() string
 	ToExec() []interface{}
 }
+
+// Wrapper is implemented by models wrapping a value of another type, like tagged rows.
+// Registries identify a wrapper by the type of the wrapped value and its query
+// and queues create empty models with New instead of the reflected type.
+type Wrapper interface {
+	Unwrap() interface{}
+	New() DataModel
+}
+
+// Priority orders the records of one query, higher priorities are sent first
+// and dropped last when a quota is exceeded.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// Prioritized is implemented by models of another priority than PriorityNormal.
+type Prioritized interface {
+	Priority() Priority
+}
+
+// PriorityOf returns the priority of the model, or of the value it wraps.
+func PriorityOf(model interface{}) Priority {
+	if p, ok := model.(Prioritized); ok {
+		return p.Priority()
+	}
+	if w, ok := model.(Wrapper); ok {
+		if p, ok := w.Unwrap().(Prioritized); ok {
+			return p.Priority()
+		}
+	}
+	return PriorityNormal
+}
+
+// Keyed is implemented by models with an idempotency key, records of the same key are duplicates.
+type Keyed interface {
+	IdempotencyKey() string
+}
+
+// KeySetter is implemented by keyed models whose key is not part of their binary form,
+// queues storing the key set it back on the ejected models.
+type KeySetter interface {
+	SetIdempotencyKey(key string)
+}
+
+// KeyOf returns the idempotency key of the model, or of the value it wraps, empty if it has none.
+func KeyOf(model interface{}) string {
+	if k, ok := model.(Keyed); ok {
+		return k.IdempotencyKey()
+	}
+	if w, ok := model.(Wrapper); ok {
+		if k, ok := w.Unwrap().(Keyed); ok {
+			return k.IdempotencyKey()
+		}
+	}
+	return ""
+}
@@ -1,11 +1,19 @@
 module github.com/farwydi/ballistic
 
-go 1.14
+go 1.18
 
 require (
 	github.com/ClickHouse/clickhouse-go v1.4.5
 	github.com/stretchr/testify v1.7.0
-	go.uber.org/multierr v1.7.0 // indirect
 	go.uber.org/zap v1.17.0
+)
+
+require (
+	github.com/cloudflare/golz4 v0.0.0-20150217214814-ef862a3cdc58 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.7.0 // indirect
 	gopkg.in/yaml.v2 v2.3.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 )
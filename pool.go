@@ -5,3 +5,10 @@ type Pool interface {
 	Push(model DataModel) error
 	Eject(limit int) (models []DataModel, err error)
 }
+
+// TypedPool is a Pool of models of type T.
+type TypedPool[T DataModel] interface {
+	Append(models []T) error
+	Push(model T) error
+	Eject(limit int) (models []T, err error)
+}
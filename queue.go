@@ -1,9 +1,147 @@
 package ballistic
 
-import "encoding"
+import (
+	"encoding"
+	"errors"
+	"fmt"
+)
+
+// ErrModelType is returned by the adapters for records of another type.
+var ErrModelType = errors.New("unexpected model type")
 
 type Queue interface {
 	Push(model encoding.BinaryMarshaler) error
 	Eject(limit int) (models []interface{}, err error)
 	Len() int
 }
+
+// TypedQueue is a Queue of records of type T.
+type TypedQueue[T any] interface {
+	Push(model T) error
+	Eject(limit int) (models []T, err error)
+	Len() int
+}
+
+// BatchQueue is a Queue pushing many records at once.
+// The errors are those of the models not pushed by index, nil if all were pushed.
+type BatchQueue interface {
+	PushAll(models []encoding.BinaryMarshaler) []error
+}
+
+// TypedBatchQueue is a TypedQueue pushing many records at once like BatchQueue.
+type TypedBatchQueue[T any] interface {
+	PushAll(models []T) []error
+}
+
+// PushAll pushes the models to the queue at once if it is a TypedBatchQueue, one by one otherwise.
+func PushAll[T any](queue TypedQueue[T], models []T) (errs []error) {
+	if b, ok := queue.(TypedBatchQueue[T]); ok {
+		return b.PushAll(models)
+	}
+	return pushEach(queue.Push, models)
+}
+
+func pushEach[T any](push func(model T) error, models []T) (errs []error) {
+	for i, model := range models {
+		if err := push(model); err != nil {
+			if errs == nil {
+				errs = make([]error, len(models))
+			}
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// Syncer is a queue committing the records pushed to stable storage.
+type Syncer interface {
+	Sync() error
+}
+
+// ErrNoSync is returned by the adapters syncing a queue that is not a Syncer.
+var ErrNoSync = errors.New("queue can't sync")
+
+// TypedAdapter adapts a Queue to a TypedQueue.
+// Ejected records of another type are dropped and reported by ErrModelType.
+type TypedAdapter[T encoding.BinaryMarshaler] struct {
+	Queue Queue
+}
+
+func (a TypedAdapter[T]) Push(model T) error {
+	return a.Queue.Push(model)
+}
+
+// PushAll pushes the models at once if the queue is a BatchQueue.
+func (a TypedAdapter[T]) PushAll(models []T) []error {
+	b, ok := a.Queue.(BatchQueue)
+	if !ok {
+		return pushEach(a.Push, models)
+	}
+
+	marshalers := make([]encoding.BinaryMarshaler, len(models))
+	for i, model := range models {
+		marshalers[i] = model
+	}
+	return b.PushAll(marshalers)
+}
+
+// Sync syncs the queue if it is a Syncer, see ErrNoSync.
+func (a TypedAdapter[T]) Sync() error {
+	if s, ok := a.Queue.(Syncer); ok {
+		return s.Sync()
+	}
+	return ErrNoSync
+}
+
+func (a TypedAdapter[T]) Eject(limit int) (models []T, err error) {
+	ejected, err := a.Queue.Eject(limit)
+	models = make([]T, 0, len(ejected))
+	for _, e := range ejected {
+		if e == nil {
+			continue
+		}
+		model, ok := e.(T)
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("%w: %T is not %T", ErrModelType, e, model)
+			}
+			continue
+		}
+		models = append(models, model)
+	}
+	return models, err
+}
+
+func (a TypedAdapter[T]) Len() int {
+	return a.Queue.Len()
+}
+
+// UntypedAdapter adapts a TypedQueue to a Queue, pushed models of another type are rejected with ErrModelType.
+type UntypedAdapter[T encoding.BinaryMarshaler] struct {
+	Queue TypedQueue[T]
+}
+
+func (a UntypedAdapter[T]) Push(model encoding.BinaryMarshaler) error {
+	typed, ok := model.(T)
+	if !ok {
+		return fmt.Errorf("%w: %T is not %T", ErrModelType, model, typed)
+	}
+	return a.Queue.Push(typed)
+}
+
+func (a UntypedAdapter[T]) Eject(limit int) (models []interface{}, err error) {
+	ejected, err := a.Queue.Eject(limit)
+	if len(ejected) == 0 {
+		return nil, err
+	}
+
+	models = make([]interface{}, len(ejected))
+	for i, model := range ejected {
+		models[i] = model
+	}
+	return models, err
+}
+
+func (a UntypedAdapter[T]) Len() int {
+	return a.Queue.Len()
+}
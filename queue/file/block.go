@@ -0,0 +1,110 @@
+package file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// A block payload follows the flags byte of its envelope: the uvarint number of records,
+// the uvarint size of the decompressed records, since format 4 the uvarint size
+// of the raw frames before the block it replaces, and the compressed records,
+// each an uvarint size and the record envelope.
+
+// maxBlockRecords limits the records of a block, the consumed ones are counted in the skip-ahead word.
+const maxBlockRecords = math.MaxUint16
+
+// maxReplaced bounds the raw frames a block replaces. The tail is compressed
+// once its records reach the block size and every frame has a payload byte.
+const maxReplaced = 3 * (MaxBlockSize + MetaElementSize + math.MaxUint16)
+
+type blockInfo struct {
+	count   int
+	rawSize int
+	// replaced is the size of the raw frames before the block holding its records.
+	replaced   int64
+	compressed []byte
+}
+
+func parseBlock(payload []byte, format int) (blockInfo, error) {
+	count, n := binary.Uvarint(payload)
+	if n <= 0 || count == 0 || count > maxBlockRecords {
+		return blockInfo{}, fmt.Errorf("%w: bad block record count", ErrInvalidFile)
+	}
+	payload = payload[n:]
+
+	rawSize, n := binary.Uvarint(payload)
+	if n <= 0 || rawSize > count*(math.MaxUint16+binary.MaxVarintLen64) {
+		return blockInfo{}, fmt.Errorf("%w: bad block size", ErrInvalidFile)
+	}
+	payload = payload[n:]
+
+	var replaced uint64
+	if format >= 4 {
+		replaced, n = binary.Uvarint(payload)
+		if n <= 0 || replaced > maxReplaced {
+			return blockInfo{}, fmt.Errorf("%w: bad replaced size", ErrInvalidFile)
+		}
+		payload = payload[n:]
+	}
+
+	return blockInfo{
+		count:      int(count),
+		rawSize:    int(rawSize),
+		replaced:   int64(replaced),
+		compressed: payload,
+	}, nil
+}
+
+// buildBlock returns the frame data of a compressed block of the records replacing
+// the raw frames of the size before it and the size of the records before compression.
+func buildBlock(records [][]byte, replaced int64, c Compressor) (data []byte, rawSize int, err error) {
+	var raw []byte
+	for _, rec := range records {
+		raw = appendUvarint(raw, uint64(len(rec)))
+		raw = append(raw, rec...)
+	}
+
+	compressed, err := c.Compress(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data = make([]byte, 0, 1+3*binary.MaxVarintLen64+len(compressed))
+	data = append(data, flagBlock)
+	data = appendUvarint(data, uint64(len(records)))
+	data = appendUvarint(data, uint64(len(raw)))
+	data = appendUvarint(data, uint64(replaced))
+	data = append(data, compressed...)
+	return data, len(raw), nil
+}
+
+// readBlock decompresses a block into its record envelopes.
+func readBlock(b blockInfo, c Compressor) ([][]byte, error) {
+	if c == nil {
+		return nil, fmt.Errorf("%w: compressed block in a file without compression", ErrInvalidFile)
+	}
+
+	raw, err := c.Decompress(b.compressed, b.rawSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: block: %v", ErrInvalidFile, err)
+	}
+	if len(raw) != b.rawSize {
+		return nil, fmt.Errorf("%w: block of %d bytes, expected %d", ErrInvalidFile, len(raw), b.rawSize)
+	}
+
+	records := make([][]byte, 0, b.count)
+	for len(raw) > 0 {
+		size, n := binary.Uvarint(raw)
+		if n <= 0 || uint64(len(raw)-n) < size {
+			return nil, fmt.Errorf("%w: truncated block record", ErrInvalidFile)
+		}
+		records = append(records, raw[n:n+int(size)])
+		raw = raw[n+int(size):]
+	}
+
+	if len(records) != b.count {
+		return nil, fmt.Errorf("%w: block of %d records, expected %d", ErrInvalidFile, len(records), b.count)
+	}
+	return records, nil
+}
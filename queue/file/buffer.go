@@ -4,6 +4,6 @@ import "sync"
 
 var bsPool = &sync.Pool{
 	New: func() interface{} {
-		return make([]byte, 4)
+		return make([]byte, CRC32HashSize+SkipAheadSize)
 	},
 }
@@ -0,0 +1,179 @@
+package file
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"sort"
+	"sync"
+)
+
+// Codec encodes the models pushed into a queue into record payloads.
+// The name of the codec is stored in the file header, so readers decode with the same codec.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into v, a pointer created like the queue pattern.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// Raw stores what MarshalBinary returns, models must be BinaryMarshalers.
+	// It is the codec of files without a codec in the header.
+	Raw Codec = rawCodec{}
+	// JSON encodes models with encoding/json.
+	JSON Codec = jsonCodec{}
+	// Gob encodes every record with its own encoding/gob stream.
+	Gob Codec = gobCodec{}
+	// Packed is a msgpack-like compact self-describing binary encoding, structs are encoded as arrays of exported fields.
+	Packed Codec = packedCodec{}
+	// Proto is the protobuf wire format, the exported fields of a struct are numbered from 1 in declaration order.
+	Proto Codec = protoCodec{}
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{
+	m: map[string]Codec{},
+}
+
+func init() {
+	for _, c := range []Codec{Raw, JSON, Gob, Packed, Proto} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec makes the codec available to read files written with it.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[c.Name()] = c
+}
+
+// CodecByName returns a registered codec, an empty name is Raw.
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		return Raw, nil
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownCodec, name)
+	}
+	return c, nil
+}
+
+// Codecs returns the names of the registered codecs.
+func Codecs() []string {
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	names := make([]string, 0, len(codecs.m))
+	for name := range codecs.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// marshal encodes v, codecs other than Raw encode the value wrapped by a ballistic.Wrapper.
+func marshal(codec Codec, v interface{}) ([]byte, error) {
+	if w, ok := v.(ballistic.Wrapper); ok && codec != Raw {
+		v = w.Unwrap()
+	}
+	return codec.Marshal(v)
+}
+
+// unmarshal decodes data into model, codecs other than Raw decode into the value wrapped by a ballistic.Wrapper.
+func unmarshal(codec Codec, data []byte, model interface{}) error {
+	if w, ok := model.(ballistic.Wrapper); ok && codec != Raw {
+		model = w.Unwrap()
+	}
+	return codec.Unmarshal(data, model)
+}
+
+// Decode decodes a record of a file with the header into a new model registered under name,
+// records of older versions are upcast first.
+func Decode(registry *ballistic.Registry, name string, header Header, rec Record) (ballistic.DataModel, error) {
+	codec, err := CodecByName(header.Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := registry.Upcast(name, rec.Version, rec.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := registry.New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unmarshal(codec, data, model)
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("raw codec: %T is not a BinaryMarshaler", v)
+	}
+	return m.MarshalBinary()
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("raw codec: %T is not a BinaryUnmarshaler", v)
+	}
+	return m.UnmarshalBinary(data)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,439 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Type tags of the packed codec, every value starts with one.
+const (
+	packedNil byte = iota
+	packedFalse
+	packedTrue
+	packedInt
+	packedUint
+	packedFloat32
+	packedFloat64
+	packedString
+	packedBytes
+	packedArray
+	packedMap
+	packedStruct
+	packedTime
+)
+
+var (
+	errPackedShort = errors.New("packed codec: short data")
+	fieldCache     sync.Map
+	timeType       = reflect.TypeOf(time.Time{})
+	bytesType      = reflect.TypeOf([]byte(nil))
+)
+
+type packedCodec struct{}
+
+func (packedCodec) Name() string {
+	return "packed"
+}
+
+func (packedCodec) Marshal(v interface{}) ([]byte, error) {
+	return appendPacked(make([]byte, 0, 64), reflect.ValueOf(v))
+}
+
+func (packedCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("packed codec: unmarshal into %T", v)
+	}
+
+	rest, err := decodePacked(data, rv.Elem())
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("packed codec: %d trailing bytes", len(rest))
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], x)]...)
+}
+
+func appendPacked(buf []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(buf, packedNil), nil
+	}
+
+	if v.Type() == timeType {
+		data, err := v.Interface().(time.Time).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, packedTime)
+		buf = appendUvarint(buf, uint64(len(data)))
+		return append(buf, data...), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(buf, packedNil), nil
+		}
+		return appendPacked(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, packedTrue), nil
+		}
+		return append(buf, packedFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var tmp [binary.MaxVarintLen64]byte
+		buf = append(buf, packedInt)
+		return append(buf, tmp[:binary.PutVarint(tmp[:], v.Int())]...), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUvarint(append(buf, packedUint), v.Uint()), nil
+	case reflect.Float32:
+		buf = append(buf, packedFloat32, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[len(buf)-4:], math.Float32bits(float32(v.Float())))
+		return buf, nil
+	case reflect.Float64:
+		buf = append(buf, packedFloat64, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], math.Float64bits(v.Float()))
+		return buf, nil
+	case reflect.String:
+		buf = appendUvarint(append(buf, packedString), uint64(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(buf, packedNil), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf = appendUvarint(append(buf, packedBytes), uint64(v.Len()))
+			return append(buf, v.Bytes()...), nil
+		}
+		fallthrough
+	case reflect.Array:
+		var err error
+		buf = appendUvarint(append(buf, packedArray), uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			buf, err = appendPacked(buf, v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(buf, packedNil), nil
+		}
+		var err error
+		buf = appendUvarint(append(buf, packedMap), uint64(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			buf, err = appendPacked(buf, iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			buf, err = appendPacked(buf, iter.Value())
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Struct:
+		var err error
+		fields := exportedFields(v.Type())
+		buf = appendUvarint(append(buf, packedStruct), uint64(len(fields)))
+		for _, i := range fields {
+			buf, err = appendPacked(buf, v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+
+	return nil, fmt.Errorf("packed codec: unsupported type %s", v.Type())
+}
+
+// decodePacked decodes the first value of data into v and returns the rest.
+func decodePacked(data []byte, v reflect.Value) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errPackedShort
+	}
+
+	tag := data[0]
+	if tag == packedNil {
+		v.Set(reflect.Zero(v.Type()))
+		return data[1:], nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodePacked(data, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return nil, fmt.Errorf("packed codec: unsupported type %s", v.Type())
+		}
+		x, rest, err := decodePackedAny(data)
+		if err != nil {
+			return nil, err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return rest, nil
+	}
+
+	data = data[1:]
+	switch tag {
+	case packedFalse, packedTrue:
+		if v.Kind() != reflect.Bool {
+			return nil, packedMismatch(tag, v.Type())
+		}
+		v.SetBool(tag == packedTrue)
+		return data, nil
+
+	case packedInt, packedUint:
+		var i int64
+		var u uint64
+		var n int
+		if tag == packedInt {
+			i, n = binary.Varint(data)
+			u = uint64(i)
+		} else {
+			u, n = binary.Uvarint(data)
+			i = int64(u)
+		}
+		if n <= 0 {
+			return nil, errPackedShort
+		}
+
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if (tag == packedUint && i < 0) || v.OverflowInt(i) {
+				return nil, fmt.Errorf("packed codec: %d overflows %s", u, v.Type())
+			}
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if (tag == packedInt && i < 0) || v.OverflowUint(u) {
+				return nil, fmt.Errorf("packed codec: %d overflows %s", i, v.Type())
+			}
+			v.SetUint(u)
+		case reflect.Float32, reflect.Float64:
+			if tag == packedInt {
+				v.SetFloat(float64(i))
+			} else {
+				v.SetFloat(float64(u))
+			}
+		default:
+			return nil, packedMismatch(tag, v.Type())
+		}
+		return data[n:], nil
+
+	case packedFloat32, packedFloat64:
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return nil, packedMismatch(tag, v.Type())
+		}
+		if tag == packedFloat32 {
+			if len(data) < 4 {
+				return nil, errPackedShort
+			}
+			v.SetFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(data))))
+			return data[4:], nil
+		}
+		if len(data) < 8 {
+			return nil, errPackedShort
+		}
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(data)))
+		return data[8:], nil
+
+	case packedString, packedBytes, packedTime:
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, errPackedShort
+		}
+		b := data[n : n+int(size)]
+		data = data[n+int(size):]
+
+		switch {
+		case tag == packedTime && v.Type() == timeType:
+			var t time.Time
+			if err := t.UnmarshalBinary(b); err != nil {
+				return nil, fmt.Errorf("packed codec: %w", err)
+			}
+			v.Set(reflect.ValueOf(t))
+		case tag != packedTime && v.Kind() == reflect.String:
+			v.SetString(string(b))
+		case tag != packedTime && v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), b...))
+		default:
+			return nil, packedMismatch(tag, v.Type())
+		}
+		return data, nil
+
+	case packedArray, packedStruct:
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			// Every element takes at least one byte
+			return nil, errPackedShort
+		}
+		data = data[n:]
+
+		var fields []int
+		switch {
+		case tag == packedStruct && v.Kind() == reflect.Struct:
+			fields = exportedFields(v.Type())
+		case tag == packedArray && v.Kind() == reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), int(size), int(size)))
+		case tag == packedArray && v.Kind() == reflect.Array:
+		default:
+			return nil, packedMismatch(tag, v.Type())
+		}
+
+		var err error
+		for i := 0; i < int(size); i++ {
+			var elem reflect.Value
+			switch {
+			case fields != nil && i < len(fields):
+				elem = v.Field(fields[i])
+			case fields == nil && i < v.Len():
+				elem = v.Index(i)
+			}
+
+			// Values without a place, like fields removed from the struct, are skipped
+			if !elem.IsValid() {
+				_, data, err = decodePackedAny(data)
+			} else {
+				data, err = decodePacked(data, elem)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+
+	case packedMap:
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < 2*size {
+			return nil, errPackedShort
+		}
+		data = data[n:]
+
+		if v.Kind() != reflect.Map {
+			return nil, packedMismatch(tag, v.Type())
+		}
+		m := reflect.MakeMapWithSize(v.Type(), int(size))
+		var err error
+		for i := 0; i < int(size); i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			data, err = decodePacked(data, key)
+			if err != nil {
+				return nil, err
+			}
+			if key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable() {
+				return nil, fmt.Errorf("packed codec: map key %s is not comparable", key.Elem().Type())
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			data, err = decodePacked(data, value)
+			if err != nil {
+				return nil, err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("packed codec: unknown tag %d", tag)
+}
+
+func packedMismatch(tag byte, t reflect.Type) error {
+	return fmt.Errorf("packed codec: can't decode tag %d into %s", tag, t)
+}
+
+// decodePackedAny decodes the first value of data without a target type:
+// integers are int64 or uint64, arrays and structs []interface{}, maps map[interface{}]interface{}.
+func decodePackedAny(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errPackedShort
+	}
+
+	var v reflect.Value
+	switch data[0] {
+	case packedNil:
+		return nil, data[1:], nil
+	case packedFalse, packedTrue:
+		v = reflect.New(reflect.TypeOf(false)).Elem()
+	case packedInt:
+		v = reflect.New(reflect.TypeOf(int64(0))).Elem()
+	case packedUint:
+		v = reflect.New(reflect.TypeOf(uint64(0))).Elem()
+	case packedFloat32:
+		v = reflect.New(reflect.TypeOf(float32(0))).Elem()
+	case packedFloat64:
+		v = reflect.New(reflect.TypeOf(float64(0))).Elem()
+	case packedString:
+		v = reflect.New(reflect.TypeOf("")).Elem()
+	case packedBytes:
+		v = reflect.New(bytesType).Elem()
+	case packedTime:
+		v = reflect.New(timeType).Elem()
+	case packedArray, packedStruct:
+		return decodePackedList(data)
+	case packedMap:
+		v = reflect.New(reflect.TypeOf(map[interface{}]interface{}(nil))).Elem()
+	default:
+		return nil, nil, fmt.Errorf("packed codec: unknown tag %d", data[0])
+	}
+
+	rest, err := decodePacked(data, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v.Interface(), rest, nil
+}
+
+func decodePackedList(data []byte) (interface{}, []byte, error) {
+	size, n := binary.Uvarint(data[1:])
+	if n <= 0 || uint64(len(data)-1-n) < size {
+		return nil, nil, errPackedShort
+	}
+	data = data[1+n:]
+
+	list := make([]interface{}, size)
+	var err error
+	for i := range list {
+		list[i], data, err = decodePackedAny(data)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return list, data, nil
+}
+
+// exportedFields returns the indexes of the exported fields of a struct type.
+func exportedFields(t reflect.Type) []int {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]int)
+	}
+
+	fields := make([]int, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			fields = append(fields, i)
+		}
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,337 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Wire types of the protobuf encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtoShort = errors.New("proto codec: short data")
+
+// protoCodec encodes structs as protobuf messages. Field numbers follow the declaration order
+// of the exported fields, so new fields must be appended. Signed integers are zigzag encoded
+// like sint64, times are bytes of MarshalBinary, slices are unpacked repeated fields
+// and maps are repeated entries with the key in field 1 and the value in field 2.
+// Zero values are omitted and unknown fields skipped.
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("proto codec: %T is not a struct", v)
+	}
+	return appendMessage(make([]byte, 0, 64), rv)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("proto codec: %T is not a pointer to a struct", v)
+	}
+	rv = rv.Elem()
+	rv.Set(reflect.Zero(rv.Type()))
+	return decodeMessage(data, rv)
+}
+
+func appendKey(buf []byte, num, wire int) []byte {
+	return appendUvarint(buf, uint64(num)<<3|uint64(wire))
+}
+
+func appendMessage(buf []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for i, index := range exportedFields(v.Type()) {
+		f := v.Field(index)
+		num := i + 1
+		if f.IsZero() {
+			continue
+		}
+
+		switch {
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8:
+			for j := 0; j < f.Len(); j++ {
+				buf, err = appendProtoField(buf, num, f.Index(j))
+				if err != nil {
+					return nil, err
+				}
+			}
+		case f.Kind() == reflect.Map:
+			iter := f.MapRange()
+			for iter.Next() {
+				entry, err := appendProtoField(nil, 1, iter.Key())
+				if err != nil {
+					return nil, err
+				}
+				entry, err = appendProtoField(entry, 2, iter.Value())
+				if err != nil {
+					return nil, err
+				}
+				buf = appendUvarint(appendKey(buf, num, wireBytes), uint64(len(entry)))
+				buf = append(buf, entry...)
+			}
+		default:
+			buf, err = appendProtoField(buf, num, f)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+func appendProtoField(buf []byte, num int, v reflect.Value) ([]byte, error) {
+	if v.Type() == timeType {
+		data, err := v.Interface().(time.Time).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = appendUvarint(appendKey(buf, num, wireBytes), uint64(len(data)))
+		return append(buf, data...), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return buf, nil
+		}
+		return appendProtoField(buf, num, v.Elem())
+	case reflect.Bool:
+		var x uint64
+		if v.Bool() {
+			x = 1
+		}
+		return appendUvarint(appendKey(buf, num, wireVarint), x), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x := v.Int()
+		return appendUvarint(appendKey(buf, num, wireVarint), uint64(x<<1)^uint64(x>>63)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUvarint(appendKey(buf, num, wireVarint), v.Uint()), nil
+	case reflect.Float32:
+		buf = append(appendKey(buf, num, wireFixed32), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(buf[len(buf)-4:], math.Float32bits(float32(v.Float())))
+		return buf, nil
+	case reflect.Float64:
+		buf = append(appendKey(buf, num, wireFixed64), 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(buf[len(buf)-8:], math.Float64bits(v.Float()))
+		return buf, nil
+	case reflect.String:
+		buf = appendUvarint(appendKey(buf, num, wireBytes), uint64(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		buf = appendUvarint(appendKey(buf, num, wireBytes), uint64(v.Len()))
+		return append(buf, v.Bytes()...), nil
+	case reflect.Struct:
+		msg, err := appendMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendUvarint(appendKey(buf, num, wireBytes), uint64(len(msg)))
+		return append(buf, msg...), nil
+	}
+
+	return nil, fmt.Errorf("proto codec: unsupported type %s", v.Type())
+}
+
+// protoValue is a field read from the wire, x holds varints and fixed values, b the bytes.
+type protoValue struct {
+	num  int
+	wire int
+	x    uint64
+	b    []byte
+}
+
+// walkMessage calls fn for every field of a message.
+func walkMessage(data []byte, fn func(f protoValue) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoShort
+		}
+		data = data[n:]
+
+		f := protoValue{
+			num:  int(key >> 3),
+			wire: int(key & 7),
+		}
+		switch f.wire {
+		case wireVarint:
+			f.x, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errProtoShort
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errProtoShort
+			}
+			f.x = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errProtoShort
+			}
+			f.x = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errProtoShort
+			}
+			f.b = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return fmt.Errorf("proto codec: unsupported wire type %d", f.wire)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeMessage(data []byte, v reflect.Value) error {
+	fields := exportedFields(v.Type())
+	return walkMessage(data, func(pv protoValue) error {
+		if pv.num < 1 || pv.num > len(fields) {
+			return nil
+		}
+
+		f := v.Field(fields[pv.num-1])
+		switch {
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8:
+			elem := reflect.New(f.Type().Elem()).Elem()
+			if err := setProto(elem, pv); err != nil {
+				return err
+			}
+			f.Set(reflect.Append(f, elem))
+			return nil
+		case f.Kind() == reflect.Map:
+			if pv.wire != wireBytes {
+				return protoMismatch(pv, f.Type())
+			}
+			if f.IsNil() {
+				f.Set(reflect.MakeMap(f.Type()))
+			}
+			key := reflect.New(f.Type().Key()).Elem()
+			value := reflect.New(f.Type().Elem()).Elem()
+			err := walkMessage(pv.b, func(entry protoValue) error {
+				switch entry.num {
+				case 1:
+					return setProto(key, entry)
+				case 2:
+					return setProto(value, entry)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			f.SetMapIndex(key, value)
+			return nil
+		}
+		return setProto(f, pv)
+	})
+}
+
+func setProto(v reflect.Value, pv protoValue) error {
+	if v.Type() == timeType {
+		if pv.wire != wireBytes {
+			return protoMismatch(pv, v.Type())
+		}
+		var t time.Time
+		if err := t.UnmarshalBinary(pv.b); err != nil {
+			return fmt.Errorf("proto codec: %w", err)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setProto(v.Elem(), pv)
+	case reflect.Bool:
+		if pv.wire != wireVarint {
+			break
+		}
+		v.SetBool(pv.x != 0)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if pv.wire != wireVarint {
+			break
+		}
+		x := int64(pv.x>>1) ^ -int64(pv.x&1)
+		if v.OverflowInt(x) {
+			return fmt.Errorf("proto codec: field %d: %d overflows %s", pv.num, x, v.Type())
+		}
+		v.SetInt(x)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if pv.wire != wireVarint {
+			break
+		}
+		if v.OverflowUint(pv.x) {
+			return fmt.Errorf("proto codec: field %d: %d overflows %s", pv.num, pv.x, v.Type())
+		}
+		v.SetUint(pv.x)
+		return nil
+	case reflect.Float32:
+		if pv.wire != wireFixed32 {
+			break
+		}
+		v.SetFloat(float64(math.Float32frombits(uint32(pv.x))))
+		return nil
+	case reflect.Float64:
+		if pv.wire != wireFixed64 {
+			break
+		}
+		v.SetFloat(math.Float64frombits(pv.x))
+		return nil
+	case reflect.String:
+		if pv.wire != wireBytes {
+			break
+		}
+		v.SetString(string(pv.b))
+		return nil
+	case reflect.Slice:
+		if pv.wire != wireBytes || v.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		v.SetBytes(append([]byte(nil), pv.b...))
+		return nil
+	case reflect.Struct:
+		if pv.wire != wireBytes {
+			break
+		}
+		return decodeMessage(pv.b, v)
+	}
+
+	return protoMismatch(pv, v.Type())
+}
+
+func protoMismatch(pv protoValue, t reflect.Type) error {
+	return fmt.Errorf("proto codec: field %d of wire type %d can't be decoded into %s", pv.num, pv.wire, t)
+}
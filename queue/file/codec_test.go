@@ -0,0 +1,252 @@
+package file
+
+import (
+	"github.com/farwydi/ballistic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type codecNested struct {
+	Name string
+	Tags []string
+}
+
+// codecStruct is not a BinaryMarshaler.
+type codecStruct struct {
+	Time    time.Time
+	Bool    bool
+	Int     int
+	Int8    int8
+	Uint16  uint16
+	Float32 float32
+	Float64 float64
+	String  string
+	Bytes   []byte
+	Ints    []int64
+	Map     map[string]int
+	Nested  codecNested
+	Ptr     *codecNested
+	hidden  int
+}
+
+func TestCodecs(t *testing.T) {
+	v := &codecStruct{
+		Time:    time.Date(2021, 4, 29, 20, 1, 34, 561, time.UTC),
+		Bool:    true,
+		Int:     -1 << 40,
+		Int8:    -7,
+		Uint16:  65535,
+		Float32: 1.5,
+		Float64: -2.25,
+		String:  "test",
+		Bytes:   []byte{0, 1, 2},
+		Ints:    []int64{1, -1, 0},
+		Map:     map[string]int{"a": 1, "b": 0},
+		Nested:  codecNested{Name: "nested", Tags: []string{"x", ""}},
+		Ptr:     &codecNested{Name: "ptr"},
+		hidden:  1,
+	}
+	expected := *v
+	expected.hidden = 0
+
+	for _, codec := range []Codec{JSON, Gob, Packed, Proto} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			data, err := codec.Marshal(v)
+			require.NoError(t, err)
+
+			decoded := &codecStruct{}
+			require.NoError(t, codec.Unmarshal(data, decoded))
+			assert.True(t, expected.Time.Equal(decoded.Time))
+			decoded.Time = expected.Time
+			assert.Equal(t, &expected, decoded)
+
+			if codec != Gob {
+				assert.Error(t, codec.Unmarshal(data[:len(data)-1], &codecStruct{}))
+			}
+		})
+	}
+
+	_, err := Raw.Marshal(v)
+	assert.Error(t, err)
+	_, err = Proto.Marshal(1)
+	assert.Error(t, err)
+	_, err = Packed.Marshal(make(chan int))
+	assert.Error(t, err)
+}
+
+func TestPackedSchemaChange(t *testing.T) {
+	type v1 struct {
+		A int
+		B string
+		C []int
+	}
+	type v2 struct {
+		A int64
+		B string
+	}
+
+	for _, codec := range []Codec{Packed, Proto} {
+		data, err := codec.Marshal(&v1{A: 1, B: "b", C: []int{1, 2}})
+		require.NoError(t, err)
+
+		// Removed fields are skipped, added ones stay zero
+		var decoded v2
+		require.NoError(t, codec.Unmarshal(data, &decoded), codec.Name())
+		assert.Equal(t, v2{A: 1, B: "b"}, decoded, codec.Name())
+
+		var grown struct {
+			A int
+			B string
+			C []int
+			D float64
+		}
+		require.NoError(t, codec.Unmarshal(data, &grown), codec.Name())
+		assert.Equal(t, []int{1, 2}, grown.C, codec.Name())
+	}
+
+	data, err := Packed.Marshal(&v1{A: -1})
+	require.NoError(t, err)
+	var unsigned struct{ A uint }
+	assert.Error(t, Packed.Unmarshal(data, &unsigned))
+	var wrong struct{ A string }
+	assert.Error(t, Packed.Unmarshal(data, &wrong))
+}
+
+func TestQueueCodec(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	path := filepath.Join(tempDir, "test_0.bd")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// A raw queue needs BinaryUnmarshalers
+	_, err = NewQueue(f, &codecStruct{})
+	assert.Error(t, err)
+
+	q, err := NewQueue(f, &codecStruct{}, Config{Codec: Packed})
+	require.NoError(t, err)
+	assert.Equal(t, "packed", q.Header().Codec)
+	require.NoError(t, q.PushValue(&codecStruct{Int: 1}))
+	require.NoError(t, q.PushValue(&codecStruct{Int: 2}))
+
+	// The codec of the file wins over the config
+	q, err = NewQueue(f, &codecStruct{}, Config{Codec: JSON})
+	require.NoError(t, err)
+	assert.Equal(t, Packed, q.codec)
+	require.NoError(t, q.PushValue(&codecStruct{Int: 3}))
+
+	models, err := q.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 3)
+	for i, m := range models {
+		assert.Equal(t, i+1, m.(*codecStruct).Int)
+	}
+
+	header, err := ReadHeader(path)
+	require.NoError(t, err)
+	assert.Equal(t, "packed", header.Codec)
+
+	// Registered models are decoded with the codec of the header
+	other, err := os.OpenFile(filepath.Join(tempDir, "other_0.bd"), os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer other.Close()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("test", 1, func() ballistic.DataModel {
+		return &testStruct{}
+	}))
+	q, err = NewQueue(other, &testStruct{}, Config{Registry: registry, Codec: Proto})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&testStruct{M: 5}))
+
+	_, err = WalkFile(other.Name(), func(rec Record) error {
+		model, err := Decode(registry, "test", q.Header(), rec)
+		require.NoError(t, err)
+		assert.Equal(t, &testStruct{M: 5}, model)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A file of an unknown codec can't be opened
+	unknown := filepath.Join(tempDir, "unknown_0.bd")
+	u, err := os.OpenFile(unknown, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer u.Close()
+	_, err = writeHead(u, Header{Codec: "unknown"})
+	require.NoError(t, err)
+	_, err = NewQueue(u, &testStruct{})
+	assert.ErrorIs(t, err, ErrUnknownCodec)
+}
+
+func (c *codecStruct) SQL() string {
+	return "test"
+}
+
+func (c *codecStruct) ToExec() []interface{} {
+	return []interface{}{c.Int}
+}
+
+func TestCoded(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	for _, codec := range []Codec{Raw, Packed} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			f, err := os.OpenFile(filepath.Join(tempDir, codec.Name()+"_0.bd"), os.O_CREATE|os.O_RDWR, os.ModePerm)
+			require.NoError(t, err)
+			defer f.Close()
+
+			registry := ballistic.NewRegistry()
+			require.NoError(t, registry.Register("test", 1, CodedFactory(&codecStruct{}, Packed)))
+			model, err := registry.New("test")
+			require.NoError(t, err)
+
+			q, err := NewQueue(f, model, Config{Registry: registry, Codec: codec})
+			require.NoError(t, err)
+			require.NoError(t, q.Push(NewCoded(&codecStruct{Int: 1, String: "a"}, Packed)))
+			assert.Equal(t, "test", q.Header().Model)
+
+			models, err := q.Eject(-1)
+			require.NoError(t, err)
+			require.Len(t, models, 1)
+			coded := models[0].(*Coded)
+			assert.Equal(t, &codecStruct{Int: 1, String: "a"}, coded.Unwrap())
+			assert.Equal(t, []interface{}{1}, coded.ToExec())
+		})
+	}
+
+	// The binary form is the one of the codec, JSON by default
+	data, err := NewCoded(&codecStruct{Int: 2}, nil).MarshalBinary()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Int":2`)
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	v := &codecStruct{
+		Time:   time.Now(),
+		Int:    42,
+		String: "benchmark",
+		Bytes:  []byte{1, 2, 3},
+	}
+	for _, codec := range []Codec{JSON, Gob, Packed, Proto} {
+		b.Run(codec.Name(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = codec.Marshal(v)
+			}
+		})
+	}
+}
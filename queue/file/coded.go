@@ -0,0 +1,61 @@
+package file
+
+import (
+	"github.com/farwydi/ballistic"
+	"reflect"
+)
+
+// Row is a row of a query without a binary encoding of its own, see Coded.
+type Row interface {
+	SQL() string
+	ToExec() []interface{}
+}
+
+// Coded makes a model of a row encoded by a codec, so rows don't implement
+// the BinaryMarshaler of ballistic.DataModel. The row is a pointer to a struct.
+// File queues of another codec than Raw encode the row with their own codec.
+type Coded struct {
+	row   Row
+	codec Codec
+}
+
+// NewCoded wraps the pointer to the row into a model encoded by the codec, JSON if it is nil or Raw.
+func NewCoded(row Row, codec Codec) *Coded {
+	if codec == nil || codec == Raw {
+		codec = JSON
+	}
+	return &Coded{row: row, codec: codec}
+}
+
+// CodedFactory returns a registry factory of empty rows of the type of row encoded by the codec.
+func CodedFactory(row Row, codec Codec) ballistic.ModelFactory {
+	c := NewCoded(row, codec)
+	return c.New
+}
+
+// Unwrap returns the row, it identifies the row type in a registry.
+func (c *Coded) Unwrap() interface{} {
+	return c.row
+}
+
+// New returns an empty row of the same type and codec.
+func (c *Coded) New() ballistic.DataModel {
+	row := reflect.New(reflect.TypeOf(c.row).Elem()).Interface().(Row)
+	return &Coded{row: row, codec: c.codec}
+}
+
+func (c *Coded) SQL() string {
+	return c.row.SQL()
+}
+
+func (c *Coded) ToExec() []interface{} {
+	return c.row.ToExec()
+}
+
+func (c *Coded) MarshalBinary() ([]byte, error) {
+	return c.codec.Marshal(c.row)
+}
+
+func (c *Coded) UnmarshalBinary(data []byte) error {
+	return c.codec.Unmarshal(data, c.row)
+}
@@ -0,0 +1,142 @@
+package file
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// Compressor compresses blocks of records.
+// The name of the compressor is stored in the file header, so readers decompress with the same one.
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	// Decompress returns at most limit bytes, more is an error.
+	Decompress(data []byte, limit int) ([]byte, error)
+}
+
+var (
+	// Flate compresses blocks with compress/flate at the best speed.
+	Flate Compressor = flateCompressor{}
+	// Gzip compresses blocks with compress/gzip at the default level.
+	Gzip Compressor = gzipCompressor{}
+)
+
+var compressors = struct {
+	sync.RWMutex
+	m map[string]Compressor
+}{
+	m: map[string]Compressor{},
+}
+
+func init() {
+	for _, c := range []Compressor{Flate, Gzip} {
+		RegisterCompressor(c)
+	}
+}
+
+// RegisterCompressor makes the compressor available to read files written with it.
+func RegisterCompressor(c Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.m[c.Name()] = c
+}
+
+// CompressorByName returns a registered compressor, an empty name is no compression and returns nil.
+func CompressorByName(name string) (Compressor, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	compressors.RLock()
+	defer compressors.RUnlock()
+	c, ok := compressors.m[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownCompressor, name)
+	}
+	return c, nil
+}
+
+// Compressors returns the names of the registered compressors.
+func Compressors() []string {
+	compressors.RLock()
+	defer compressors.RUnlock()
+
+	names := make([]string, 0, len(compressors.m))
+	for name := range compressors.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readLimited reads r up to limit bytes.
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("decompressed block exceeds %d bytes", limit)
+	}
+	return data, nil
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Name() string {
+	return "flate"
+}
+
+func (flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readLimited(r, limit)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, limit)
+}
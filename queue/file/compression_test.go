@@ -0,0 +1,228 @@
+package file
+
+import (
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func ejectM(t *testing.T, q *Queue, limit int) []int {
+	models, err := q.Eject(limit)
+	require.NoError(t, err)
+	ms := make([]int, len(models))
+	for i, m := range models {
+		ms[i] = m.(*testStruct).M
+	}
+	return ms
+}
+
+func seq(from, to int) []int {
+	var s []int
+	for i := from; i < to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+func TestCompressedQueue(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	for _, compressor := range []Compressor{Flate, Gzip} {
+		t.Run(compressor.Name(), func(t *testing.T) {
+			path := filepath.Join(tempDir, compressor.Name()+"_0.bd")
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+			require.NoError(t, err)
+			defer f.Close()
+
+			cfg := Config{Compressor: compressor, BlockSize: 1024}
+			q, err := NewQueue(f, &testStruct{}, cfg)
+			require.NoError(t, err)
+			assert.Equal(t, compressor.Name(), q.Header().Compression)
+
+			for i := 0; i < 300; i++ {
+				require.NoError(t, q.Push(&testStruct{M: i}))
+			}
+			assert.Equal(t, 300, q.Len())
+			assert.Greater(t, q.CompressionRatio(), 2.0)
+
+			info, err := WalkFile(path, nil)
+			require.NoError(t, err)
+			assert.True(t, info.Valid())
+			assert.Greater(t, info.Blocks, 1)
+			assert.Equal(t, 300, info.Records)
+			assert.Equal(t, 300, info.Pending)
+
+			// Eject stops inside a block and goes on from there after a reopen
+			assert.Equal(t, seq(0, 7), ejectM(t, q, 7))
+			info, err = WalkFile(path, nil)
+			require.NoError(t, err)
+			assert.Equal(t, 7, info.SkipRecords)
+			assert.Equal(t, 293, info.Pending)
+
+			q, err = NewQueue(f, &testStruct{}, cfg)
+			require.NoError(t, err)
+			assert.Equal(t, 293, q.Len())
+			assert.Equal(t, seq(7, 150), ejectM(t, q, 143))
+			assert.Equal(t, seq(150, 300), ejectM(t, q, -1))
+			assert.Equal(t, 0, q.Len())
+
+			// Consumed raw records are dropped when the tail is compressed
+			for i := 300; i < 310; i++ {
+				require.NoError(t, q.Push(&testStruct{M: i}))
+			}
+			assert.Equal(t, seq(300, 305), ejectM(t, q, 5))
+			for i := 310; i < 400; i++ {
+				require.NoError(t, q.Push(&testStruct{M: i}))
+			}
+			q, err = NewQueue(f, &testStruct{}, cfg)
+			require.NoError(t, err)
+			assert.Equal(t, 95, q.Len())
+			assert.Equal(t, seq(305, 400), ejectM(t, q, -1))
+
+			before, after, err := Compact(path)
+			require.NoError(t, err)
+			assert.Less(t, after, before)
+		})
+	}
+}
+
+func TestCompressionFallback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	// Random records don't compress and stay raw
+	f, err := os.OpenFile(filepath.Join(tempDir, "random_0.bd"), os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer f.Close()
+	q, err := NewQueue(f, &raw{}, Config{Compressor: Flate, BlockSize: 256})
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		data := make([]byte, 100)
+		_, err = rand.Read(data)
+		require.NoError(t, err)
+		require.NoError(t, q.Push(raw(data)))
+	}
+	info, err := WalkFile(f.Name(), nil)
+	require.NoError(t, err)
+	assert.True(t, info.Valid())
+	assert.Equal(t, 0, info.Blocks)
+	assert.Equal(t, 1.0, q.CompressionRatio())
+
+	// Salvage keeps the compressor of the source
+	path := filepath.Join(tempDir, "salvage_0.bd")
+	g, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	q, err = NewQueue(g, &testStruct{}, Config{Compressor: Flate, BlockSize: 256})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, q.Push(&testStruct{M: i}))
+	}
+	require.NoError(t, g.Close())
+
+	copied := filepath.Join(tempDir, "copy_0.bd")
+	info, err = Salvage(path, copied)
+	require.NoError(t, err)
+	assert.Equal(t, 100, info.Pending)
+	header, err := ReadHeader(copied)
+	require.NoError(t, err)
+	assert.Equal(t, "flate", header.Compression)
+
+	// A file of an unknown compressor can't be opened
+	u, err := os.OpenFile(filepath.Join(tempDir, "unknown_0.bd"), os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer u.Close()
+	_, err = writeHead(u, Header{Compression: "unknown"})
+	require.NoError(t, err)
+	_, err = NewQueue(u, &testStruct{})
+	assert.ErrorIs(t, err, ErrUnknownCompressor)
+}
+
+func TestBrokenBlock(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tempFile.Close())
+		assert.NoError(t, os.Remove(tempFile.Name()))
+		assert.NoError(t, os.Remove(tempFile.Name()+QuarantineSuffix))
+	}()
+
+	q, err := NewQueue(tempFile, &testStruct{}, Config{Compressor: Flate, BlockSize: 512})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, q.Push(&testStruct{M: i}))
+	}
+	require.Equal(t, "flate", q.Header().Compression)
+
+	// Corrupt the compressed data of the first block
+	info, err := WalkFile(tempFile.Name(), nil)
+	require.NoError(t, err)
+	require.Greater(t, info.Blocks, 0)
+	_, err = tempFile.WriteAt([]byte(strings.Repeat("\xff", 4)), info.SkipAhead+8)
+	require.NoError(t, err)
+
+	models, err := q.Eject(-1)
+	require.NoError(t, err)
+	assert.Less(t, len(models), 100)
+	assert.Equal(t, 0, q.Len())
+	quarantined, err := ReadQuarantine(tempFile.Name() + QuarantineSuffix)
+	require.NoError(t, err)
+	assert.Len(t, quarantined, 1)
+}
+
+func TestCompressTailCrash(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tempFile.Close())
+		assert.NoError(t, os.Remove(tempFile.Name()))
+	}()
+
+	cfg := Config{Compressor: Flate, BlockSize: 512}
+	q, err := NewQueue(tempFile, &testStruct{}, cfg)
+	require.NoError(t, err)
+
+	// Fill the tail without compressing it
+	q.blockSize = MaxBlockSize
+	for i := 0; i < 40; i++ {
+		require.NoError(t, q.Push(&testStruct{M: i}))
+	}
+	words := make([]byte, CRC32HashSize+SkipAheadSize)
+	_, err = tempFile.ReadAt(words, CRC32HashOffset)
+	require.NoError(t, err)
+	stat, err := tempFile.Stat()
+	require.NoError(t, err)
+
+	// The block is written after the raw records it replaces
+	require.NoError(t, q.compressTail(make([]byte, CRC32HashSize+SkipAheadSize)))
+	info, err := WalkFile(tempFile.Name(), nil)
+	require.NoError(t, err)
+	assert.True(t, info.Valid())
+	assert.Equal(t, 1, info.Blocks)
+	assert.Equal(t, 40, info.Records)
+	assert.Greater(t, info.Size, stat.Size())
+
+	// A crash before the checksum and the skip-ahead are written keeps the raw records
+	_, err = tempFile.WriteAt(words, CRC32HashOffset)
+	require.NoError(t, err)
+	q, err = NewQueue(tempFile, &testStruct{}, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, 40, q.Len())
+	assert.Equal(t, seq(0, 40), ejectM(t, q, -1))
+	info, err = WalkFile(tempFile.Name(), nil)
+	require.NoError(t, err)
+	assert.True(t, info.Valid())
+	assert.Equal(t, 0, info.Blocks)
+	assert.Equal(t, stat.Size(), info.Size)
+}
@@ -1,15 +1,40 @@
 package file
 
+import "github.com/farwydi/ballistic"
+
 // Config defines the config for file queue.
 type Config struct {
 	Workspace  string
 	MaxHistory int
+	// Registry names the models in the file header and upcasts records of older versions.
+	Registry *ballistic.Registry
+	// Quarantine keeps the records that can't be decoded,
+	// by default they are appended to the queue file name with QuarantineSuffix.
+	Quarantine Quarantine
+	// Codec encodes the records of new files, Raw by default.
+	// Existing files are read and written with the codec stored in their header.
+	Codec Codec
+	// Compressor compresses the records of new files in blocks, nil keeps them uncompressed.
+	// Like the codec, existing files keep the compressor stored in their header.
+	Compressor Compressor
+	// Keys encrypts the records with AES-GCM, nil keeps them in plain.
+	// Existing files encrypted with another key than the current one are rewritten with it on open.
+	Keys KeyProvider
+	// Suffix is appended to the queue name, so a query has several queues, like one per priority.
+	Suffix string
+	// BlockSize is the size of the records compressed into one block, at most MaxBlockSize.
+	BlockSize int
 }
 
+// MaxBlockSize is the largest BlockSize, a compressed block must fit into a record frame.
+const MaxBlockSize = 48 << 10
+
 // ConfigDefault is the default config
 var ConfigDefault = Config{
 	Workspace:  "/tmp",
 	MaxHistory: 3,
+	Codec:      Raw,
+	BlockSize:  16 << 10,
 }
 
 // Helper function to set default values
@@ -30,5 +55,17 @@ func configDefault(config ...Config) Config {
 		cfg.MaxHistory = ConfigDefault.MaxHistory
 	}
 
+	if cfg.Codec == nil {
+		cfg.Codec = ConfigDefault.Codec
+	}
+
+	if cfg.BlockSize <= 0 {
+		cfg.BlockSize = ConfigDefault.BlockSize
+	}
+
+	if cfg.BlockSize > MaxBlockSize {
+		cfg.BlockSize = MaxBlockSize
+	}
+
 	return cfg
 }
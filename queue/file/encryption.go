@@ -0,0 +1,105 @@
+package file
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// KeyProvider supplies the AES keys encrypting queue files. The id of the key of a file
+// is stored in its header, so files written with an old key stay readable while the key is provided.
+type KeyProvider interface {
+	// CurrentKey returns the key new records are encrypted with.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the id.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider of fixed keys, keys are 16, 24 or 32 bytes long.
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (s StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := s.Key(s.Current)
+	return s.Current, key, err
+}
+
+func (s StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := s.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// newAEAD returns the AES-GCM cipher of the key with the id, the provider may be nil for unencrypted files.
+func newAEAD(keys KeyProvider, id string) (cipher.AEAD, error) {
+	if id == "" {
+		return nil, nil
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("%w: file is encrypted with '%s', no keys configured", ErrUnknownKey, id)
+	}
+
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("key '%s': %w", id, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// newFileID returns a random id of an encrypted file.
+func newFileID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// recordAAD returns the additional data of the record at offset, it binds the record
+// to the header, so to the file id and the key id, and to its position in the file.
+// Files without an id were encrypted before records were bound, their records have none.
+func recordAAD(header Header, raw []byte, offset int64) []byte {
+	if header.FileID == "" {
+		return nil
+	}
+	aad := make([]byte, len(raw)+8)
+	copy(aad, raw)
+	binary.BigEndian.PutUint64(aad[len(raw):], uint64(offset))
+	return aad
+}
+
+// seal appends the random nonce and the encrypted data to dst.
+func seal(aead cipher.AEAD, dst, data, aad []byte) ([]byte, error) {
+	n := len(dst)
+	dst = append(dst, make([]byte, aead.NonceSize())...)
+	nonce := dst[n:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(dst, nonce, data, aad), nil
+}
+
+// open authenticates and decrypts data sealed by seal.
+func open(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: short record", ErrAuthentication)
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+	return plain, nil
+}
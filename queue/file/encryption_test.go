@@ -0,0 +1,310 @@
+package file
+
+import (
+	"bytes"
+	"github.com/farwydi/ballistic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestEncryptedQueue(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	for _, compressor := range []Compressor{nil, Flate} {
+		name := "plain"
+		if compressor != nil {
+			name = compressor.Name()
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+"_0.bd")
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+			require.NoError(t, err)
+			defer f.Close()
+
+			keys := StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": key1}}
+			cfg := Config{Keys: keys, Compressor: compressor, BlockSize: 512}
+			q, err := NewQueue(f, &testStruct{}, cfg)
+			require.NoError(t, err)
+			assert.Equal(t, "k1", q.Header().KeyID)
+
+			for i := 0; i < 100; i++ {
+				require.NoError(t, q.Push(&testStruct{M: i}))
+			}
+			assert.Equal(t, seq(0, 10), ejectM(t, q, 10))
+
+			stored, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			assert.NotContains(t, string(stored), `"M":`)
+
+			// Without the keys the file can't be opened or read
+			_, err = NewQueue(f, &testStruct{})
+			assert.ErrorIs(t, err, ErrUnknownKey)
+			info, err := WalkFile(path, func(rec Record) error {
+				assert.ErrorIs(t, rec.Err, ErrUnknownKey)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.True(t, info.Valid())
+			_, err = Salvage(path, filepath.Join(tempDir, name+"_salvage_0.bd"))
+			assert.ErrorIs(t, err, ErrUnknownKey)
+
+			info, err = WalkFile(path, func(rec Record) error {
+				assert.NoError(t, rec.Err)
+				return nil
+			}, keys)
+			require.NoError(t, err)
+			assert.Equal(t, 90, info.Pending)
+
+			q, err = NewQueue(f, &testStruct{}, cfg)
+			require.NoError(t, err)
+			assert.Equal(t, 90, q.Len())
+			assert.Equal(t, seq(10, 100), ejectM(t, q, -1))
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	path := filepath.Join(tempDir, "test_0.bd")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer f.Close()
+
+	q, err := NewQueue(f, &testStruct{}, Config{Keys: StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": key1}}})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, q.Push(&testStruct{M: i}))
+	}
+	assert.Equal(t, seq(0, 3), ejectM(t, q, 3))
+
+	// The old key must still be provided to read the file
+	_, err = NewQueue(f, &testStruct{}, Config{Keys: StaticKeys{Current: "k2", Keys: map[string][]byte{"k2": key2}}})
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// The file is rewritten with the current key
+	rotated := StaticKeys{Current: "k2", Keys: map[string][]byte{"k1": key1, "k2": key2}}
+	q, err = NewQueue(f, &testStruct{}, Config{Keys: rotated})
+	require.NoError(t, err)
+	assert.Equal(t, "k2", q.Header().KeyID)
+	require.NoError(t, q.Push(&testStruct{M: 10}))
+
+	header, err := ReadHeader(path)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", header.KeyID)
+	require.NoError(t, q.Close())
+
+	f, err = os.OpenFile(path, os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer f.Close()
+	q, err = NewQueue(f, &testStruct{}, Config{Keys: StaticKeys{Current: "k2", Keys: map[string][]byte{"k2": key2}}})
+	require.NoError(t, err)
+	assert.Equal(t, seq(3, 11), ejectM(t, q, -1))
+
+	// Plain files are encrypted on open
+	plain, err := os.OpenFile(filepath.Join(tempDir, "plain_0.bd"), os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer plain.Close()
+	q, err = NewQueue(plain, &testStruct{})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&testStruct{M: 1}))
+	q, err = NewQueue(plain, &testStruct{}, Config{Keys: rotated})
+	require.NoError(t, err)
+	assert.Equal(t, "k2", q.Header().KeyID)
+	assert.Equal(t, []int{1}, ejectM(t, q, -1))
+}
+
+func TestTamperedRecord(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tempFile.Close())
+		assert.NoError(t, os.Remove(tempFile.Name()))
+		assert.NoError(t, os.Remove(tempFile.Name()+QuarantineSuffix))
+	}()
+
+	cfg := Config{Keys: StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": key1}}}
+	q, err := NewQueue(tempFile, &testStruct{}, cfg)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, q.Push(&testStruct{M: i}))
+	}
+
+	var offsets []int64
+	_, err = WalkFile(tempFile.Name(), func(rec Record) error {
+		offsets = append(offsets, rec.Offset)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, offsets, 6)
+
+	// Flip a ciphertext byte of the second and the fifth record
+	tamper := func(offset int64) {
+		b := make([]byte, 1)
+		_, err := tempFile.ReadAt(b, offset+MetaElementSize+20)
+		require.NoError(t, err)
+		b[0] ^= 0xff
+		_, err = tempFile.WriteAt(b, offset+MetaElementSize+20)
+		require.NoError(t, err)
+	}
+	tamper(offsets[1])
+
+	assert.Equal(t, []int{0, 2}, ejectM(t, q, 2))
+
+	// The checksum no longer matches, the tampered record is quarantined alone
+	tamper(offsets[4])
+	info, err := WalkFile(tempFile.Name(), func(rec Record) error {
+		if rec.Offset == offsets[4] {
+			assert.ErrorIs(t, rec.Err, ErrAuthentication)
+		}
+		return nil
+	}, cfg.Keys)
+	require.NoError(t, err)
+	assert.False(t, info.Valid())
+
+	q, err = NewQueue(tempFile, &testStruct{}, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, []int{3, 5}, ejectM(t, q, -1))
+	assert.Equal(t, 0, q.Len())
+
+	quarantined, err := ReadQuarantine(tempFile.Name() + QuarantineSuffix)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 2)
+	assert.Contains(t, quarantined[0].Reason, ErrAuthentication.Error())
+}
+
+func TestRecordBinding(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	cfg := Config{Keys: StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": key1}}}
+	create := func(name string) (*Queue, []int64) {
+		f, err := os.OpenFile(filepath.Join(tempDir, name), os.O_CREATE|os.O_RDWR, os.ModePerm)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = f.Close() })
+		q, err := NewQueue(f, &testStruct{}, cfg)
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, q.Push(&testStruct{M: i}))
+		}
+
+		var offsets []int64
+		_, err = WalkFile(f.Name(), func(rec Record) error {
+			offsets = append(offsets, rec.Offset)
+			return nil
+		}, cfg.Keys)
+		require.NoError(t, err)
+		require.Len(t, offsets, 3)
+		return q, offsets
+	}
+	a, offsets := create("a_0.bd")
+	b, _ := create("b_0.bd")
+	assert.NotEqual(t, a.Header().FileID, b.Header().FileID)
+	size := offsets[1] - offsets[0]
+
+	// A record copied to another position of its file or into another file doesn't authenticate
+	frame := make([]byte, size)
+	_, err = a.file.ReadAt(frame, offsets[0])
+	require.NoError(t, err)
+	_, err = a.file.WriteAt(frame, offsets[1])
+	require.NoError(t, err)
+	_, err = b.file.WriteAt(frame, offsets[0])
+	require.NoError(t, err)
+
+	a, err = NewQueue(a.file, &testStruct{}, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, ejectM(t, a, -1))
+	b, err = NewQueue(b.file, &testStruct{}, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, ejectM(t, b, -1))
+
+	quarantined, err := ReadQuarantine(a.file.Name() + QuarantineSuffix)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	assert.Contains(t, quarantined[0].Reason, ErrAuthentication.Error())
+}
+
+func TestQuarantineSealed(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	newRegistry := func(version int) *ballistic.Registry {
+		r := ballistic.NewRegistry()
+		require.NoError(t, r.Register("test", version, func() ballistic.DataModel {
+			return &sqlStruct{Q: "test"}
+		}))
+		return r
+	}
+
+	for _, compressor := range []Compressor{nil, Flate} {
+		name := "plain"
+		if compressor != nil {
+			name = compressor.Name()
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+"_0.bd")
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+			require.NoError(t, err)
+			defer f.Close()
+
+			keys := StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": key1}}
+			cfg := Config{Keys: keys, Compressor: compressor, BlockSize: 512, Registry: newRegistry(1)}
+			q, err := NewQueue(f, &sqlStruct{Q: "test"}, cfg)
+			require.NoError(t, err)
+			for i := 0; i < 50; i++ {
+				require.NoError(t, q.Push(&sqlStruct{testStruct: testStruct{M: 424242}, Q: "test"}))
+			}
+
+			// Version 2 can't read the records, they are quarantined sealed
+			cfg.Registry = newRegistry(2)
+			q, err = NewQueue(f, &sqlStruct{Q: "test"}, cfg)
+			require.NoError(t, err)
+			models, err := q.Eject(-1)
+			require.NoError(t, err)
+			assert.Empty(t, models)
+
+			data, err := ioutil.ReadFile(path + QuarantineSuffix)
+			require.NoError(t, err)
+			assert.NotContains(t, string(data), "424242")
+
+			quarantined, err := ReadQuarantine(path + QuarantineSuffix)
+			require.NoError(t, err)
+			require.Len(t, quarantined, 50)
+			for _, rec := range quarantined {
+				assert.Equal(t, "k1", rec.KeyID)
+				assert.Equal(t, q.Header().FileID, rec.FileID)
+				assert.Equal(t, 1, rec.Version)
+
+				plain, err := OpenQuarantined(rec, keys)
+				require.NoError(t, err)
+				e, err := parseEnvelope(plain)
+				require.NoError(t, err)
+				assert.Equal(t, `{"M":424242}`, string(e.payload))
+			}
+		})
+	}
+}
@@ -3,5 +3,24 @@ package file
 import "fmt"
 
 var (
-	ErrInvalidFile = fmt.Errorf("file invalid")
+	ErrInvalidFile       = fmt.Errorf("file invalid")
+	ErrModelMismatch     = fmt.Errorf("model mismatch")
+	ErrUnknownCodec      = fmt.Errorf("unknown codec")
+	ErrUnknownCompressor = fmt.Errorf("unknown compressor")
+	ErrUnknownKey        = fmt.Errorf("unknown key")
+	ErrAuthentication    = fmt.Errorf("record authentication failed")
+	ErrRecordTooLarge    = fmt.Errorf("model too large")
 )
+
+// RecordError is the error of a model the queue rejects, it is not pushed.
+type RecordError struct {
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
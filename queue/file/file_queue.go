@@ -1,52 +1,212 @@
 package file
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
 	"encoding"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"hash"
+	"github.com/farwydi/ballistic"
 	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
+	"time"
 )
 
+// Safe is a model stored with the Raw codec.
 type Safe interface {
 	encoding.BinaryMarshaler
 	encoding.BinaryUnmarshaler
 }
 
+// FormatVersion is the version of the file layout written by Queue.
+// Format 4 blocks replace the raw frames written before them and records carry
+// their idempotency key, readers of older formats refuse such files.
+const FormatVersion = 4
+
 const (
-	CRC32HashOffset int64 = 0
-	CRC32HashSize   int64 = 4
-	SkipAheadOffset       = CRC32HashOffset + CRC32HashSize
-	SkipAheadSize   int64 = 8
-	DataOffset            = SkipAheadOffset + SkipAheadSize
-	HeadSize              = CRC32HashSize + SkipAheadSize
-	MetaElementSize       = 2
+	MagicOffset      int64 = 0
+	MagicSize        int64 = 4
+	CRC32HashOffset        = MagicOffset + MagicSize
+	CRC32HashSize    int64 = 4
+	SkipAheadOffset        = CRC32HashOffset + CRC32HashSize
+	SkipAheadSize    int64 = 8
+	HeaderSizeOffset       = SkipAheadOffset + SkipAheadSize
+	HeaderSizeSize   int64 = 2
+	HeadSize               = HeaderSizeOffset + HeaderSizeSize
+	MetaElementSize        = 2
 )
 
-func NewQueue(file *os.File, pattern Safe) (*Queue, error) {
+// NewQueue opens the queue stored in file, records are decoded as the type of pattern.
+// A registry in the config stores the model name of pattern in the header of a new file
+// and upcasts records of older versions. Files of older formats are upgraded into a new file
+// renamed over the old one, file is then closed and the queue owns the reopened file.
+// The pattern must be a Safe model unless the codec of the file is not Raw.
+func NewQueue(file *os.File, pattern interface{}, config ...Config) (*Queue, error) {
+	cfg := configDefault(config...)
+
+	newModel, err := modelFactory(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return newQueue(file, newModel, newHeader(pattern, cfg), cfg)
+}
+
+// newHeader returns the header of a new file of pattern records.
+func newHeader(pattern interface{}, cfg Config) Header {
+	header := Header{Format: FormatVersion, Codec: cfg.Codec.Name()}
+	if cfg.Compressor != nil {
+		header.Compression = cfg.Compressor.Name()
+	}
+	if cfg.Registry != nil {
+		header.Model, header.Version, _ = cfg.Registry.Lookup(pattern)
+	}
+	return header
+}
+
+// OpenQueue opens the queue stored in file, records are decoded
+// as the model registered under the name stored in the header.
+func OpenQueue(file *os.File, registry *ballistic.Registry, config ...Config) (*Queue, error) {
+	cfg := configDefault(config...)
+	cfg.Registry = registry
+
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := readHead(file)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if h.header.Model == "" {
+		return nil, fmt.Errorf("%w: '%s' has no model name", ballistic.ErrUnknownModel, file.Name())
+	}
+
+	model, err := registry.New(h.header.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	newModel, err := modelFactory(model)
+	if err != nil {
+		return nil, err
+	}
+
+	header := h.header
+	header.Version, err = registry.Version(header.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	return newQueue(file, newModel, header, cfg)
+}
+
+// modelFactory returns a function creating empty models like pattern.
+func modelFactory(pattern interface{}) (func() interface{}, error) {
+	if w, ok := pattern.(ballistic.Wrapper); ok {
+		return func() interface{} {
+			return w.New()
+		}, nil
+	}
+
+	t := reflect.TypeOf(pattern)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("model %T is not a pointer", pattern)
+	}
+
+	typeOf := t.Elem()
+	return func() interface{} {
+		return reflect.New(typeOf).Interface()
+	}, nil
+}
+
+// newQueue opens the queue, header is written to an empty file and holds
+// the model name, the version, the codec, the compressor and the key of the pushed records.
+func newQueue(file *os.File, newModel func() interface{}, header Header, cfg Config) (*Queue, error) {
+	if cfg.Keys != nil {
+		id, _, err := cfg.Keys.CurrentKey()
+		if err != nil {
+			return nil, err
+		}
+		header.KeyID = id
+	}
+
+	quarantine := cfg.Quarantine
+	if quarantine == nil {
+		quarantine = NewFileQuarantine(file.Name() + QuarantineSuffix)
+	}
+
+	blockSize := cfg.BlockSize
+	if blockSize <= 0 || blockSize > MaxBlockSize {
+		blockSize = ConfigDefault.BlockSize
+	}
+
 	return (&Queue{
-		typeOf: reflect.ValueOf(pattern).Elem().Type(),
-		file:   file,
-		order:  binary.BigEndian,
-		sum:    crc32.NewIEEE(),
-	}).checkFile()
+		newModel:   newModel,
+		file:       file,
+		order:      binary.BigEndian,
+		blockSize:  blockSize,
+		keys:       cfg.Keys,
+		keyID:      header.KeyID,
+		registry:   cfg.Registry,
+		model:      header.Model,
+		version:    header.Version,
+		quarantine: quarantine,
+	}).checkFile(header)
 }
 
 type Queue struct {
-	typeOf reflect.Type
-	file   *os.File
-	order  binary.ByteOrder
-	mx     sync.Mutex
+	newModel   func() interface{}
+	codec      Codec
+	compressor Compressor
+	blockSize  int
+	// keys provides the key of the file, records are sealed by aead if the file is encrypted.
+	keys  KeyProvider
+	keyID string
+	aead  cipher.AEAD
+	file  *os.File
+	order binary.ByteOrder
+	mx    sync.Mutex
 
-	sum   hash.Hash32
-	count int
-	mw    io.Writer
+	header Header
+	// headRaw is the encoded header, records of encrypted files are bound to it.
+	headRaw []byte
+	sum     uint32
+	count   int
+	// end is the size of the file.
+	end int64
+
+	// The raw records after the last block start at tailOffset,
+	// where the checksum is tailSum. They are compressed once tailBytes reach the block size.
+	tailOffset int64
+	tailSum    uint32
+	tailBytes  int
+	// replaced are the raw frames after the skip-ahead whose records are in the block that follows them.
+	replaced []span
+
+	// rawBytes and storedBytes are the sizes of the frames before and after compression.
+	rawBytes    int64
+	storedBytes int64
+	// cached is the last decompressed block.
+	cached struct {
+		offset  int64
+		records [][]byte
+	}
+
+	registry   *ballistic.Registry
+	model      string
+	version    int
+	quarantine Quarantine
 }
 
 func (f *Queue) Len() int {
@@ -55,115 +215,361 @@ func (f *Queue) Len() int {
 	return f.count
 }
 
-func (f *Queue) checkFile() (*Queue, error) {
-	f.mw = io.MultiWriter(f.file, f.sum)
+// Header returns the header stored in the file.
+func (f *Queue) Header() Header {
+	return f.header
+}
+
+// CompressionRatio returns the size of the records before compression divided by their size in the file,
+// it is 1 for files without compression.
+func (f *Queue) CompressionRatio() float64 {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.storedBytes == 0 {
+		return 1
+	}
+	return float64(f.rawBytes) / float64(f.storedBytes)
+}
 
+func (f *Queue) checkFile(header Header) (*Queue, error) {
 	_, err := f.file.Seek(0, io.SeekStart)
 	if err != nil {
 		return nil, err
 	}
 
-	buf := make([]byte, HeadSize)
-	crc32SumBuf := buf[0:CRC32HashSize]
-	skipAheadBuf := buf[CRC32HashSize : CRC32HashSize+SkipAheadSize]
-
-	n, err := f.file.Read(buf[0:HeadSize])
+	h, err := readHead(f.file)
 	if err != nil {
-		if errors.Is(err, io.EOF) && n == 0 {
-			haedBuf := buf[0 : CRC32HashSize+SkipAheadSize]
-			f.order.PutUint32(crc32SumBuf, 0)
-			f.order.PutUint64(skipAheadBuf, uint64(DataOffset))
-			_, err := f.file.Write(haedBuf)
+		if errors.Is(err, io.EOF) {
+			err = f.setCodec(header)
+			if err != nil {
+				return nil, err
+			}
+			header.FileID = ""
+			if header.KeyID != "" {
+				header.FileID, err = newFileID()
+				if err != nil {
+					return nil, err
+				}
+			}
+			h, err = writeHead(f.file, header)
 			if err != nil {
 				return nil, err
 			}
+			f.header = h.header
+			f.headRaw = h.raw
+			f.sum = h.sum
+			f.end = h.dataOffset
+			f.tailOffset = f.end
+			f.tailSum = f.sum
 			return f, nil
 		}
 		return nil, err
 	}
 
-	fileSum := f.order.Uint32(crc32SumBuf)
-	skipAhead := int64(f.order.Uint64(skipAheadBuf))
-	currOffset := DataOffset
+	if h.header.Format < FormatVersion {
+		// Files of older formats hold Raw records
+		header.Codec = Raw.Name()
+		return f.upgrade(header)
+	}
+
+	if header.Model != "" && h.header.Model != "" && header.Model != h.header.Model {
+		return nil, fmt.Errorf("%w: file holds '%s', not '%s'", ErrModelMismatch, h.header.Model, header.Model)
+	}
 
-	_, err = f.file.Seek(DataOffset, io.SeekStart)
+	if f.keys != nil && (h.header.KeyID != f.keyID || h.header.FileID == "") {
+		// Rotate the key or bind the records to the file, they are read with the old key
+		_, err = newAEAD(f.keys, h.header.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		rotated := h.header
+		rotated.KeyID = f.keyID
+		return f.upgrade(rotated)
+	}
+
+	err = f.setCodec(h.header)
 	if err != nil {
 		return nil, err
 	}
 
-	tr := io.TeeReader(f.file, f.sum)
+	f.header = h.header
+	f.headRaw = h.raw
+	if f.model == "" {
+		f.model = h.header.Model
+	}
+	f.sum = crc32.ChecksumIEEE(h.raw)
+	f.tailOffset = h.dataOffset
+	f.tailSum = f.sum
+	f.count, f.rawBytes, f.storedBytes, f.tailBytes, f.replaced = 0, 0, 0, 0, nil
+
+	// good is the end of the frames covered by the stored checksum
+	good := int64(-1)
+	if f.sum == h.sum {
+		good = h.dataOffset
+	}
+	// raw are the recent raw frames a block may replace
+	type rawFrame struct {
+		offset, size int64
+		pending      bool
+	}
+	var raw []rawFrame
+
+	_, err = f.file.Seek(h.dataOffset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
 
+	r := bufio.NewReader(f.file)
+	meta := make([]byte, MetaElementSize)
+	buf := make([]byte, 0, 1024)
+	offset := h.dataOffset
 	for {
-		size, err := f.readMeta(buf)
+		_, err := io.ReadFull(r, meta)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, ErrInvalidFile
+			}
 			return nil, err
 		}
 
-		currOffset += MetaElementSize
-
-		if size > math.MaxUint16 {
-			return nil, ErrInvalidFile
-		}
-
-		if len(buf) < size {
+		size := int(f.order.Uint16(meta))
+		if cap(buf) < size {
 			buf = make([]byte, size)
 		}
+		buf = buf[:size]
 
-		_, err = tr.Read(buf[:size])
+		_, err = io.ReadFull(r, buf)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
 				return nil, ErrInvalidFile
 			}
 			return nil, err
 		}
+		f.sum = crc32.Update(f.sum, crc32.IEEETable, buf)
 
-		currOffset += int64(size)
-
-		if currOffset > skipAhead {
-			f.count++
+		frameSize := MetaElementSize + int64(size)
+		pending := offset >= h.skipAhead
+		e, err := f.parseFrame(buf, offset)
+		if err == nil && e.block {
+			b, err := parseBlock(e.payload, h.header.Format)
+			if err != nil {
+				return nil, err
+			}
+			if b.replaced > 0 {
+				start := offset - b.replaced
+				for _, r := range raw {
+					if r.offset < start {
+						continue
+					}
+					if r.pending {
+						f.count--
+					}
+					f.rawBytes -= r.size
+					f.storedBytes -= r.size
+				}
+				if offset > h.skipAhead {
+					f.replaced = append(f.replaced, span{start: start, end: offset})
+				}
+			}
+			raw = raw[:0]
+			if pending {
+				f.count += b.count
+				if offset == h.skipAhead {
+					f.count -= h.skipRecords
+				}
+			}
+			f.rawBytes += int64(b.rawSize)
+			f.tailOffset = offset + frameSize
+			f.tailSum = f.sum
+			f.tailBytes = 0
+		} else {
+			// Broken and tampered envelopes are counted and quarantined by Eject
+			if pending {
+				f.count++
+			}
+			f.rawBytes += frameSize
+			f.tailBytes += size
+			for len(raw) > 0 && raw[0].offset < offset-maxReplaced {
+				raw = raw[1:]
+			}
+			raw = append(raw, rawFrame{offset: offset, size: frameSize, pending: pending})
+		}
+		f.storedBytes += frameSize
+		offset += frameSize
+		if f.sum == h.sum {
+			good = offset
 		}
 	}
+	f.end = offset
 
-	if f.sum.Sum32() != fileSum {
-		return nil, ErrInvalidFile
+	if f.sum != h.sum {
+		if good >= 0 {
+			// A crash between writing frames and the checksum leaves frames it doesn't cover,
+			// none of them was acknowledged
+			err = f.file.Truncate(good)
+			if err != nil {
+				return nil, err
+			}
+			return f.checkFile(header)
+		}
+		if f.aead == nil {
+			return nil, ErrInvalidFile
+		}
+		// Records of an encrypted file authenticate on their own,
+		// Eject quarantines the ones that don't instead of the whole file
+		err = f.updateSum(make([]byte, CRC32HashSize))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return f, nil
 }
 
-func (f *Queue) readMeta(bs []byte) (size int, err error) {
-	metaElementBuf := bs[0:MetaElementSize]
+// setCodec sets the codec, the compressor and the cipher the records of the file are encoded with.
+func (f *Queue) setCodec(header Header) error {
+	codec, err := CodecByName(header.Codec)
+	if err != nil {
+		return err
+	}
+
+	if codec == Raw {
+		if _, ok := f.newModel().(encoding.BinaryUnmarshaler); !ok {
+			return fmt.Errorf("model %T is not a BinaryUnmarshaler, the file codec is %s", f.newModel(), codec.Name())
+		}
+	}
 
-	_, err = f.file.Read(metaElementBuf)
+	f.compressor, err = CompressorByName(header.Compression)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	size = int(f.order.Uint16(metaElementBuf))
+	f.aead, err = newAEAD(f.keys, header.KeyID)
+	if err != nil {
+		return err
+	}
 
-	return size, err
+	f.codec = codec
+	return nil
 }
 
-func (f *Queue) writeMeta(bs []byte, size int) error {
-	metaElementBuf := bs[0:MetaElementSize]
+// upgrade rewrites a file of an older format or another key with the pending records only.
+// Records failing authentication are moved to the quarantine.
+func (f *Queue) upgrade(header Header) (*Queue, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.file.Name()), "."+filepath.Base(f.file.Name())+".upgrade")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if tmp != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
 
-	f.order.PutUint16(metaElementBuf, uint16(size))
+	_, err = f.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := f.file.Write(metaElementBuf)
+	tq, err := newQueue(tmp, f.newModel, header, Config{Quarantine: f.quarantine, BlockSize: f.blockSize, Keys: f.keys})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	info, err := Walk(f.file, func(rec Record) error {
+		if rec.Consumed {
+			return nil
+		}
+		if errors.Is(rec.Err, ErrAuthentication) {
+			return f.quarantine.Put(QuarantineRecord{
+				Time:   time.Now(),
+				Model:  header.Model,
+				Reason: rec.Err.Error(),
+				Data:   append([]byte(nil), rec.Data...),
+				KeyID:  f.header.KeyID,
+				FileID: f.header.FileID,
+				Offset: rec.Offset,
+			})
+		}
+		return tq.push(envelope{version: rec.Version, key: rec.Key, payload: rec.Data})
+	}, f.keys)
+	if err != nil {
+		return nil, err
+	}
+	// The records of an encrypted file were authenticated, the checksum may be off
+	if info.Err != nil || !info.Valid() && info.Header.KeyID == "" {
+		return nil, ErrInvalidFile
+	}
+
+	// The old file stays intact until the upgraded one replaces it
+	err = tmp.Sync()
+	if err != nil {
+		return nil, err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	name := f.file.Name()
+	err = os.Rename(tmp.Name(), name)
+	if err != nil {
+		return nil, err
+	}
+	tmp = nil
+	err = SyncDir(filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(name, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	_ = f.file.Close()
+	f.file = file
+
+	return f.checkFile(header)
+}
+
+func (f *Queue) readSkip(bs []byte) (offset int64, records int, err error) {
+	skipAheadBuf := bs[0:SkipAheadSize]
+
+	_, err = f.file.ReadAt(skipAheadBuf, SkipAheadOffset)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offset, records = splitSkip(f.order.Uint64(skipAheadBuf))
+	return offset, records, nil
+}
+
+func (f *Queue) writeSkip(bs []byte, offset int64, records int) error {
+	skipAheadBuf := bs[0:SkipAheadSize]
+
+	f.order.PutUint64(skipAheadBuf, joinSkip(offset, records))
+	_, err := f.file.WriteAt(skipAheadBuf, SkipAheadOffset)
+	return err
+}
+
+// writeSumSkip writes the checksum and the skip-ahead word at once, they are adjacent.
+func (f *Queue) writeSumSkip(bs []byte, sum uint32, offset int64, records int) error {
+	buf := bs[0 : CRC32HashSize+SkipAheadSize]
+
+	f.order.PutUint32(buf, sum)
+	f.order.PutUint64(buf[CRC32HashSize:], joinSkip(offset, records))
+	_, err := f.file.WriteAt(buf, CRC32HashOffset)
+	return err
 }
 
 func (f *Queue) updateSum(bs []byte) error {
 	crc32SumBuf := bs[0:CRC32HashSize]
 
-	f.order.PutUint32(crc32SumBuf, f.sum.Sum32())
+	f.order.PutUint32(crc32SumBuf, f.sum)
 	_, err := f.file.WriteAt(crc32SumBuf, CRC32HashOffset)
 	if err != nil {
 		return err
@@ -173,15 +579,68 @@ func (f *Queue) updateSum(bs []byte) error {
 }
 
 func (f *Queue) Push(model encoding.BinaryMarshaler) error {
-	data, err := model.MarshalBinary()
+	return f.PushValue(model)
+}
+
+// PushValue pushes a model encoded with the codec of the queue, it needs not to be a BinaryMarshaler.
+// The idempotency key of a ballistic.Keyed model is stored with the record.
+func (f *Queue) PushValue(v interface{}) error {
+	data, err := marshal(f.codec, v)
 	if err != nil {
-		return err
+		return &RecordError{Err: err}
 	}
 
-	size := len(data)
+	return f.push(envelope{version: f.version, key: ballistic.KeyOf(v), payload: data})
+}
 
+// frame returns the frame of the data, meta included. The meta holds the stored size,
+// the data of an encrypted file is sealed by sealAt once the offset of the frame is known.
+func (f *Queue) frame(data []byte) ([]byte, error) {
+	size := len(data)
+	if f.aead != nil {
+		size += f.aead.NonceSize() + f.aead.Overhead()
+	}
 	if size > math.MaxUint16 {
-		return fmt.Errorf("model to large: %d over %d", size, math.MaxUint16)
+		return nil, fmt.Errorf("%w: %d over %d", ErrRecordTooLarge, size, math.MaxUint16)
+	}
+
+	frame := make([]byte, MetaElementSize, MetaElementSize+len(data))
+	f.order.PutUint16(frame, uint16(size))
+	return append(frame, data...), nil
+}
+
+// sealAt returns the frame stored at offset, its data is sealed if the file is encrypted.
+func (f *Queue) sealAt(frame []byte, offset int64) ([]byte, error) {
+	if f.aead == nil {
+		return frame, nil
+	}
+
+	sealed := make([]byte, MetaElementSize, int(f.order.Uint16(frame))+MetaElementSize)
+	copy(sealed, frame[:MetaElementSize])
+	return seal(f.aead, sealed, frame[MetaElementSize:], recordAAD(f.header, f.headRaw, offset))
+}
+
+// unseal returns the data of the frame stored at offset, it fails if an encrypted frame doesn't authenticate.
+func (f *Queue) unseal(stored []byte, offset int64) ([]byte, error) {
+	if f.aead == nil {
+		return stored, nil
+	}
+	return open(f.aead, stored, recordAAD(f.header, f.headRaw, offset))
+}
+
+// parseFrame returns the envelope of the frame stored at offset.
+func (f *Queue) parseFrame(stored []byte, offset int64) (envelope, error) {
+	data, err := f.unseal(stored, offset)
+	if err != nil {
+		return envelope{}, err
+	}
+	return parseEnvelope(data)
+}
+
+func (f *Queue) push(e envelope) error {
+	frame, err := f.encode(e)
+	if err != nil {
+		return err
 	}
 
 	bs := bsPool.Get().([]byte)
@@ -190,26 +649,345 @@ func (f *Queue) Push(model encoding.BinaryMarshaler) error {
 	f.mx.Lock()
 	defer f.mx.Unlock()
 
-	err = f.writeMeta(bs, size)
+	err = f.write([][]byte{frame})
 	if err != nil {
 		return err
 	}
 
-	_, err = f.mw.Write(data)
+	err = f.updateSum(bs)
 	if err != nil {
 		return err
 	}
 
-	f.count++
+	if f.compressor != nil && f.tailBytes >= f.blockSize {
+		return f.compressTail(bs)
+	}
 
-	err = f.updateSum(bs)
+	return nil
+}
+
+// PushAll pushes the models like Push with one write and one checksum update,
+// the tail is compressed as blocks fill. The errors are those of the models not pushed
+// by index, nil if all were pushed. A model the queue rejects fails with a *RecordError
+// and the others are pushed, after a write error none of the rest is.
+func (f *Queue) PushAll(models []encoding.BinaryMarshaler) (errs []error) {
+	values := make([]interface{}, len(models))
+	for i, model := range models {
+		values[i] = model
+	}
+	return f.pushValues(values)
+}
+
+func (f *Queue) pushValues(values []interface{}) (errs []error) {
+	fail := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(values))
+		}
+		errs[i] = err
+	}
+
+	frames := make([][]byte, 0, len(values))
+	index := make([]int, 0, len(values))
+	for i, v := range values {
+		data, err := marshal(f.codec, v)
+		if err != nil {
+			fail(i, &RecordError{Err: err})
+			continue
+		}
+		frame, err := f.encode(envelope{version: f.version, key: ballistic.KeyOf(v), payload: data})
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		frames = append(frames, frame)
+		index = append(index, i)
+	}
+	if len(frames) == 0 {
+		return errs
+	}
+
+	bs := bsPool.Get().([]byte)
+	defer bsPool.Put(bs)
+
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	// The frames are written up to a full block at a time, so it is compressed like by Push
+	start, tail := 0, f.tailBytes
+	for i, frame := range frames {
+		tail += len(frame) - MetaElementSize
+		if i < len(frames)-1 && (f.compressor == nil || tail < f.blockSize) {
+			continue
+		}
+
+		err := f.write(frames[start : i+1])
+		if err == nil && f.compressor != nil && f.tailBytes >= f.blockSize {
+			err = f.compressTail(bs)
+		}
+		if err != nil {
+			for _, j := range index[start:] {
+				fail(j, err)
+			}
+			index = index[:start]
+			break
+		}
+		start, tail = i+1, f.tailBytes
+	}
+
+	if err := f.updateSum(bs); err != nil {
+		for _, j := range index {
+			fail(j, err)
+		}
+	}
+	return errs
+}
+
+// Close closes the file of the queue.
+func (f *Queue) Close() error {
+	return f.file.Close()
+}
+
+// Sync commits the records pushed to stable storage.
+func (f *Queue) Sync() error {
+	return f.file.Sync()
+}
+
+// encode returns the frame of the record.
+func (f *Queue) encode(e envelope) ([]byte, error) {
+	frame, err := f.frame(e.append(make([]byte, 0, len(e.payload)+binary.MaxVarintLen64+1)))
+	if err != nil {
+		return nil, &RecordError{Err: err}
+	}
+	return frame, nil
+}
+
+// write appends the frames at the end of the file, the checksum is updated by the caller.
+func (f *Queue) write(frames [][]byte) error {
+	if f.aead != nil {
+		sealed := make([][]byte, len(frames))
+		offset := f.end
+		for i, frame := range frames {
+			var err error
+			sealed[i], err = f.sealAt(frame, offset)
+			if err != nil {
+				return err
+			}
+			offset += int64(len(sealed[i]))
+		}
+		frames = sealed
+	}
+
+	buf := frames[0]
+	if len(frames) > 1 {
+		buf = bytes.Join(frames, nil)
+	}
+
+	_, err := f.file.WriteAt(buf, f.end)
 	if err != nil {
 		return err
 	}
 
+	f.end += int64(len(buf))
+	f.rawBytes += int64(len(buf))
+	f.storedBytes += int64(len(buf))
+	for _, frame := range frames {
+		f.sum = crc32.Update(f.sum, crc32.IEEETable, frame[MetaElementSize:])
+		f.count++
+		f.tailBytes += len(frame) - MetaElementSize
+	}
 	return nil
 }
 
+// span is a range of file offsets, the end is excluded.
+type span struct {
+	start, end int64
+}
+
+// compressTail appends a compressed block of the raw records after the last block.
+// The raw frames are never rewritten, the block replaces them for readers, so a crash
+// leaves either of them. Consumed records are dropped. The tail stays raw if compression
+// doesn't make it smaller or a record of it fails authentication.
+func (f *Queue) compressTail(bs []byte) error {
+	if f.end-f.tailOffset > maxReplaced {
+		f.tailOffset = f.end
+		f.tailSum = f.sum
+		f.tailBytes = 0
+		return nil
+	}
+
+	tail := make([]byte, f.end-f.tailOffset)
+	_, err := f.file.ReadAt(tail, f.tailOffset)
+	if err != nil {
+		return err
+	}
+
+	skipOffset, skipRecords, err := f.readSkip(bs)
+	if err != nil {
+		return err
+	}
+
+	var records [][]byte
+	sealed := true
+	for off := 0; off < len(tail); {
+		size := int(f.order.Uint16(tail[off:]))
+		if f.tailOffset+int64(off) >= skipOffset {
+			data, err := f.unseal(tail[off+MetaElementSize:off+MetaElementSize+size], f.tailOffset+int64(off))
+			sealed = sealed && err == nil
+			records = append(records, data)
+		}
+		off += MetaElementSize + size
+	}
+
+	var frame []byte
+	var rawSize int
+	if sealed && len(records) > 0 && len(records) <= maxBlockRecords {
+		var data []byte
+		data, rawSize, err = buildBlock(records, int64(len(tail)), f.compressor)
+		if err != nil {
+			return err
+		}
+		frame, err = f.frame(data)
+		if err != nil && !errors.Is(err, ErrRecordTooLarge) {
+			return err
+		}
+	}
+
+	if frame == nil || MetaElementSize+int(f.order.Uint16(frame)) >= len(tail) {
+		f.tailOffset = f.end
+		f.tailSum = f.sum
+		f.tailBytes = 0
+		return nil
+	}
+
+	offset := f.end
+	frame, err = f.sealAt(frame, offset)
+	if err != nil {
+		return err
+	}
+	_, err = f.file.WriteAt(frame, offset)
+	if err != nil {
+		return err
+	}
+
+	// Without pending records before the tail the skip-ahead passes the raw frames,
+	// it is written together with the checksum
+	sum := crc32.Update(f.sum, crc32.IEEETable, frame[MetaElementSize:])
+	replaced := skipOffset < f.tailOffset
+	if !replaced {
+		skipOffset, skipRecords = offset, 0
+	}
+	err = f.writeSumSkip(bs, sum, skipOffset, skipRecords)
+	if err != nil {
+		return err
+	}
+
+	if replaced {
+		f.replaced = append(f.replaced, span{start: f.tailOffset, end: offset})
+	}
+	f.sum = sum
+	f.rawBytes += int64(rawSize - len(tail))
+	f.storedBytes += int64(len(frame) - len(tail))
+	f.end = offset + int64(len(frame))
+	f.tailOffset = f.end
+	f.tailSum = f.sum
+	f.tailBytes = 0
+	f.cached.records = nil
+	return nil
+}
+
+// replacedAt returns the end of the raw frames at offset replaced by a block.
+func (f *Queue) replacedAt(offset int64) (int64, bool) {
+	for _, r := range f.replaced {
+		if r.start <= offset && offset < r.end {
+			return r.end, true
+		}
+	}
+	return 0, false
+}
+
+// decode upcasts the payload to the current version and unmarshals it.
+func (f *Queue) decode(e envelope) (interface{}, error) {
+	data := e.payload
+	switch {
+	case f.registry != nil && f.model != "":
+		var err error
+		data, err = f.registry.Upcast(f.model, e.version, data)
+		if err != nil {
+			return nil, err
+		}
+	case e.version > 0 && f.version > 0 && e.version != f.version:
+		return nil, fmt.Errorf("%w: version %d, current is %d", ballistic.ErrNoUpcaster, e.version, f.version)
+	}
+
+	model := f.newModel()
+	err := unmarshal(f.codec, data, model)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := model.(ballistic.KeySetter); ok && e.key != "" {
+		k.SetIdempotencyKey(e.key)
+	}
+	return model, nil
+}
+
+// readBlock returns the records of the block frame at offset.
+func (f *Queue) readBlock(offset int64, b blockInfo) ([][]byte, error) {
+	if f.cached.records != nil && f.cached.offset == offset {
+		return f.cached.records, nil
+	}
+
+	records, err := readBlock(b, f.compressor)
+	if err != nil {
+		return nil, err
+	}
+
+	f.cached.offset = offset
+	f.cached.records = records
+	return records, nil
+}
+
+// quarantineData moves the data of the frame at offset to the quarantine.
+// The data of an encrypted file is sealed again, it never reaches the quarantine in plaintext.
+func (f *Queue) quarantineData(reason string, version int, data []byte, offset int64) error {
+	rec := QuarantineRecord{
+		Time:    time.Now(),
+		Model:   f.model,
+		Version: version,
+		Reason:  reason,
+		Data:    append([]byte(nil), data...),
+	}
+	if f.aead != nil {
+		sealed, err := seal(f.aead, nil, data, nil)
+		if err != nil {
+			return err
+		}
+		rec.Data = sealed
+		rec.KeyID = f.header.KeyID
+		rec.FileID = f.header.FileID
+		rec.Offset = offset
+	}
+	return f.quarantine.Put(rec)
+}
+
+// eject decodes a record of the frame at offset, records that can't be decoded are moved to the quarantine.
+func (f *Queue) eject(data []byte, offset int64, models []interface{}) ([]interface{}, error) {
+	var model interface{}
+	e, decodeErr := parseEnvelope(data)
+	if decodeErr == nil && e.block {
+		decodeErr = fmt.Errorf("%w: block inside a block", ErrInvalidFile)
+	}
+	if decodeErr == nil {
+		model, decodeErr = f.decode(e)
+	}
+
+	if decodeErr != nil {
+		return models, f.quarantineData(decodeErr.Error(), e.version, data, offset)
+	}
+	return append(models, model), nil
+}
+
+// Eject removes up to limit records from the head of the queue.
+// Records that can't be decoded are moved to the quarantine and don't count to the limit.
 func (f *Queue) Eject(limit int) (models []interface{}, err error) {
 	f.mx.Lock()
 	defer f.mx.Unlock()
@@ -226,66 +1004,119 @@ func (f *Queue) Eject(limit int) (models []interface{}, err error) {
 		return nil, nil
 	}
 
-	models = make([]interface{}, limit)
-
-	buf := make([]byte, HeadSize)
-	skipAheadBuf := buf[0:SkipAheadSize]
+	models = make([]interface{}, 0, limit)
 
-	_, err = f.file.ReadAt(skipAheadBuf, SkipAheadOffset)
+	bs := make([]byte, SkipAheadSize)
+	offset, skipRecords, err := f.readSkip(bs)
 	if err != nil {
 		return nil, err
 	}
 
-	skipAhead := int64(f.order.Uint64(skipAheadBuf))
-
-	_, err = f.file.Seek(skipAhead, io.SeekStart)
-	if err != nil {
-		return nil, err
-	}
+	meta := make([]byte, MetaElementSize)
+	var buf []byte
+	for len(models) < limit && f.count > 0 && offset < f.end {
+		if end, ok := f.replacedAt(offset); ok {
+			offset = end
+			skipRecords = 0
+			continue
+		}
 
-	for i := 0; i < limit; i++ {
-		size, err := f.readMeta(buf)
+		_, err = f.file.ReadAt(meta, offset)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return models[:i], err
+			break
 		}
 
-		skipAhead += MetaElementSize
-
-		if len(buf) < size {
+		size := int(f.order.Uint16(meta))
+		if cap(buf) < size {
 			buf = make([]byte, size)
 		}
+		buf = buf[:size]
 
-		dataBuf := buf[0:size]
-		_, err = f.file.Read(dataBuf)
-		f.count--
+		_, err = f.file.ReadAt(buf, offset+MetaElementSize)
 		if err != nil {
-			return models[:i], err
+			break
+		}
+		next := offset + MetaElementSize + int64(size)
+
+		data, aerr := f.unseal(buf, offset)
+		if aerr != nil {
+			// The frame may be a block, its records are counted as one then
+			err = f.quarantine.Put(QuarantineRecord{
+				Time:   time.Now(),
+				Model:  f.model,
+				Reason: aerr.Error(),
+				Data:   append([]byte(nil), buf...),
+				KeyID:  f.header.KeyID,
+				FileID: f.header.FileID,
+				Offset: offset,
+			})
+			if err != nil {
+				break
+			}
+			offset = next
+			skipRecords = 0
+			f.count--
+			continue
 		}
 
-		skipAhead += int64(size)
+		e, perr := parseEnvelope(data)
+		if perr != nil || !e.block {
+			models, err = f.eject(data, offset, models)
+			if err != nil {
+				break
+			}
+			offset = next
+			f.count--
+			continue
+		}
 
-		e := reflect.New(f.typeOf).Interface().(encoding.BinaryUnmarshaler)
-		err = e.UnmarshalBinary(dataBuf)
-		if err != nil {
-			return models[:i], err
+		// A compressed block, possibly consumed in part
+		b, berr := parseBlock(e.payload, f.header.Format)
+		var records [][]byte
+		if berr == nil {
+			records, berr = f.readBlock(offset, b)
+		}
+		if berr != nil {
+			err = f.quarantineData(berr.Error(), 0, data, offset)
+			if err != nil {
+				break
+			}
+			if b.count > skipRecords {
+				f.count -= b.count - skipRecords
+			}
+			offset = next
+			skipRecords = 0
+			continue
 		}
 
-		models[i] = e
+		for skipRecords < len(records) && len(models) < limit {
+			models, err = f.eject(records[skipRecords], offset, models)
+			if err != nil {
+				break
+			}
+			skipRecords++
+			f.count--
+		}
+		if err != nil {
+			break
+		}
+		if skipRecords == len(records) {
+			offset = next
+			skipRecords = 0
+		}
 	}
 
-	f.order.PutUint64(skipAheadBuf, uint64(skipAhead))
-	_, err = f.file.WriteAt(skipAheadBuf, SkipAheadOffset)
-	if err != nil {
-		return models, err
+	if offset >= f.end {
+		f.count = 0
+	}
+	for len(f.replaced) > 0 && f.replaced[0].end <= offset {
+		f.replaced = f.replaced[1:]
 	}
 
-	_, err = f.file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return models, err
+	werr := f.writeSkip(bs, offset, skipRecords)
+	if werr != nil && err == nil {
+		err = werr
 	}
 
-	return models, nil
+	return models, err
 }
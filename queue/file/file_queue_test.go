@@ -1,11 +1,14 @@
 package file
 
 import (
+	"encoding"
 	"encoding/json"
+	"github.com/farwydi/ballistic"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -124,3 +127,191 @@ func TestPushEjectReopen(t *testing.T) {
 		})
 	}
 }
+
+func TestUpcastAndQuarantine(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tempFile.Close())
+		assert.NoError(t, os.Remove(tempFile.Name()))
+		assert.NoError(t, os.Remove(tempFile.Name()+QuarantineSuffix))
+	}()
+
+	newRegistry := func(version int) *ballistic.Registry {
+		r := ballistic.NewRegistry()
+		require.NoError(t, r.Register("test", version, func() ballistic.DataModel {
+			return &sqlStruct{}
+		}))
+		return r
+	}
+
+	v1 := newRegistry(1)
+	q, err := NewQueue(tempFile, &sqlStruct{}, Config{Registry: v1})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&sqlStruct{testStruct: testStruct{M: 1}}))
+	require.NoError(t, q.Push(&sqlStruct{testStruct: testStruct{M: 2}}))
+
+	// Version 2 multiplies M by ten
+	v2 := newRegistry(2)
+	require.NoError(t, v2.RegisterUpcaster("test", 1, func(data []byte) ([]byte, error) {
+		var m map[string]int
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		m["M"] *= 10
+		return json.Marshal(m)
+	}))
+	q, err = NewQueue(tempFile, &sqlStruct{}, Config{Registry: v2})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&sqlStruct{testStruct: testStruct{M: 30}}))
+
+	models, err := q.Eject(1)
+	require.NoError(t, err)
+	require.Len(t, models, 1)
+	assert.Equal(t, 10, models[0].(*sqlStruct).M)
+
+	// Version 3 can't read version 1 and 2 records
+	v3 := newRegistry(3)
+	q, err = NewQueue(tempFile, &sqlStruct{}, Config{Registry: v3})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&sqlStruct{testStruct: testStruct{M: 4}}))
+	assert.Equal(t, 3, q.Len())
+
+	models, err = q.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 1)
+	assert.Equal(t, 4, models[0].(*sqlStruct).M)
+	assert.Equal(t, 0, q.Len())
+
+	quarantined, err := ReadQuarantine(tempFile.Name() + QuarantineSuffix)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 2)
+	assert.Equal(t, 1, quarantined[0].Version)
+	assert.Equal(t, 2, quarantined[1].Version)
+	assert.Equal(t, "test", quarantined[0].Model)
+	assert.Contains(t, quarantined[0].Reason, ballistic.ErrNoUpcaster.Error())
+	e, err := parseEnvelope(quarantined[1].Data)
+	require.NoError(t, err)
+	assert.Equal(t, `{"M":30}`, string(e.payload))
+}
+
+type keyedStruct struct {
+	testStruct
+	K string `json:"-"`
+}
+
+func (t *keyedStruct) IdempotencyKey() string {
+	return t.K
+}
+
+func (t *keyedStruct) SetIdempotencyKey(key string) {
+	t.K = key
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	for name, cfg := range map[string]Config{"raw": {}, "compressed": {Compressor: Flate, BlockSize: 64}} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+"_0.bd")
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+			require.NoError(t, err)
+			defer f.Close()
+
+			q, err := NewQueue(f, &keyedStruct{}, cfg)
+			require.NoError(t, err)
+			for i := 0; i < 10; i++ {
+				model := &keyedStruct{testStruct: testStruct{M: i}}
+				if i%2 == 0 {
+					model.K = "key-" + strconv.Itoa(i)
+				}
+				require.NoError(t, q.Push(model))
+			}
+
+			var keys []string
+			_, err = WalkFile(path, func(rec Record) error {
+				keys = append(keys, rec.Key)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"key-0", "", "key-2", "", "key-4", "", "key-6", "", "key-8", ""}, keys)
+
+			// The key is not part of the payload, the queue sets it back
+			models, err := q.Eject(-1)
+			require.NoError(t, err)
+			require.Len(t, models, 10)
+			assert.Equal(t, "key-4", models[4].(*keyedStruct).K)
+			assert.Equal(t, "", models[5].(*keyedStruct).K)
+		})
+	}
+}
+
+// rejectedStruct fails to marshal with err, or marshals to size bytes.
+type rejectedStruct struct {
+	size int
+	err  error
+}
+
+func (t rejectedStruct) MarshalBinary() (data []byte, err error) {
+	return make([]byte, t.size), t.err
+}
+
+func TestPushAll(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	for _, cfg := range []Config{{}, {Compressor: Flate, BlockSize: 1024}} {
+		name := "raw"
+		if cfg.Compressor != nil {
+			name = cfg.Compressor.Name()
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(tempDir, name+"_0.bd")
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+			require.NoError(t, err)
+			defer f.Close()
+
+			q, err := NewQueue(f, &testStruct{}, cfg)
+			require.NoError(t, err)
+
+			var models []encoding.BinaryMarshaler
+			for i := 0; i < 300; i++ {
+				models = append(models, &testStruct{M: i})
+			}
+			models[10] = rejectedStruct{size: 1 << 17}
+			models[20] = rejectedStruct{err: assert.AnError}
+
+			errs := q.PushAll(models)
+			require.Len(t, errs, len(models))
+			var recordErr *RecordError
+			assert.ErrorIs(t, errs[10], ErrRecordTooLarge)
+			assert.ErrorAs(t, errs[10], &recordErr)
+			assert.ErrorIs(t, errs[20], assert.AnError)
+			assert.ErrorAs(t, errs[20], &recordErr)
+			errs[10], errs[20] = nil, nil
+			assert.Equal(t, make([]error, len(models)), errs)
+			assert.Equal(t, 298, q.Len())
+			assert.Nil(t, q.PushAll([]encoding.BinaryMarshaler{&testStruct{M: 300}}))
+
+			info, err := WalkFile(path, nil)
+			require.NoError(t, err)
+			assert.True(t, info.Valid())
+			assert.Equal(t, 299, info.Records)
+			if cfg.Compressor != nil {
+				assert.Greater(t, info.Blocks, 1)
+			}
+
+			want := append(append(seq(0, 10), seq(11, 20)...), seq(21, 301)...)
+			q, err = NewQueue(f, &testStruct{}, cfg)
+			require.NoError(t, err)
+			assert.Equal(t, want, ejectM(t, q, -1))
+		})
+	}
+}
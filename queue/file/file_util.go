@@ -1,6 +1,9 @@
 package file
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+)
 
 // exists checks whether a file exists in the given path. It also fails if
 // the path points to a directory or there is an error when trying to check the file.
@@ -17,3 +20,60 @@ func exists(path string) bool {
 	}
 	return true
 }
+
+// DiskUsage returns the total size of the queue and quarantine files of the workspace, see ListQueues.
+func DiskUsage(workspace string) (int64, error) {
+	paths, err := ListQueues(workspace)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, path := range paths {
+		for _, p := range []string{path, path + QuarantineSuffix} {
+			info, err := os.Lstat(p)
+			if err != nil {
+				continue
+			}
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+// SyncDir commits the entries of the directory to stable storage,
+// so the files created, renamed or removed in it survive a crash.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// writeFile replaces the file at path with the data atomically, the data is synced
+// to a temporary file renamed over the path and the directory is synced.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	err := func() error {
+		f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return SyncDir(filepath.Dir(path))
+}
@@ -0,0 +1,173 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+)
+
+// Magic starts every queue file since format 2, files without it are format 1.
+var Magic = []byte("BLQF")
+
+// Layout of format 1, kept to read and upgrade old files.
+const (
+	legacyCRC32HashOffset int64 = 0
+	legacySkipAheadOffset       = legacyCRC32HashOffset + CRC32HashSize
+	legacyDataOffset            = legacySkipAheadOffset + SkipAheadSize
+)
+
+// Header is the metadata stored at the beginning of a queue file.
+type Header struct {
+	Format int `json:"format"`
+	// Model is the name the record type is registered with, empty if unknown.
+	Model   string `json:"model,omitempty"`
+	Version int    `json:"version,omitempty"`
+	// Codec is the name of the codec of the records, empty is Raw.
+	Codec string `json:"codec,omitempty"`
+	// Compression is the name of the compressor of the record blocks, empty if the file has no blocks.
+	Compression string `json:"compression,omitempty"`
+	// KeyID is the id of the key the records are encrypted with, empty if they are not.
+	KeyID string `json:"key_id,omitempty"`
+	// FileID is the random id of an encrypted file, records only authenticate
+	// in the file, under the header and at the offset they were written to.
+	FileID string `json:"file_id,omitempty"`
+}
+
+// The skip-ahead word holds the offset of the first pending frame in the low 48 bits
+// and the number of consumed records of that frame, a compressed block, in the high 16 bits.
+const skipRecordsShift = 48
+
+func splitSkip(word uint64) (offset int64, records int) {
+	return int64(word & (1<<skipRecordsShift - 1)), int(word >> skipRecordsShift)
+}
+
+func joinSkip(offset int64, records int) uint64 {
+	return uint64(offset) | uint64(records)<<skipRecordsShift
+}
+
+// head is the fixed part of a queue file.
+type head struct {
+	header    Header
+	sum       uint32
+	skipAhead int64
+	// skipRecords is the number of consumed records of the block at skipAhead.
+	skipRecords int
+	dataOffset  int64
+	// raw is the encoded header, the checksum starts with it.
+	raw []byte
+}
+
+// readHead reads the head of a queue file, io.EOF means the file is empty.
+func readHead(r io.Reader) (head, error) {
+	var h head
+	order := binary.BigEndian
+
+	buf := make([]byte, HeadSize)
+	n, err := io.ReadFull(r, buf[:MagicSize])
+	if err != nil {
+		if errors.Is(err, io.EOF) && n == 0 {
+			return h, io.EOF
+		}
+		return h, fmt.Errorf("%w: short head", ErrInvalidFile)
+	}
+
+	if !bytes.Equal(buf[:MagicSize], Magic) {
+		// Format 1 starts with the checksum
+		_, err = io.ReadFull(r, buf[MagicSize:legacyDataOffset])
+		if err != nil {
+			return h, fmt.Errorf("%w: short head", ErrInvalidFile)
+		}
+
+		h.header = Header{Format: 1}
+		h.sum = order.Uint32(buf[legacyCRC32HashOffset:])
+		h.skipAhead = int64(order.Uint64(buf[legacySkipAheadOffset:]))
+		h.dataOffset = legacyDataOffset
+		return h, nil
+	}
+
+	_, err = io.ReadFull(r, buf[MagicSize:HeadSize])
+	if err != nil {
+		return h, fmt.Errorf("%w: short head", ErrInvalidFile)
+	}
+
+	h.sum = order.Uint32(buf[CRC32HashOffset:])
+	h.skipAhead, h.skipRecords = splitSkip(order.Uint64(buf[SkipAheadOffset:]))
+	h.raw = make([]byte, order.Uint16(buf[HeaderSizeOffset:]))
+	h.dataOffset = HeadSize + int64(len(h.raw))
+
+	_, err = io.ReadFull(r, h.raw)
+	if err != nil {
+		return h, fmt.Errorf("%w: short header", ErrInvalidFile)
+	}
+
+	err = json.Unmarshal(h.raw, &h.header)
+	if err != nil {
+		return h, fmt.Errorf("%w: header: %v", ErrInvalidFile, err)
+	}
+
+	if h.header.Format > FormatVersion {
+		return h, fmt.Errorf("%w: unsupported format %d", ErrInvalidFile, h.header.Format)
+	}
+
+	return h, nil
+}
+
+// writeHead writes the head of an empty queue file.
+func writeHead(w io.Writer, header Header) (head, error) {
+	header.Format = FormatVersion
+	raw, err := json.Marshal(header)
+	if err != nil {
+		return head{}, err
+	}
+
+	if len(raw) > math.MaxUint16 {
+		return head{}, fmt.Errorf("header to large: %d over %d", len(raw), math.MaxUint16)
+	}
+
+	h := head{
+		header:     header,
+		dataOffset: HeadSize + int64(len(raw)),
+		raw:        raw,
+	}
+
+	order := binary.BigEndian
+	buf := make([]byte, HeadSize, h.dataOffset)
+	copy(buf, Magic)
+	// The checksum of a file without records covers the header only
+	h.sum = crc32.ChecksumIEEE(raw)
+	order.PutUint32(buf[CRC32HashOffset:], h.sum)
+	order.PutUint64(buf[SkipAheadOffset:], uint64(h.dataOffset))
+	order.PutUint16(buf[HeaderSizeOffset:], uint16(len(raw)))
+	h.skipAhead = h.dataOffset
+
+	_, err = w.Write(append(buf, raw...))
+	if err != nil {
+		return head{}, err
+	}
+
+	return h, nil
+}
+
+// ReadHeader returns the header of the queue file at path.
+func ReadHeader(path string) (Header, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer file.Close()
+
+	h, err := readHead(file)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Header{Format: FormatVersion}, nil
+		}
+		return Header{}, err
+	}
+	return h.header, nil
+}
@@ -0,0 +1,112 @@
+package file
+
+import (
+	"encoding/binary"
+	"github.com/farwydi/ballistic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeLegacy writes a format 1 file with the first skip records consumed.
+func writeLegacy(t *testing.T, path string, records []string, skip int) {
+	order := binary.BigEndian
+	data := make([]byte, legacyDataOffset)
+	sum := crc32.NewIEEE()
+	skipAhead := legacyDataOffset
+	for i, rec := range records {
+		meta := make([]byte, MetaElementSize)
+		order.PutUint16(meta, uint16(len(rec)))
+		data = append(data, meta...)
+		data = append(data, rec...)
+		_, _ = sum.Write([]byte(rec))
+		if i < skip {
+			skipAhead += MetaElementSize + int64(len(rec))
+		}
+	}
+	order.PutUint32(data[legacyCRC32HashOffset:], sum.Sum32())
+	order.PutUint64(data[legacySkipAheadOffset:], uint64(skipAhead))
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+}
+
+func TestHeaderAndUpgrade(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("test", 3, func() ballistic.DataModel {
+		return &sqlStruct{}
+	}))
+
+	path := filepath.Join(tempDir, "1_0.bd")
+	writeLegacy(t, path, []string{`{"M":1}`, `{"M":2}`, `{"M":3}`}, 1)
+
+	f, err := os.OpenFile(path, os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	q, err := NewQueue(f, &sqlStruct{}, Config{Registry: registry})
+	require.NoError(t, err)
+	assert.Equal(t, Header{Format: FormatVersion, Model: "test", Version: 3, Codec: "raw"}, q.Header())
+	assert.Equal(t, 2, q.Len())
+	require.NoError(t, q.Push(&sqlStruct{testStruct: testStruct{M: 4}}))
+	// The upgraded file replaced the old one, the queue owns the reopened file
+	require.NoError(t, q.Close())
+	matches, err := filepath.Glob(filepath.Join(tempDir, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, matches)
+
+	info, err := WalkFile(path, nil)
+	require.NoError(t, err)
+	assert.True(t, info.Valid())
+	assert.Equal(t, 3, info.Records)
+	assert.Equal(t, q.Header(), info.Header)
+
+	// The header is enough to decode the file
+	f, err = os.OpenFile(path, os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer f.Close()
+	q, err = OpenQueue(f, registry)
+	require.NoError(t, err)
+	models, err := q.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 3)
+	for i, m := range models {
+		assert.Equal(t, i+2, m.(*sqlStruct).M)
+	}
+
+	_, err = OpenQueue(f, ballistic.NewRegistry())
+	assert.ErrorIs(t, err, ballistic.ErrUnknownModel)
+
+	other := ballistic.NewRegistry()
+	require.NoError(t, other.Register("other", 1, func() ballistic.DataModel {
+		return &sqlStruct{}
+	}))
+	_, err = NewQueue(f, &sqlStruct{}, Config{Registry: other})
+	assert.ErrorIs(t, err, ErrModelMismatch)
+}
+
+func TestUpgradeBrokenLegacy(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	path := filepath.Join(tempDir, "1_0.bd")
+	writeLegacy(t, path, []string{`{"M":1}`, `{"M":2}`}, 0)
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, stat.Size()-1))
+
+	f, err := os.OpenFile(path, os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = NewQueue(f, &testStruct{})
+	assert.ErrorIs(t, err, ErrInvalidFile)
+}
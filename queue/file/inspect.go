@@ -0,0 +1,342 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Record is a framed record of a queue file.
+type Record struct {
+	// Offset of the record meta in the file.
+	Offset int64
+	// Data is the payload encoded by the model.
+	Data []byte
+	// Version of the model that encoded the payload, 0 if unknown.
+	Version int
+	// Key is the idempotency key of the record, empty if it has none.
+	Key string
+	// Consumed is true for records before the skip-ahead pointer.
+	Consumed bool
+	// Index is the position of the record in its compressed block, all records of a block share the Offset.
+	Index int
+	// Err is set if the record envelope is broken or doesn't authenticate, Data is the stored record then.
+	Err error
+}
+
+// Info describes the state of a queue file.
+type Info struct {
+	Header    Header
+	Size      int64
+	Sum       uint32
+	ActualSum uint32
+	SkipAhead int64
+	// SkipRecords is the number of consumed records of the block at SkipAhead.
+	SkipRecords int
+	// Blocks is the number of compressed blocks.
+	Blocks  int
+	Records int
+	Pending int
+	// PendingBytes is the payload size of the pending records.
+	PendingBytes int64
+	// ValidSize is the offset where the last complete record ends.
+	ValidSize int64
+	// Err is the framing error, nil if every record is complete.
+	Err error
+}
+
+// Valid reports whether the framing is complete and the checksum matches.
+func (i Info) Valid() bool {
+	return i.Err == nil && i.Sum == i.ActualSum
+}
+
+// Walk reads a queue file from the beginning and calls fn for every complete record.
+// Record data is only valid during the call. Framing errors don't stop Walk,
+// they are reported in Info.Err, errors returned by fn do.
+// Records of encrypted files are decrypted with the keys, without them they are reported with Err.
+func Walk(r io.Reader, fn func(rec Record) error, keys ...KeyProvider) (Info, error) {
+	var info Info
+	order := binary.BigEndian
+	br := bufio.NewReader(r)
+
+	h, err := readHead(br)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return info, nil
+		}
+		info.Err = err
+		return info, nil
+	}
+
+	aead, keyErr := newAEAD(keyProvider(keys), h.header.KeyID)
+
+	info.Header = h.header
+	info.Sum = h.sum
+	info.SkipAhead = h.skipAhead
+	info.SkipRecords = h.skipRecords
+	info.Size = h.dataOffset
+	info.ValidSize = h.dataOffset
+
+	emit := func(rec Record) error {
+		info.Records++
+		if !rec.Consumed {
+			info.Pending++
+			info.PendingBytes += int64(len(rec.Data))
+		}
+		if fn != nil {
+			return fn(rec)
+		}
+		return nil
+	}
+	// Raw records are held back while a block written after them may replace them
+	hold := h.header.Format >= 4 && h.header.Compression != ""
+	var held []Record
+	release := func(before int64) error {
+		i := 0
+		for ; i < len(held) && held[i].Offset < before; i++ {
+			if err := emit(held[i]); err != nil {
+				return err
+			}
+		}
+		held = held[i:]
+		return nil
+	}
+
+	sum := crc32.NewIEEE()
+	_, _ = sum.Write(h.raw)
+	meta := make([]byte, MetaElementSize)
+	buf := make([]byte, 0, 1024)
+	offset := h.dataOffset
+	for {
+		n, err := io.ReadFull(br, meta)
+		info.Size += int64(n)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				info.Err = fmt.Errorf("%w: truncated meta at %d", ErrInvalidFile, offset)
+			}
+			break
+		}
+
+		size := int(order.Uint16(meta))
+		if cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+
+		n, err = io.ReadFull(br, buf)
+		info.Size += int64(n)
+		if err != nil {
+			info.Err = fmt.Errorf("%w: truncated record at %d", ErrInvalidFile, offset)
+			break
+		}
+		_, _ = sum.Write(buf)
+
+		rec := Record{
+			Offset:   offset,
+			Data:     buf,
+			Version:  h.header.Version,
+			Consumed: offset < info.SkipAhead,
+		}
+		var records [][]byte
+		replaced := int64(0)
+		switch {
+		case h.header.Format == 1:
+			rec.Version = 0
+		case h.header.Format >= 3:
+			data, err := buf, keyErr
+			if err == nil && aead != nil {
+				data, err = open(aead, buf, recordAAD(h.header, h.raw, offset))
+			}
+			if err != nil {
+				rec.Err = err
+				break
+			}
+			e, err := parseEnvelope(data)
+			if err != nil {
+				rec.Err = err
+				break
+			}
+			if e.block {
+				records, replaced, rec.Err = walkBlock(h.header, e.payload)
+				info.Blocks++
+				break
+			}
+			rec.Data = e.payload
+			rec.Version = e.version
+			rec.Key = e.key
+		}
+		info.ValidSize = offset + MetaElementSize + int64(size)
+
+		if replaced > 0 {
+			if err := release(offset - replaced); err != nil {
+				return info, err
+			}
+			held = held[:0]
+		}
+		if hold {
+			if err := release(offset - maxReplaced); err != nil {
+				return info, err
+			}
+			if records == nil {
+				rec.Data = append([]byte(nil), rec.Data...)
+				held = append(held, rec)
+				offset = info.ValidSize
+				continue
+			}
+		}
+
+		if records == nil {
+			records = [][]byte{nil}
+		}
+		for i, data := range records {
+			if data != nil {
+				rec.Index = i
+				rec.Consumed = offset < info.SkipAhead || (offset == info.SkipAhead && i < info.SkipRecords)
+				e, err := parseEnvelope(data)
+				rec.Data, rec.Version, rec.Key, rec.Err = e.payload, e.version, e.key, err
+				if err != nil {
+					rec.Data = data
+				}
+			}
+
+			if err := emit(rec); err != nil {
+				return info, err
+			}
+		}
+		offset = info.ValidSize
+	}
+	if err := release(info.ValidSize); err != nil {
+		return info, err
+	}
+
+	// Count the tail of a broken file
+	rest, _ := io.Copy(ioutil.Discard, br)
+	info.Size += rest
+	info.ActualSum = sum.Sum32()
+
+	return info, nil
+}
+
+// walkBlock returns the record envelopes of a compressed block and the size of the raw frames it replaces.
+func walkBlock(header Header, payload []byte) ([][]byte, int64, error) {
+	b, err := parseBlock(payload, header.Format)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c, err := CompressorByName(header.Compression)
+	if err != nil {
+		return nil, b.replaced, err
+	}
+
+	records, err := readBlock(b, c)
+	return records, b.replaced, err
+}
+
+// keyProvider returns the optional provider of the keys.
+func keyProvider(keys []KeyProvider) KeyProvider {
+	if len(keys) < 1 {
+		return nil
+	}
+	return keys[0]
+}
+
+// WalkFile is Walk over the file at path.
+func WalkFile(path string, fn func(rec Record) error, keys ...KeyProvider) (Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer file.Close()
+
+	return Walk(file, fn, keys...)
+}
+
+// raw stores record data as is.
+type raw []byte
+
+func (r raw) MarshalBinary() ([]byte, error) {
+	return r, nil
+}
+
+func (r *raw) UnmarshalBinary(data []byte) error {
+	*r = append((*r)[:0], data...)
+	return nil
+}
+
+// Salvage copies every complete pending record of the file at src into a new queue file at dst.
+// Checksum mismatches are ignored, so it recovers what is left of a broken file.
+// An encrypted file needs the keys, the records are encrypted with the current key in dst.
+func Salvage(src, dst string, keys ...KeyProvider) (Info, error) {
+	header, err := ReadHeader(src)
+	if err != nil {
+		header = Header{}
+	}
+
+	_, err = newAEAD(keyProvider(keys), header.KeyID)
+	if err != nil {
+		return Info{}, err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return Info{}, err
+	}
+	defer out.Close()
+
+	q, err := newQueue(out, func() interface{} {
+		return &raw{}
+	}, header, Config{Keys: keyProvider(keys)})
+	if err != nil {
+		return Info{}, err
+	}
+
+	return WalkFile(src, func(rec Record) error {
+		if rec.Consumed || rec.Err != nil {
+			return nil
+		}
+		return q.push(envelope{version: rec.Version, key: rec.Key, payload: rec.Data})
+	}, keys...)
+}
+
+// Compact rewrites the file at path without the consumed records.
+// The file must not be open by a queue.
+func Compact(path string, keys ...KeyProvider) (before, after int64, err error) {
+	info, err := WalkFile(path, nil, keys...)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !info.Valid() {
+		if info.Err != nil {
+			return 0, 0, info.Err
+		}
+		return 0, 0, ErrInvalidFile
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".compact")
+	_ = os.Remove(tmp)
+	_, err = Salvage(path, tmp, keys...)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, err
+	}
+
+	stat, err := os.Stat(tmp)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, err
+	}
+
+	return info.Size, stat.Size(), nil
+}
@@ -0,0 +1,77 @@
+package file
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkSalvageCompact(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	path := filepath.Join(tempDir, "1_0.bd")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+
+	q, err := NewQueue(f, &testStruct{})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, q.Push(&testStruct{M: i}))
+	}
+	_, err = q.Eject(2)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	var pending []string
+	info, err := WalkFile(path, func(rec Record) error {
+		if !rec.Consumed {
+			pending = append(pending, string(rec.Data))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, info.Valid())
+	assert.Equal(t, 5, info.Records)
+	assert.Equal(t, 3, info.Pending)
+	assert.Equal(t, []string{`{"M":2}`, `{"M":3}`, `{"M":4}`}, pending)
+
+	before, after, err := Compact(path)
+	require.NoError(t, err)
+	assert.Less(t, after, before)
+
+	info, err = WalkFile(path, nil)
+	require.NoError(t, err)
+	assert.True(t, info.Valid())
+	assert.Equal(t, 3, info.Records)
+	assert.Equal(t, 3, info.Pending)
+
+	// Cut the last record in half
+	require.NoError(t, os.Truncate(path, info.Size-3))
+	info, err = WalkFile(path, nil)
+	require.NoError(t, err)
+	assert.False(t, info.Valid())
+	assert.ErrorIs(t, info.Err, ErrInvalidFile)
+	assert.Equal(t, 2, info.Records)
+
+	repaired := filepath.Join(tempDir, "2_0.bd")
+	_, err = Salvage(path, repaired)
+	require.NoError(t, err)
+
+	f, err = os.OpenFile(repaired, os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	defer f.Close()
+	q, err = NewQueue(f, &testStruct{})
+	require.NoError(t, err)
+	models, err := q.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 2)
+	assert.Equal(t, 2, models[0].(*testStruct).M)
+	assert.Equal(t, 3, models[1].(*testStruct).M)
+}
@@ -5,57 +5,269 @@ import (
 	"fmt"
 	"github.com/farwydi/ballistic"
 	"hash/adler32"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strconv"
 )
 
+var fileNameExtractor = regexp.MustCompile(`^(.+)_(\d+)\.(bd|carapted)$`)
+
 func NewQueueByModel(model ballistic.DataModel, config ...Config) (*Queue, error) {
 	// Set default config
 	cfg := configDefault(config...)
 
 	return (&queueLoader{
-		cfg:               cfg,
-		fileNameExtractor: regexp.MustCompile(`^(\d+)_(\d+)\.(bd|carapted)$`),
+		cfg: cfg,
 	}).load(model)
 }
 
 type queueLoader struct {
-	cfg               Config
-	fileNameExtractor *regexp.Regexp
+	cfg Config
 }
 
-func (q *queueLoader) load(model ballistic.DataModel) (*Queue, error) {
+// legacyQueueName returns the adler32 name used before the manifest.
+func legacyQueueName(query string) string {
 	h := adler32.New()
-	_, _ = h.Write([]byte(model.SQL()))
+	_, _ = h.Write([]byte(query))
+	return strconv.FormatUint(uint64(h.Sum32()), 10)
+}
 
-	fName := fmt.Sprintf("%d_0.bd", h.Sum32())
-	fPath := filepath.Join(q.cfg.Workspace, fName)
-	file, err := os.OpenFile(fPath, os.O_CREATE|os.O_RDWR, os.ModePerm)
+// List returns the paths of the queue files in the workspace.
+func List(workspace string) ([]string, error) {
+	infos, err := ioutil.ReadDir(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() && fileNameExtractor.MatchString(info.Name()) {
+			paths = append(paths, filepath.Join(workspace, info.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// ListQueues returns the paths of the queue files of the workspace: the ones named in its
+// manifest and the ones named by the former adler32 scheme. Other files are left alone,
+// the workspace may be shared with other programs.
+func ListQueues(workspace string) ([]string, error) {
+	paths, err := List(workspace)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ReadManifest(workspace)
 	if err != nil {
 		return nil, err
 	}
 
-	queue, err := NewQueue(file, model)
+	var queues []string
+	for _, path := range paths {
+		name, _, _, err := ParseFileName(filepath.Base(path))
+		if err != nil {
+			continue
+		}
+		if _, ok := m.Queues[name]; ok || legacyName.MatchString(name) {
+			queues = append(queues, path)
+		}
+	}
+	return queues, nil
+}
+
+// MatchModel returns the name of the registered model the queue file belongs to.
+// The name is taken from the file header, files without it are matched by their query
+// looked up in the workspace manifest, legacy names are matched by their hash.
+func MatchModel(path string, registry *ballistic.Registry) (string, error) {
+	fne := fileNameExtractor.FindStringSubmatch(filepath.Base(path))
+	if len(fne) != 4 {
+		return "", fmt.Errorf("bad name: '%s'", filepath.Base(path))
+	}
+
+	header, err := ReadHeader(path)
+	if err == nil && header.Model != "" {
+		if _, err := registry.Version(header.Model); err != nil {
+			return "", err
+		}
+		return header.Model, nil
+	}
+
+	m, err := ReadManifest(filepath.Dir(path))
 	if err != nil {
-		if errors.Is(err, ErrInvalidFile) {
-			err := q.markCarapted(file)
-			if err != nil {
-				return nil, err
-			}
+		return "", err
+	}
+	entry, inManifest := m.Lookup(path)
+	isLegacy := legacyName.MatchString(fne[1])
 
-			file, err = os.OpenFile(fPath, os.O_CREATE|os.O_RDWR, os.ModePerm)
-			queue, err = NewQueue(file, model)
-			if err != nil {
-				return nil, err
-			}
+	for _, name := range registry.Names() {
+		model, err := registry.New(name)
+		if err != nil {
+			return "", err
 		}
+
+		switch {
+		case inManifest && entry.SQL == model.SQL():
+			return name, nil
+		case isLegacy && legacyQueueName(model.SQL()) == fne[1]:
+			return name, nil
+		case !inManifest && !isLegacy && QueueName(model.SQL()) == fne[1]:
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no registered model for '%s'", ballistic.ErrUnknownModel, filepath.Base(path))
+}
+
+func (q *queueLoader) load(model ballistic.DataModel) (*Queue, error) {
+	modelName := reflect.TypeOf(model).String()
+	if w, ok := model.(ballistic.Wrapper); ok {
+		modelName = reflect.TypeOf(w.Unwrap()).String()
+	}
+	if q.cfg.Registry != nil {
+		if registered, _, ok := q.cfg.Registry.Lookup(model); ok {
+			modelName = registered
+		}
+	}
+
+	name := QueueName(model.SQL()) + q.cfg.Suffix
+	orphans, err := register(q.cfg.Workspace, name, q.cfg.Suffix == "", ManifestEntry{
+		SQL:    model.SQL(),
+		Model:  modelName,
+		Format: FormatVersion,
+	})
+	if err != nil {
 		return nil, err
 	}
+
+	fName := q.buildName(name, "bd", 0)
+	fPath := filepath.Join(q.cfg.Workspace, fName)
+	file, err := q.open(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := NewQueue(file, model, q.cfg)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidFile) {
+			return nil, err
+		}
+
+		err := q.markCarapted(file)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err = q.open(fPath)
+		if err != nil {
+			return nil, err
+		}
+		queue, err = NewQueue(file, model, q.cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, orphan := range orphans {
+		err = q.merge(queue, model, orphan)
+		if err != nil {
+			_ = queue.Close()
+			return nil, err
+		}
+	}
 	return queue, nil
 }
 
+// open opens the queue file, the workspace is synced when the file is created
+// so the file outlives a crash once its records are synced.
+func (q *queueLoader) open(path string) (*os.File, error) {
+	created := !exists(path)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := SyncDir(q.cfg.Workspace); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+// merge moves the pending records of a legacy queue file whose new name was taken into the queue,
+// it fails if the queue can't be written. The records are read from a copy, the file is left
+// untouched until they are synced and removed then. A file that can't be fully read is marked carapted.
+func (q *queueLoader) merge(queue *Queue, model ballistic.DataModel, path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	models, readErr := q.readOrphan(model, path)
+	if readErr != nil && !errors.Is(readErr, ErrInvalidFile) {
+		_ = file.Close()
+		return readErr
+	}
+	for _, m := range models {
+		if err := queue.PushValue(m); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("merging '%s': %w", filepath.Base(path), err)
+		}
+	}
+	if err := queue.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if readErr != nil {
+		return q.markCarapted(file)
+	}
+
+	_ = file.Close()
+	err = os.Remove(path)
+	if err != nil {
+		return err
+	}
+	return SyncDir(q.cfg.Workspace)
+}
+
+// readOrphan returns the pending records of the legacy queue file read from a copy of it,
+// ErrInvalidFile is returned with the records read if the file can't be fully read.
+func (q *queueLoader) readOrphan(model ballistic.DataModel, path string) ([]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmp := filepath.Join(q.cfg.Workspace, "."+filepath.Base(path)+".merge")
+	defer os.Remove(tmp)
+	if err := ioutil.WriteFile(tmp, data, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(tmp, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg := q.cfg
+	if cfg.Quarantine == nil {
+		cfg.Quarantine = NewFileQuarantine(path + QuarantineSuffix)
+	}
+	orphan, err := NewQueue(file, model, cfg)
+	if err != nil {
+		return nil, err
+	}
+	// An upgrade reopens the copy
+	defer orphan.Close()
+
+	models, err := orphan.Eject(-1)
+	if err != nil && !errors.Is(err, ErrInvalidFile) {
+		err = fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	return models, err
+}
+
 func (q *queueLoader) markCarapted(file *os.File) error {
 	err := file.Close()
 	if err != nil {
@@ -76,7 +288,13 @@ func (q *queueLoader) buildName(name, t string, n int) string {
 }
 
 func (q *queueLoader) extractName(fileName string) (name, t string, n int, err error) {
-	fne := q.fileNameExtractor.FindStringSubmatch(fileName)
+	return ParseFileName(fileName)
+}
+
+// ParseFileName splits the base name of a queue file into the queue name,
+// the type (bd or carapted) and the history number.
+func ParseFileName(fileName string) (name, t string, n int, err error) {
+	fne := fileNameExtractor.FindStringSubmatch(fileName)
 	if len(fne) != 4 {
 		return "", "", 0, fmt.Errorf("bad name: '%s'", fileName)
 	}
@@ -0,0 +1,178 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ManifestName is the name of the workspace manifest.
+const ManifestName = "manifest.json"
+
+var (
+	ErrCollision = errors.New("queue name collision")
+
+	tableExtractor = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+(?:TABLE\s+)?([^\s(]+)`)
+	tableQuotes    = strings.NewReplacer("`", "", `"`, "")
+	tableCleaner   = regexp.MustCompile(`[^A-Za-z0-9_.]+`)
+	legacyName     = regexp.MustCompile(`^\d+$`)
+
+	// manifestMx serializes manifest updates of all workspaces in the process.
+	manifestMx sync.Mutex
+)
+
+// ManifestEntry describes the queue files sharing one name.
+type ManifestEntry struct {
+	SQL   string `json:"sql"`
+	Model string `json:"model"`
+	// Format is the file queue format version.
+	Format int `json:"format"`
+	// Legacy is the adler32 name the files were migrated from.
+	Legacy string `json:"legacy,omitempty"`
+}
+
+// Manifest maps the queue names of a workspace to their queries.
+type Manifest struct {
+	Queues map[string]ManifestEntry `json:"queues"`
+}
+
+// QueueName returns the name shared by the queue files of the query:
+// the target table followed by a hash of the whole query.
+func QueueName(query string) string {
+	table := "queue"
+	if m := tableExtractor.FindStringSubmatch(query); len(m) == 2 {
+		table = strings.Trim(tableCleaner.ReplaceAllString(tableQuotes.Replace(m[1]), "_"), "_.")
+	}
+	if len(table) > 64 {
+		table = table[:64]
+	}
+	if table == "" {
+		table = "queue"
+	}
+
+	sum := sha256.Sum256([]byte(query))
+	return table + "-" + hex.EncodeToString(sum[:8])
+}
+
+// ReadManifest reads the manifest of the workspace, a missing manifest is empty.
+func ReadManifest(workspace string) (*Manifest, error) {
+	m := &Manifest{Queues: map[string]ManifestEntry{}}
+
+	data, err := ioutil.ReadFile(filepath.Join(workspace, ManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	if m.Queues == nil {
+		m.Queues = map[string]ManifestEntry{}
+	}
+	return m, nil
+}
+
+func (m *Manifest) write(workspace string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(filepath.Join(workspace, ManifestName), data, 0644)
+}
+
+// Lookup returns the manifest entry of the queue file at path.
+func (m *Manifest) Lookup(path string) (ManifestEntry, bool) {
+	fne := fileNameExtractor.FindStringSubmatch(filepath.Base(path))
+	if len(fne) != 4 {
+		return ManifestEntry{}, false
+	}
+	entry, ok := m.Queues[fne[1]]
+	return entry, ok
+}
+
+// register adds the query to the manifest of the workspace,
+// and moves the files named by the former adler32 scheme if legacy is set.
+// It returns the paths of the legacy queue files whose new name is taken.
+func register(workspace, name string, legacy bool, entry ManifestEntry) (orphans []string, err error) {
+	manifestMx.Lock()
+	defer manifestMx.Unlock()
+
+	m, err := ReadManifest(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, ok := m.Queues[name]
+	if ok && prev.SQL != entry.SQL {
+		return nil, fmt.Errorf("%w: '%s' is used by %q", ErrCollision, name, prev.SQL)
+	}
+	entry.Legacy = prev.Legacy
+
+	if legacy {
+		adler := legacyQueueName(entry.SQL)
+		var migrated bool
+		migrated, orphans, err = migrate(workspace, adler, name)
+		if err != nil {
+			return nil, err
+		}
+		if migrated {
+			entry.Legacy = adler
+		}
+	}
+
+	if ok && prev == entry {
+		return orphans, nil
+	}
+
+	m.Queues[name] = entry
+	return orphans, m.write(workspace)
+}
+
+// migrate renames the files of the legacy queue. It returns the paths of the
+// queue files whose new name is taken, they stay in place.
+func migrate(workspace, legacy, name string) (migrated bool, orphans []string, err error) {
+	infos, err := ioutil.ReadDir(workspace)
+	if err != nil {
+		return false, nil, err
+	}
+
+	for _, info := range infos {
+		fne := fileNameExtractor.FindStringSubmatch(info.Name())
+		if len(fne) != 4 || fne[1] != legacy {
+			continue
+		}
+
+		prev := filepath.Join(workspace, info.Name())
+		next := filepath.Join(workspace, name+"_"+fne[2]+"."+fne[3])
+		if exists(next) {
+			if fne[3] == "bd" {
+				orphans = append(orphans, prev)
+			}
+			continue
+		}
+
+		err = os.Rename(prev, next)
+		if err != nil {
+			return migrated, orphans, err
+		}
+		migrated = true
+	}
+
+	if migrated {
+		err = SyncDir(workspace)
+	}
+	return migrated, orphans, err
+}
@@ -0,0 +1,160 @@
+package file
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type sqlStruct struct {
+	testStruct
+	Q string
+}
+
+func (t *sqlStruct) SQL() string {
+	return t.Q
+}
+
+func TestQueueName(t *testing.T) {
+	assert.Regexp(t, `^test\.table_1-[0-9a-f]{16}$`,
+		QueueName("INSERT INTO test.table_1 (a, b) VALUES (?, ?)"))
+	assert.Regexp(t, `^db\.t-[0-9a-f]{16}$`,
+		QueueName("insert into `db`.`t`(a) values (?)"))
+	assert.Regexp(t, `^queue-[0-9a-f]{16}$`, QueueName("test"))
+	assert.NotEqual(t, QueueName("INSERT INTO t (a) VALUES (?)"), QueueName("INSERT INTO t (b) VALUES (?)"))
+}
+
+func TestManifestMigration(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	model := &sqlStruct{Q: "INSERT INTO test.table (m) VALUES (?)"}
+
+	// A spool written by the adler32 naming
+	legacy := filepath.Join(tempDir, legacyQueueName(model.SQL())+"_0.bd")
+	f, err := os.OpenFile(legacy, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	q, err := NewQueue(f, model)
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&testStruct{M: 7}))
+	require.NoError(t, f.Close())
+
+	q, err = NewQueueByModel(model, Config{Workspace: tempDir})
+	require.NoError(t, err)
+	assert.False(t, exists(legacy))
+	assert.Equal(t, 1, q.Len())
+	require.NoError(t, q.Close())
+
+	paths, err := List(tempDir)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	assert.Equal(t, QueueName(model.SQL())+"_0.bd", filepath.Base(paths[0]))
+
+	m, err := ReadManifest(tempDir)
+	require.NoError(t, err)
+	entry, ok := m.Lookup(paths[0])
+	require.True(t, ok)
+	assert.Equal(t, ManifestEntry{
+		SQL:    model.SQL(),
+		Model:  "*file.sqlStruct",
+		Format: FormatVersion,
+		Legacy: legacyQueueName(model.SQL()),
+	}, entry)
+
+	// An older binary wrote the legacy file again, it is merged
+	f, err = os.OpenFile(legacy, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	q, err = NewQueue(f, model)
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&testStruct{M: 8}))
+	require.NoError(t, f.Close())
+
+	q, err = NewQueueByModel(model, Config{Workspace: tempDir})
+	require.NoError(t, err)
+	assert.False(t, exists(legacy))
+	models, err := q.Eject(-1)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{&sqlStruct{testStruct: testStruct{M: 7}}, &sqlStruct{testStruct: testStruct{M: 8}}}, models)
+
+	// Another query claims the same name
+	m.Queues[QueueName(model.SQL())] = ManifestEntry{SQL: "INSERT INTO other (m) VALUES (?)"}
+	require.NoError(t, m.write(tempDir))
+	_, err = NewQueueByModel(model, Config{Workspace: tempDir})
+	assert.ErrorIs(t, err, ErrCollision)
+}
+
+func TestMergeFailure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	model := &sqlStruct{Q: "INSERT INTO test.table (m) VALUES (?)"}
+	q, err := NewQueueByModel(model, Config{Workspace: tempDir})
+	require.NoError(t, err)
+	path := q.file.Name()
+	require.NoError(t, q.Close())
+
+	// An older binary wrote the legacy file
+	legacy := filepath.Join(tempDir, legacyQueueName(model.SQL())+"_0.bd")
+	f, err := os.OpenFile(legacy, os.O_CREATE|os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	q, err = NewQueue(f, model)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, q.Push(&testStruct{M: i}))
+	}
+	require.NoError(t, f.Close())
+
+	// The queue can't be written, the legacy file keeps its records
+	f, err = os.Open(path)
+	require.NoError(t, err)
+	readOnly, err := NewQueue(f, model)
+	require.NoError(t, err)
+	loader := &queueLoader{cfg: configDefault(Config{Workspace: tempDir})}
+	assert.Error(t, loader.merge(readOnly, model, legacy))
+	require.NoError(t, f.Close())
+
+	info, err := WalkFile(legacy, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, info.Pending)
+
+	q, err = NewQueueByModel(model, Config{Workspace: tempDir})
+	require.NoError(t, err)
+	defer q.Close()
+	assert.False(t, exists(legacy))
+	assert.Equal(t, 3, q.Len())
+}
+
+func TestQueueSuffix(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	model := &sqlStruct{Q: "INSERT INTO test.table (m) VALUES (?)"}
+	legacy := filepath.Join(tempDir, legacyQueueName(model.SQL())+"_0.bd")
+	require.NoError(t, ioutil.WriteFile(legacy, nil, 0644))
+
+	// A suffixed queue has files of its own and leaves the legacy ones to the plain queue
+	q, err := NewQueueByModel(model, Config{Workspace: tempDir, Suffix: "-p1"})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(&testStruct{M: 7}))
+	assert.True(t, exists(legacy))
+
+	path := filepath.Join(tempDir, QueueName(model.SQL())+"-p1_0.bd")
+	m, err := ReadManifest(tempDir)
+	require.NoError(t, err)
+	entry, ok := m.Lookup(path)
+	require.True(t, ok)
+	assert.Equal(t, model.SQL(), entry.SQL)
+	assert.Empty(t, entry.Legacy)
+}
@@ -0,0 +1,101 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// QuarantineSuffix is appended to the queue file name by the default quarantine.
+const QuarantineSuffix = ".quarantine"
+
+// QuarantineRecord is a record a queue could not decode.
+type QuarantineRecord struct {
+	Time    time.Time `json:"time"`
+	Model   string    `json:"model,omitempty"`
+	Version int       `json:"version"`
+	Reason  string    `json:"reason"`
+	Data    []byte    `json:"data"`
+	// KeyID is set for the records of an encrypted file, Data is sealed with the key then,
+	// see OpenQuarantined. A record failing authentication keeps the frame stored in the file.
+	KeyID string `json:"key_id,omitempty"`
+	// FileID and Offset locate the record in the encrypted file it comes from.
+	FileID string `json:"file_id,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+// OpenQuarantined returns the data of the quarantined record, decrypted with its key
+// if it comes from an encrypted file.
+func OpenQuarantined(rec QuarantineRecord, keys KeyProvider) ([]byte, error) {
+	if rec.KeyID == "" {
+		return rec.Data, nil
+	}
+	aead, err := newAEAD(keys, rec.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, rec.Data, nil)
+}
+
+// Quarantine keeps the records a queue could not decode,
+// so one bad record doesn't block the records behind it.
+type Quarantine interface {
+	Put(rec QuarantineRecord) error
+}
+
+// FileQuarantine appends quarantined records to a file as JSON lines.
+type FileQuarantine struct {
+	path string
+	mx   sync.Mutex
+}
+
+func NewFileQuarantine(path string) *FileQuarantine {
+	return &FileQuarantine{path: path}
+}
+
+func (q *FileQuarantine) Put(rec QuarantineRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	file, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// ReadQuarantine reads the records of the quarantine file at path.
+func ReadQuarantine(path string) ([]QuarantineRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []QuarantineRecord
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var rec QuarantineRecord
+		err := json.Unmarshal(sc.Bytes(), &rec)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
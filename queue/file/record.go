@@ -0,0 +1,87 @@
+package file
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Flags of the record envelope, each announces a field following the flags byte.
+const (
+	flagVersion byte = 1 << iota
+	// flagBlock marks a compressed block of records, it has no other flags.
+	flagBlock
+	// flagKey announces the idempotency key of the record, since format 4.
+	flagKey
+)
+
+// envelope wraps the payload of a record with its metadata, since format 3.
+type envelope struct {
+	// version of the model that encoded the payload, 0 if unknown.
+	version int
+	// key is the idempotency key of the record, empty if it has none.
+	key     string
+	payload []byte
+	// block is set for compressed blocks, the payload is the block then.
+	block bool
+}
+
+func (e envelope) append(dst []byte) []byte {
+	var flags byte
+	if e.version > 0 {
+		flags |= flagVersion
+	}
+	if e.key != "" {
+		flags |= flagKey
+	}
+
+	dst = append(dst, flags)
+	var buf [binary.MaxVarintLen64]byte
+	if flags&flagVersion != 0 {
+		n := binary.PutUvarint(buf[:], uint64(e.version))
+		dst = append(dst, buf[:n]...)
+	}
+	if flags&flagKey != 0 {
+		n := binary.PutUvarint(buf[:], uint64(len(e.key)))
+		dst = append(append(dst, buf[:n]...), e.key...)
+	}
+	return append(dst, e.payload...)
+}
+
+func parseEnvelope(data []byte) (envelope, error) {
+	var e envelope
+	if len(data) < 1 {
+		return e, fmt.Errorf("%w: empty record", ErrInvalidFile)
+	}
+
+	flags := data[0]
+	data = data[1:]
+	if flags == flagBlock {
+		e.block = true
+		e.payload = data
+		return e, nil
+	}
+	if flags&^(flagVersion|flagKey) != 0 {
+		return e, fmt.Errorf("%w: unknown record flags %#x", ErrInvalidFile, flags)
+	}
+
+	if flags&flagVersion != 0 {
+		version, n := binary.Uvarint(data)
+		if n <= 0 {
+			return e, fmt.Errorf("%w: bad record version", ErrInvalidFile)
+		}
+		e.version = int(version)
+		data = data[n:]
+	}
+
+	if flags&flagKey != 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return e, fmt.Errorf("%w: bad record key", ErrInvalidFile)
+		}
+		e.key = string(data[n : n+int(size)])
+		data = data[n+int(size):]
+	}
+
+	e.payload = data
+	return e, nil
+}
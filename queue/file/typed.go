@@ -0,0 +1,69 @@
+package file
+
+import "os"
+
+// NewTypedQueue opens the queue stored in file with records of type *T,
+// they are created without reflection. The config is applied as by NewQueue.
+func NewTypedQueue[T any](file *os.File, config ...Config) (*TypedQueue[T], error) {
+	cfg := configDefault(config...)
+
+	q, err := newQueue(file, func() interface{} {
+		return new(T)
+	}, newHeader(new(T), cfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedQueue[T]{q: q}, nil
+}
+
+// TypedQueue is a Queue of *T records.
+type TypedQueue[T any] struct {
+	q *Queue
+}
+
+// Queue returns the untyped queue, it shares the file with the typed one.
+func (t *TypedQueue[T]) Queue() *Queue {
+	return t.q
+}
+
+func (t *TypedQueue[T]) Push(model *T) error {
+	return t.q.PushValue(model)
+}
+
+// PushAll pushes the models at once like Queue.PushAll.
+func (t *TypedQueue[T]) PushAll(models []*T) []error {
+	values := make([]interface{}, len(models))
+	for i, model := range models {
+		values[i] = model
+	}
+	return t.q.pushValues(values)
+}
+
+// Sync is Queue.Sync.
+func (t *TypedQueue[T]) Sync() error {
+	return t.q.Sync()
+}
+
+// Eject removes up to limit records from the head of the queue like Queue.Eject.
+func (t *TypedQueue[T]) Eject(limit int) (models []*T, err error) {
+	ejected, err := t.q.Eject(limit)
+	if len(ejected) == 0 {
+		return nil, err
+	}
+
+	models = make([]*T, len(ejected))
+	for i, model := range ejected {
+		models[i] = model.(*T)
+	}
+	return models, err
+}
+
+func (t *TypedQueue[T]) Len() int {
+	return t.q.Len()
+}
+
+// CompressionRatio is Queue.CompressionRatio.
+func (t *TypedQueue[T]) CompressionRatio() float64 {
+	return t.q.CompressionRatio()
+}
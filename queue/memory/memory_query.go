@@ -1,58 +1,103 @@
 package memory
 
 import (
-	"container/list"
 	"encoding"
 	"sync"
 )
 
 func NewQueue() *Queue {
 	return &Queue{
-		buffer: list.New(),
+		typed: NewTypedQueue[encoding.BinaryMarshaler](),
 	}
 }
 
+// Queue keeps any models, it adapts a TypedQueue of BinaryMarshalers.
 type Queue struct {
-	buffer *list.List
-	mx     sync.Mutex
+	typed *TypedQueue[encoding.BinaryMarshaler]
 }
 
 func (m *Queue) Eject(limit int) (models []interface{}, err error) {
+	ejected, _ := m.typed.Eject(limit)
+	if len(ejected) == 0 {
+		return nil, nil
+	}
+
+	models = make([]interface{}, len(ejected))
+	for i, model := range ejected {
+		models[i] = model
+	}
+	return models, nil
+}
+
+func (m *Queue) Push(model encoding.BinaryMarshaler) error {
+	return m.typed.Push(model)
+}
+
+func (m *Queue) Len() int {
+	return m.typed.Len()
+}
+
+func NewTypedQueue[T any]() *TypedQueue[T] {
+	return &TypedQueue[T]{}
+}
+
+// TypedQueue keeps models of type T in memory.
+type TypedQueue[T any] struct {
+	buffer []T
+	head   int
+	mx     sync.Mutex
+}
+
+func (m *TypedQueue[T]) Eject(limit int) (models []T, err error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
 
-	if limit > m.buffer.Len() {
-		limit = m.buffer.Len()
+	size := len(m.buffer) - m.head
+	if limit > size {
+		limit = size
 	}
 
 	if limit < 0 {
-		limit = m.buffer.Len()
+		limit = size
 	}
 
 	if limit == 0 {
 		return nil, nil
 	}
 
-	models = make([]interface{}, 0, limit)
-	it := 0
-	for e := m.buffer.Front(); e != nil && it < limit; {
-		cur := e
-		e = e.Next()
-		models = append(models, m.buffer.Remove(cur))
-		it++
+	models = make([]T, limit)
+	copy(models, m.buffer[m.head:])
+
+	// Release the ejected models
+	var zero T
+	for i := m.head; i < m.head+limit; i++ {
+		m.buffer[i] = zero
+	}
+	m.head += limit
+
+	if m.head == len(m.buffer) {
+		m.buffer = m.buffer[:0]
+		m.head = 0
+	} else if m.head > len(m.buffer)/2 {
+		n := copy(m.buffer, m.buffer[m.head:])
+		for i := n; i < len(m.buffer); i++ {
+			m.buffer[i] = zero
+		}
+		m.buffer = m.buffer[:n]
+		m.head = 0
 	}
 	return models, nil
 }
 
-func (m *Queue) Push(model encoding.BinaryMarshaler) error {
+func (m *TypedQueue[T]) Push(model T) error {
 	m.mx.Lock()
 	defer m.mx.Unlock()
-	m.buffer.PushBack(model)
+	m.buffer = append(m.buffer, model)
 	return nil
 }
 
-func (m *Queue) Len() int {
+func (m *TypedQueue[T]) Len() int {
 	m.mx.Lock()
 	defer m.mx.Unlock()
-	return m.buffer.Len()
+	return len(m.buffer) - m.head
 }
@@ -162,3 +162,101 @@ func TestBaseQueue(t *testing.T) {
 		})
 	}
 }
+
+func TestTypedQueue(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "test")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tempFile.Close())
+		assert.NoError(t, os.Remove(tempFile.Name()))
+	}()
+	fileQueue, err := file.NewTypedQueue[testStruct](tempFile)
+	require.NoError(t, err)
+
+	testsType := []struct {
+		name string
+		Type ballistic.TypedQueue[*testStruct]
+	}{
+		{
+			name: "Memory",
+			Type: memory.NewTypedQueue[*testStruct](),
+		},
+		{
+			name: "File",
+			Type: fileQueue,
+		},
+		{
+			name: "Adapter",
+			Type: ballistic.TypedAdapter[*testStruct]{Queue: memory.NewQueue()},
+		},
+	}
+	for _, testType := range testsType {
+		t.Run(testType.name, func(t *testing.T) {
+			q := testType.Type
+
+			for i := 0; i < 5; i++ {
+				require.NoError(t, q.Push(&testStruct{S: fmt.Sprint(i)}))
+			}
+			assert.Equal(t, 5, q.Len())
+
+			models, err := q.Eject(3)
+			require.NoError(t, err)
+			require.Len(t, models, 3)
+			assert.Equal(t, "0", models[0].S)
+
+			require.NoError(t, q.Push(&testStruct{S: "5"}))
+			models, err = q.Eject(-1)
+			require.NoError(t, err)
+			require.Len(t, models, 3)
+			assert.Equal(t, "3", models[0].S)
+			assert.Equal(t, "5", models[2].S)
+			assert.Equal(t, 0, q.Len())
+		})
+	}
+}
+
+type foreignStruct struct{ testStruct }
+
+func TestQueueAdapters(t *testing.T) {
+	// An untyped queue holding another type reports it
+	untyped := memory.NewQueue()
+	require.NoError(t, untyped.Push(&testStruct{S: "1"}))
+	require.NoError(t, untyped.Push(&foreignStruct{}))
+	require.NoError(t, untyped.Push(&testStruct{S: "2"}))
+
+	models, err := ballistic.TypedAdapter[*testStruct]{Queue: untyped}.Eject(-1)
+	assert.ErrorIs(t, err, ballistic.ErrModelType)
+	require.Len(t, models, 2)
+	assert.Equal(t, "2", models[1].S)
+
+	// A typed queue rejects other types
+	var q ballistic.Queue = ballistic.UntypedAdapter[*testStruct]{Queue: memory.NewTypedQueue[*testStruct]()}
+	assert.ErrorIs(t, q.Push(&foreignStruct{}), ballistic.ErrModelType)
+	require.NoError(t, q.Push(&testStruct{S: "1"}))
+	ejected, err := q.Eject(1)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{&testStruct{S: "1"}}, ejected)
+}
+
+func TestPushAll(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "test")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tempFile.Close())
+		assert.NoError(t, os.Remove(tempFile.Name()))
+	}()
+	fileQueue, err := file.NewQueue(tempFile, &testStruct{})
+	require.NoError(t, err)
+
+	for name, queue := range map[string]ballistic.Queue{"Memory": memory.NewQueue(), "File": fileQueue} {
+		t.Run(name, func(t *testing.T) {
+			typed := ballistic.TypedAdapter[*testStruct]{Queue: queue}
+			models := []*testStruct{{S: "a"}, {S: "b"}, {S: "c"}}
+			assert.Nil(t, ballistic.PushAll[*testStruct](typed, models))
+
+			ejected, err := typed.Eject(-1)
+			require.NoError(t, err)
+			assert.Equal(t, models, ejected)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package ballistic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownModel    = errors.New("unknown model")
+	ErrModelRegistered = errors.New("model already registered")
+	ErrNoUpcaster      = errors.New("no upcaster")
+)
+
+// ModelFactory returns a new empty model.
+type ModelFactory func() DataModel
+
+// Upcaster converts an encoded model of one version into the next version.
+type Upcaster func(data []byte) ([]byte, error)
+
+type registryEntry struct {
+	version   int
+	factory   ModelFactory
+	key       modelKey
+	upcasters map[int]Upcaster
+}
+
+// modelKey identifies the models registered under a name,
+// wrappers of one type are told apart by their query.
+type modelKey struct {
+	typeOf reflect.Type
+	sql    string
+}
+
+func (k modelKey) String() string {
+	if k.sql == "" {
+		return k.typeOf.String()
+	}
+	return fmt.Sprintf("%s (%s)", k.typeOf, k.sql)
+}
+
+// Registry maps stable model names to factories,
+// so stored records can be decoded without a live instance.
+type Registry struct {
+	mx     sync.RWMutex
+	models map[string]registryEntry
+	types  map[modelKey]string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		models: map[string]registryEntry{},
+		types:  map[modelKey]string{},
+	}
+}
+
+// DefaultRegistry is the registry used by the command-line tools and the sender by default.
+var DefaultRegistry = NewRegistry()
+
+// Register adds the factory of the current version of the model under the name.
+// Both the name and the type of the model can be registered once,
+// a wrapped type once per query.
+func (r *Registry) Register(name string, version int, factory ModelFactory) error {
+	if name == "" {
+		return errors.New("model name is empty")
+	}
+	if version < 1 {
+		return fmt.Errorf("model %q: version %d is not positive", name, version)
+	}
+	if factory == nil {
+		return fmt.Errorf("model %q: factory is nil", name)
+	}
+
+	model := factory()
+	if model == nil {
+		return fmt.Errorf("model %q: factory returns nil", name)
+	}
+	key := typeKey(model)
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if _, ok := r.models[name]; ok {
+		return fmt.Errorf("%w: %q", ErrModelRegistered, name)
+	}
+	if prev, ok := r.types[key]; ok {
+		return fmt.Errorf("%w: %s is registered as %q", ErrModelRegistered, key, prev)
+	}
+
+	r.models[name] = registryEntry{
+		version:   version,
+		factory:   factory,
+		key:       key,
+		upcasters: map[int]Upcaster{},
+	}
+	r.types[key] = name
+	return nil
+}
+
+// New returns a new empty model registered under the name.
+func (r *Registry) New(name string) (DataModel, error) {
+	r.mx.RLock()
+	entry, ok := r.models[name]
+	r.mx.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownModel, name)
+	}
+
+	return entry.factory(), nil
+}
+
+// Version returns the current version of the model registered under the name.
+func (r *Registry) Version(name string) (int, error) {
+	r.mx.RLock()
+	entry, ok := r.models[name]
+	r.mx.RUnlock()
+
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownModel, name)
+	}
+
+	return entry.version, nil
+}
+
+// RegisterUpcaster adds the upcaster from version from to from+1 of the model.
+func (r *Registry) RegisterUpcaster(name string, from int, upcaster Upcaster) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	entry, ok := r.models[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownModel, name)
+	}
+	if from < 1 || from >= entry.version {
+		return fmt.Errorf("model %q: upcaster from version %d, current is %d", name, from, entry.version)
+	}
+	if _, ok := entry.upcasters[from]; ok {
+		return fmt.Errorf("model %q: upcaster from version %d already registered", name, from)
+	}
+
+	entry.upcasters[from] = upcaster
+	return nil
+}
+
+// Upcast converts an encoded model of the version into the current version.
+// Version 0 means unversioned data, it is returned as is.
+func (r *Registry) Upcast(name string, version int, data []byte) ([]byte, error) {
+	r.mx.RLock()
+	entry, ok := r.models[name]
+	r.mx.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownModel, name)
+	}
+
+	if version > entry.version {
+		return nil, fmt.Errorf("%w: model %q version %d is newer than %d", ErrNoUpcaster, name, version, entry.version)
+	}
+
+	for v := version; v > 0 && v < entry.version; v++ {
+		r.mx.RLock()
+		upcaster, ok := entry.upcasters[v]
+		r.mx.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("%w: model %q from version %d", ErrNoUpcaster, name, v)
+		}
+
+		var err error
+		data, err = upcaster(data)
+		if err != nil {
+			return nil, fmt.Errorf("model %q upcast from version %d: %w", name, v, err)
+		}
+	}
+
+	return data, nil
+}
+
+// Decode upcasts the encoded model of the version and unmarshals it into a new model.
+func (r *Registry) Decode(name string, version int, data []byte) (DataModel, error) {
+	data, err := r.Upcast(name, version, data)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := r.New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = model.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return model, nil
+}
+
+// Lookup returns the name and the version the type of the model is registered with.
+func (r *Registry) Lookup(model interface{}) (name string, version int, ok bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	name, ok = r.types[typeKey(model)]
+	if !ok {
+		return "", 0, false
+	}
+	return name, r.models[name].version, true
+}
+
+// Names returns the registered names in sorted order.
+func (r *Registry) Names() []string {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	names := make([]string, 0, len(r.models))
+	for name := range r.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func typeKey(model interface{}) modelKey {
+	if w, ok := model.(Wrapper); ok {
+		key := modelKey{typeOf: reflect.TypeOf(w.Unwrap())}
+		if m, ok := model.(DataModel); ok {
+			key.sql = m.SQL()
+		}
+		return key
+	}
+	return modelKey{typeOf: reflect.TypeOf(model)}
+}
@@ -0,0 +1,78 @@
+package ballistic_test
+
+import (
+	"github.com/farwydi/ballistic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type otherStruct struct {
+	testStruct
+}
+
+func TestRegistry(t *testing.T) {
+	r := ballistic.NewRegistry()
+	factory := func() ballistic.DataModel {
+		return &testStruct{}
+	}
+
+	require.NoError(t, r.Register("test", 2, factory))
+	assert.ErrorIs(t, r.Register("test", 3, func() ballistic.DataModel {
+		return &otherStruct{}
+	}), ballistic.ErrModelRegistered)
+	assert.ErrorIs(t, r.Register("alias", 1, factory), ballistic.ErrModelRegistered)
+	assert.Error(t, r.Register("", 1, factory))
+	assert.Error(t, r.Register("zero", 0, factory))
+
+	model, err := r.New("test")
+	require.NoError(t, err)
+	assert.IsType(t, &testStruct{}, model)
+
+	name, version, ok := r.Lookup(&testStruct{})
+	assert.True(t, ok)
+	assert.Equal(t, "test", name)
+	assert.Equal(t, 2, version)
+
+	_, _, ok = r.Lookup(&otherStruct{})
+	assert.False(t, ok)
+
+	_, err = r.New("missing")
+	assert.ErrorIs(t, err, ballistic.ErrUnknownModel)
+	_, err = r.Version("missing")
+	assert.ErrorIs(t, err, ballistic.ErrUnknownModel)
+
+	assert.Equal(t, []string{"test"}, r.Names())
+}
+
+func TestRegistryUpcast(t *testing.T) {
+	r := ballistic.NewRegistry()
+	require.NoError(t, r.Register("test", 3, func() ballistic.DataModel {
+		return &testStruct{}
+	}))
+	require.NoError(t, r.RegisterUpcaster("test", 1, func(data []byte) ([]byte, error) {
+		return append(data, '1'), nil
+	}))
+	assert.Error(t, r.RegisterUpcaster("test", 3, nil))
+
+	_, err := r.Upcast("test", 1, []byte("v"))
+	assert.ErrorIs(t, err, ballistic.ErrNoUpcaster)
+
+	require.NoError(t, r.RegisterUpcaster("test", 2, func(data []byte) ([]byte, error) {
+		return append(data, '2'), nil
+	}))
+	data, err := r.Upcast("test", 1, []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, "v12", string(data))
+
+	data, err = r.Upcast("test", 0, []byte("v"))
+	require.NoError(t, err)
+	assert.Equal(t, "v", string(data))
+
+	_, err = r.Upcast("test", 4, []byte("v"))
+	assert.ErrorIs(t, err, ballistic.ErrNoUpcaster)
+
+	model, err := r.Decode("test", 3, []byte(`{"S":"x"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "x", model.(*testStruct).S)
+}
@@ -0,0 +1,240 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"sync/atomic"
+)
+
+var (
+	// ErrFull is returned by an asynchronous push to a full ring with FullError.
+	ErrFull = fmt.Errorf("push ring is full")
+	// ErrDropped resolves the future of a record dropped from a full ring with FullDrop.
+	ErrDropped = fmt.Errorf("record dropped, push ring is full")
+	// ErrInMemory fails Future.WaitDurable for a record kept in memory, writing to disk failed
+	// or newer records of its ordered destination are held in memory. PushDurable fails with it
+	// for a duplicate whose earlier copy was kept in memory.
+	ErrInMemory = fmt.Errorf("record kept in memory")
+)
+
+// FullPolicy is what an asynchronous push does when the ring is full.
+type FullPolicy int
+
+const (
+	// FullBlock waits for room in the ring or for the context of the push.
+	FullBlock FullPolicy = iota
+	// FullDrop drops the record pushed and counts it in Status.Dropped.
+	FullDrop
+	// FullError fails the push with ErrFull.
+	FullError
+)
+
+// Async makes Push enqueue the records in a ring written to the pools by a background writer,
+// so the caller doesn't wait for the disk. The records enqueued are written before Stop sends the tail.
+type Async struct {
+	// Size is the records the ring holds, 0 disables the asynchronous mode.
+	Size int
+	// MaxGroup is the most records the writer pushes at once, Size by default.
+	MaxGroup int
+	// Full is what a push does when the ring is full, FullBlock by default.
+	Full FullPolicy
+}
+
+// Future is the outcome of a record pushed asynchronously.
+type Future struct {
+	done     chan struct{}
+	err      error
+	inMemory bool
+
+	sender *Sender
+	query  string
+}
+
+func newFuture(s *Sender, query string) *Future {
+	return &Future{done: make(chan struct{}), sender: s, query: query}
+}
+
+func (f *Future) resolve(err error, inMemory bool) {
+	if f == nil {
+		return
+	}
+	f.err = err
+	f.inMemory = inMemory
+	close(f.done)
+}
+
+// Done is closed once the record is written to the pools or given up.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for the record to be written, it returns the error Push returns for the record
+// in the synchronous mode or ErrDropped. A record that fell back to memory is not an error,
+// see InMemory and WaitDurable.
+func (f *Future) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// InMemory reports whether the record was kept in memory instead of the file pool,
+// it is valid once Done is closed.
+func (f *Future) InMemory() bool {
+	<-f.done
+	return f.inMemory
+}
+
+// WaitDurable waits for the record to be written and synced to stable storage like PushDurable,
+// the sync is shared with the durable pushes. It returns a *DurabilityError if the record
+// is not durable, with ErrInMemory if it was kept in memory, or the error of the context.
+func (f *Future) WaitDurable(ctx context.Context) error {
+	if err := f.Wait(ctx); err != nil {
+		if err == ctx.Err() {
+			return err
+		}
+		return &DurabilityError{Err: err}
+	}
+	if f.inMemory {
+		return &DurabilityError{Err: ErrInMemory}
+	}
+
+	if err := f.sender.commits.sync(ctx, f.query); err != nil {
+		return &DurabilityError{Err: err, Written: true}
+	}
+	return nil
+}
+
+// asyncRecord is a record in the ring, future is nil unless the caller waits for it.
+type asyncRecord struct {
+	model  ballistic.DataModel
+	future *Future
+}
+
+// PushContext pushes the model like Push, the context bounds the wait for room in the ring with FullBlock.
+func (s *Sender) PushContext(ctx context.Context, model ballistic.DataModel) error {
+	if s.ingress == nil {
+		return s.Push(model)
+	}
+	return s.enqueue(ctx, asyncRecord{model: model})
+}
+
+// PushAsync pushes the model like PushContext and returns the future of its write.
+// The model is written at once if the asynchronous mode is disabled.
+func (s *Sender) PushAsync(ctx context.Context, model ballistic.DataModel) (*Future, error) {
+	future := newFuture(s, model.SQL())
+	if s.ingress == nil {
+		if atomic.LoadInt32(&s.isShutdown) == 1 {
+			future.resolve(ErrShutdown, false)
+			return future, nil
+		}
+		s.write([]asyncRecord{{model: model, future: future}})
+		return future, nil
+	}
+
+	if err := s.enqueue(ctx, asyncRecord{model: model, future: future}); err != nil {
+		return nil, err
+	}
+	return future, nil
+}
+
+func (s *Sender) enqueue(ctx context.Context, record asyncRecord) error {
+	s.ingressMx.RLock()
+	defer s.ingressMx.RUnlock()
+
+	if s.ingressClosed {
+		return ErrShutdown
+	}
+
+	select {
+	case s.ingress <- record:
+		return nil
+	default:
+	}
+
+	switch s.cfg.Async.Full {
+	case FullDrop:
+		atomic.AddInt64(&s.dropped, 1)
+		record.future.resolve(ErrDropped, false)
+		return nil
+	case FullError:
+		return ErrFull
+	}
+
+	select {
+	case s.ingress <- record:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runWriter pushes the records of the ring in groups of up to MaxGroup until it is closed.
+func (s *Sender) runWriter() {
+	defer close(s.writerDone)
+
+	group := make([]asyncRecord, 0, s.cfg.Async.MaxGroup)
+	for record := range s.ingress {
+		group = append(group[:0], record)
+	fill:
+		for len(group) < s.cfg.Async.MaxGroup {
+			select {
+			case record, ok := <-s.ingress:
+				if !ok {
+					break fill
+				}
+				group = append(group, record)
+			default:
+				break fill
+			}
+		}
+		s.write(group)
+	}
+}
+
+func (s *Sender) write(group []asyncRecord) {
+	models := make([]ballistic.DataModel, len(group))
+	for i, record := range group {
+		models[i] = record.model
+	}
+
+	errs, inMemory := s.pushBatch(models)
+
+	var failed int
+	var first error
+	for i, record := range group {
+		var err error
+		if errs != nil {
+			err = errs[i]
+		}
+		if err != nil && record.future == nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+		record.future.resolve(err, inMemory != nil && inMemory[i])
+	}
+	if failed > 0 {
+		s.logger.Errorw("data lost! asynchronous push failed", "error", first, "lost", failed)
+	}
+}
+
+// closeIngress stops the asynchronous pushes and waits for the records of the ring to be written.
+func (s *Sender) closeIngress() {
+	if s.ingress == nil {
+		return
+	}
+
+	s.ingressMx.Lock()
+	if !s.ingressClosed {
+		s.ingressClosed = true
+		close(s.ingress)
+	}
+	s.ingressMx.Unlock()
+
+	<-s.writerDone
+}
@@ -0,0 +1,138 @@
+package sender
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncPush(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	_, connect := newFakeDB()
+	s := NewSender(connect, Config{FileWorkspace: tempDir, Async: Async{Size: 100}})
+
+	var futures []*Future
+	for i := 0; i < 10; i++ {
+		future, err := s.PushAsync(context.Background(), &testModel{Q: insertT, N: i})
+		require.NoError(t, err)
+		futures = append(futures, future)
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+	for _, future := range futures {
+		assert.NoError(t, future.Wait(context.Background()))
+	}
+	assert.Eventually(t, func() bool {
+		return s.filePool.Backlog(insertT).Len == 20
+	}, time.Second, time.Millisecond)
+	assert.Zero(t, s.Status().Ingress)
+}
+
+func TestFutureDurable(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	_, connect := newFakeDB()
+	cfg := orderedConfig(t, tempDir)
+	cfg.Async = Async{Size: 10}
+	s := NewSender(connect, cfg)
+
+	future, err := s.PushAsync(context.Background(), &testModel{Q: insertT, N: 0})
+	require.NoError(t, err)
+	assert.NoError(t, future.WaitDurable(context.Background()))
+	assert.False(t, future.InMemory())
+
+	// Newer records of the ordered destination follow the one in memory
+	require.NoError(t, s.memoryPool.Push(&testModel{Q: insertT, N: 1}))
+	future, err = s.PushAsync(context.Background(), &testModel{Q: insertT, N: 2})
+	require.NoError(t, err)
+	assert.NoError(t, future.Wait(context.Background()))
+	assert.True(t, future.InMemory())
+
+	err = future.WaitDurable(context.Background())
+	var durabilityErr *DurabilityError
+	require.ErrorAs(t, err, &durabilityErr)
+	assert.False(t, durabilityErr.Written)
+	assert.ErrorIs(t, err, ErrInMemory)
+}
+
+func TestAsyncFull(t *testing.T) {
+	for _, policy := range []FullPolicy{FullBlock, FullDrop, FullError} {
+		t.Run(map[FullPolicy]string{FullBlock: "block", FullDrop: "drop", FullError: "error"}[policy], func(t *testing.T) {
+			tempDir, err := ioutil.TempDir("", "ballistic")
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, os.RemoveAll(tempDir))
+			}()
+
+			_, connect := newFakeDB()
+			s := NewSender(connect, Config{FileWorkspace: tempDir, Async: Async{Size: 2, Full: policy}})
+
+			// The writer holds the first record until the pool is free
+			s.filePool.ofsMx.Lock()
+			first, err := s.PushAsync(context.Background(), &testModel{Q: insertT, N: 0})
+			require.NoError(t, err)
+			require.Eventually(t, func() bool {
+				return len(s.ingress) == 0
+			}, time.Second, time.Millisecond)
+			for i := 1; i < 3; i++ {
+				require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+			}
+			assert.Len(t, s.ingress, 2)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			future, err := s.PushAsync(ctx, &testModel{Q: insertT, N: 3})
+			switch policy {
+			case FullBlock:
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+			case FullDrop:
+				require.NoError(t, err)
+				assert.ErrorIs(t, future.Wait(context.Background()), ErrDropped)
+				assert.Equal(t, int64(1), atomic.LoadInt64(&s.dropped))
+			case FullError:
+				assert.ErrorIs(t, err, ErrFull)
+			}
+
+			s.filePool.ofsMx.Unlock()
+			assert.NoError(t, first.Wait(context.Background()))
+			assert.Eventually(t, func() bool {
+				return s.filePool.Backlog(insertT).Len == 3
+			}, time.Second, time.Millisecond)
+		})
+	}
+}
+
+func TestAsyncStop(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	s := NewSender(connect, Config{FileWorkspace: tempDir, SendLimit: 1000, Async: Async{Size: 100}})
+	go s.RunPusher(context.Background())
+	for i := 0; i < 50; i++ {
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+
+	// The ring is written before the tail is sent
+	s.Stop(true)
+	assert.Len(t, db.rows(insertT), 50)
+	assert.ErrorIs(t, s.Push(&testModel{Q: insertT}), ErrShutdown)
+	_, err = s.PushAsync(context.Background(), &testModel{Q: insertT})
+	assert.ErrorIs(t, err, ErrShutdown)
+}
@@ -0,0 +1,324 @@
+package sender
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// batchDir keeps the sealed batches in the workspace until they are committed.
+	batchDir = "batches"
+	// deadLetterDir keeps the sealed batches given up after MaxBatchAttempts.
+	deadLetterDir = "deadletter"
+)
+
+// sealedBatch is a batch whose id and records are fixed when it is formed,
+// it is retried as is until committed.
+type sealedBatch struct {
+	id         string
+	query      string
+	dataModels []ballistic.DataModel
+	attempts   int
+	// persisted is unset for a batch kept in memory because it couldn't be written.
+	persisted bool
+}
+
+// batchStore persists the sealed batches as queue files named by their id.
+type batchStore struct {
+	dir     string
+	deadDir string
+	cfg     file.Config
+
+	mx      sync.Mutex
+	pending []*sealedBatch
+	// unread counts the batches left in the store that couldn't be read, they are not retried.
+	unread int
+}
+
+func newBatchStore(workspace string, cfg file.Config) (*batchStore, error) {
+	b := &batchStore{
+		dir:     filepath.Join(workspace, batchDir),
+		deadDir: filepath.Join(workspace, deadLetterDir),
+		cfg:     cfg,
+	}
+	for _, dir := range []string{b.dir, b.deadDir} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// workspaceUsage returns the size of the queue files of the workspace, see file.DiskUsage,
+// and of its sealed batches and dead letters.
+func workspaceUsage(workspace string) (int64, error) {
+	size, err := file.DiskUsage(workspace)
+	if err != nil {
+		return size, err
+	}
+
+	for _, dir := range []string{batchDir, deadLetterDir} {
+		infos, err := ioutil.ReadDir(filepath.Join(workspace, dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return size, err
+		}
+		for _, info := range infos {
+			if info.Mode().IsRegular() {
+				size += info.Size()
+			}
+		}
+	}
+	return size, nil
+}
+
+// batchID returns a new batch id, ids sort by the time they are made.
+func batchID() string {
+	var r [4]byte
+	_, _ = rand.Read(r[:])
+	return fmt.Sprintf("%016x%08x", time.Now().UnixNano(), binary.BigEndian.Uint32(r[:]))
+}
+
+func (b *batchStore) path(id string) string {
+	return filepath.Join(b.dir, id+"_0.bd")
+}
+
+// seal persists the models as a new batch.
+// If the batch can't be written it is returned unpersisted with the error if inMemory is set.
+func (b *batchStore) seal(query string, dataModels []ballistic.DataModel, inMemory bool) (*sealedBatch, error) {
+	batch := &sealedBatch{id: batchID(), query: query, dataModels: dataModels}
+
+	err := b.write(batch)
+	if err != nil {
+		if inMemory {
+			return batch, err
+		}
+		return nil, err
+	}
+	batch.persisted = true
+	return batch, nil
+}
+
+// write writes the batch to a temporary file renamed into the store once synced,
+// the store is synced after the rename.
+func (b *batchStore) write(batch *sealedBatch) error {
+	tmp := filepath.Join(b.dir, batch.id+".tmp")
+	defer os.Remove(tmp)
+
+	err := func() error {
+		f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		q, err := file.NewQueue(f, batch.dataModels[0], b.cfg)
+		if err != nil {
+			return err
+		}
+		for _, dataModel := range batch.dataModels {
+			if err := q.Push(dataModel); err != nil {
+				return err
+			}
+		}
+		return f.Sync()
+	}()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.path(batch.id)); err != nil {
+		return err
+	}
+	return file.SyncDir(b.dir)
+}
+
+// load holds the batches left in the store by a previous run and removes the temporary
+// files of the writes it interrupted. Batches that can't be read, like the ones of models
+// missing from the Registry, stay in the store and are counted unread, the first error is returned.
+func (b *batchStore) load() error {
+	infos, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), ".tmp") {
+			_ = os.Remove(filepath.Join(b.dir, info.Name()))
+			continue
+		}
+
+		id := strings.TrimSuffix(info.Name(), "_0.bd")
+		if !info.Mode().IsRegular() || id == info.Name() {
+			continue
+		}
+
+		batch, err := b.read(id)
+		if err != nil {
+			b.mx.Lock()
+			b.unread++
+			b.mx.Unlock()
+			if first == nil {
+				first = fmt.Errorf("batch %s: %w", id, err)
+			}
+			continue
+		}
+		batch.persisted = true
+		if len(batch.dataModels) == 0 {
+			_ = os.Remove(b.path(id))
+			continue
+		}
+		b.hold(batch)
+	}
+	return first
+}
+
+func (b *batchStore) read(id string) (*sealedBatch, error) {
+	name, err := file.MatchModel(b.path(id), b.cfg.Registry)
+	if err != nil {
+		return nil, err
+	}
+	model, err := b.cfg.Registry.New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ejecting consumes the records, the batch is read from a copy
+	data, err := ioutil.ReadFile(b.path(id))
+	if err != nil {
+		return nil, err
+	}
+	tmp := filepath.Join(b.dir, id+".tmp")
+	defer os.Remove(tmp)
+	if err := ioutil.WriteFile(tmp, data, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(tmp, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	q, err := file.NewQueue(f, model, b.cfg)
+	if err != nil {
+		return nil, err
+	}
+	models, err := q.Eject(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &sealedBatch{id: id, query: model.SQL()}
+	for _, m := range models {
+		if dataModel, ok := m.(ballistic.DataModel); ok {
+			batch.dataModels = append(batch.dataModels, dataModel)
+		}
+	}
+	return batch, nil
+}
+
+// hold keeps the batch pending for a retry, pending batches are retried oldest first.
+func (b *batchStore) hold(batch *sealedBatch) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	i := sort.Search(len(b.pending), func(i int) bool {
+		return b.pending[i].id > batch.id
+	})
+	b.pending = append(b.pending, nil)
+	copy(b.pending[i+1:], b.pending[i:])
+	b.pending[i] = batch
+}
+
+// take removes the oldest pending batch, nil if there is none.
+func (b *batchStore) take() *sealedBatch {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if len(b.pending) == 0 {
+		return nil
+	}
+	batch := b.pending[0]
+	b.pending = b.pending[1:]
+	return batch
+}
+
+// takeAll removes all the pending batches, oldest first.
+func (b *batchStore) takeAll() []*sealedBatch {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	batches := b.pending
+	b.pending = nil
+	return batches
+}
+
+// persist writes the pending batches kept in memory, it returns the records of the ones
+// still not written.
+func (b *batchStore) persist() (lost int, err error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for _, batch := range b.pending {
+		if batch.persisted {
+			continue
+		}
+		if werr := b.write(batch); werr != nil {
+			lost += len(batch.dataModels)
+			err = werr
+			continue
+		}
+		batch.persisted = true
+	}
+	return lost, err
+}
+
+// len returns the number of pending batches.
+func (b *batchStore) len() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return len(b.pending)
+}
+
+// unreadLen returns the number of batches of the store that couldn't be read.
+func (b *batchStore) unreadLen() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.unread
+}
+
+// commit forgets the committed batch.
+func (b *batchStore) commit(batch *sealedBatch) error {
+	if !batch.persisted {
+		return nil
+	}
+	return os.Remove(b.path(batch.id))
+}
+
+// deadLetter moves the batch out of the store, it is not retried anymore.
+func (b *batchStore) deadLetter(batch *sealedBatch) error {
+	if !batch.persisted {
+		batch.persisted = b.write(batch) == nil
+		if !batch.persisted {
+			return fmt.Errorf("%d records lost", len(batch.dataModels))
+		}
+	}
+	err := os.Rename(b.path(batch.id), filepath.Join(b.deadDir, batch.id+"_0.bd"))
+	if err != nil {
+		return err
+	}
+	if err := file.SyncDir(b.deadDir); err != nil {
+		return err
+	}
+	return file.SyncDir(b.dir)
+}
@@ -0,0 +1,210 @@
+package sender
+
+import (
+	"context"
+	"github.com/farwydi/ballistic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const insertT = "INSERT INTO t (n) VALUES (?)"
+
+func sealedConfig(t *testing.T, workspace string) Config {
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("t", 1, func() ballistic.DataModel {
+		return &testModel{Q: insertT}
+	}))
+	return Config{
+		FileWorkspace:      workspace,
+		Registry:           registry,
+		SendInterval:       time.Hour,
+		SendLimit:          5,
+		SealBatches:        true,
+		DeduplicationToken: true,
+	}
+}
+
+func batchFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names
+}
+
+func values(b fakeBatch) []int64 {
+	v := make([]int64, len(b.rows))
+	for i, row := range b.rows {
+		v[i] = row[0].(int64)
+	}
+	return v
+}
+
+func TestSealedBatchRetry(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	s := NewSender(connect, sealedConfig(t, tempDir))
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+	assert.False(t, s.send(context.Background(), time.Now(), 0))
+
+	// The failed batch is sealed on disk, not back in the queue
+	assert.Zero(t, s.backlog(insertT).Len)
+	assert.Equal(t, 1, s.Status().SealedBatches)
+	files := batchFiles(t, filepath.Join(tempDir, batchDir))
+	require.Len(t, files, 1)
+
+	// New records don't join it and wait for it
+	for i := 5; i < 7; i++ {
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+	s.nextShared = time.Time{}
+	assert.False(t, s.send(context.Background(), time.Now(), 0))
+	assert.Equal(t, 2, s.backlog(insertT).Len)
+
+	db.setFail(nil)
+	s.nextShared = time.Time{}
+	s.send(context.Background(), time.Now(), 0)
+
+	// The batch is retried as sealed, with its id as the token
+	batches := db.committed()
+	require.Len(t, batches, 2)
+	id := files[0][:len(files[0])-len("_0.bd")]
+	assert.Equal(t, withSetting(insertT, "insert_deduplication_token", id), batches[0].query)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, values(batches[0]))
+	assert.Equal(t, []int64{5, 6}, values(batches[1]))
+	assert.Empty(t, batchFiles(t, filepath.Join(tempDir, batchDir)))
+	assert.Zero(t, s.Status().SealedBatches)
+}
+
+func TestSealedBatchRestart(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	s := NewSender(connect, sealedConfig(t, tempDir))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+	s.send(context.Background(), time.Now(), 0)
+
+	// Reading the batch back doesn't consume it
+	for i := 0; i < 2; i++ {
+		s = NewSender(connect, sealedConfig(t, tempDir))
+		assert.Equal(t, 1, s.Status().SealedBatches)
+	}
+
+	db.setFail(nil)
+	s.send(context.Background(), time.Now(), 0)
+	batches := db.committed()
+	require.Len(t, batches, 1)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, values(batches[0]))
+	assert.Empty(t, batchFiles(t, filepath.Join(tempDir, batchDir)))
+}
+
+func TestSealedBatchUnread(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	s := NewSender(connect, sealedConfig(t, tempDir))
+	require.NoError(t, s.Push(&testModel{Q: insertT}))
+	s.send(context.Background(), time.Now(), 0)
+
+	// A write interrupted by a crash
+	tmp := filepath.Join(tempDir, batchDir, batchID()+".tmp")
+	require.NoError(t, ioutil.WriteFile(tmp, []byte("partial"), 0644))
+
+	// The model of the batch is missing from the registry
+	cfg := sealedConfig(t, tempDir)
+	cfg.Registry = ballistic.NewRegistry()
+	s = NewSender(connect, cfg)
+	st := s.Status()
+	assert.Zero(t, st.SealedBatches)
+	assert.Equal(t, 1, st.UnreadBatches)
+	assert.NoFileExists(t, tmp)
+	assert.Len(t, batchFiles(t, filepath.Join(tempDir, batchDir)), 1)
+}
+
+func TestSealedBatchReplay(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	cfg := sealedConfig(t, tempDir)
+	s := NewSender(connect, cfg)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+	before := s.Status().WorkspaceBytes
+	s.send(context.Background(), time.Now(), 0)
+	require.Equal(t, 1, s.Status().SealedBatches)
+	assert.Zero(t, s.filePool.Backlog(insertT).Len)
+
+	// The batch is counted in the workspace and replayed with it
+	names := batchFiles(t, filepath.Join(tempDir, batchDir))
+	require.Len(t, names, 1)
+	info, err := os.Stat(filepath.Join(tempDir, batchDir, names[0]))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, s.Status().WorkspaceBytes, before+info.Size())
+
+	stats, err := Replay(context.Background(), nil, []string{tempDir}, ReplayConfig{Registry: cfg.Registry, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 5, stats.Records)
+}
+
+func TestDeadLetter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	cfg := sealedConfig(t, tempDir)
+	cfg.MaxBatchAttempts = 2
+	s := NewSender(connect, cfg)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+
+	s.send(context.Background(), time.Now(), 0)
+	assert.Equal(t, 1, s.Status().SealedBatches)
+	s.send(context.Background(), time.Now(), 0)
+	assert.Zero(t, s.Status().SealedBatches)
+
+	assert.Empty(t, batchFiles(t, filepath.Join(tempDir, batchDir)))
+	assert.Len(t, batchFiles(t, filepath.Join(tempDir, deadLetterDir)), 1)
+
+	db.setFail(nil)
+	s.send(context.Background(), time.Now(), 0)
+	assert.Empty(t, db.committed())
+}
@@ -0,0 +1,155 @@
+package sender
+
+import (
+	"context"
+	"github.com/farwydi/ballistic"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CatchUp drains a large backlog by sending batches back to back instead of once per tick.
+type CatchUp struct {
+	// Threshold is the backlog in records of both pools that starts the catch-up, 0 disables it.
+	// The catch-up goes on while the backlog exceeds it and the publishes succeed.
+	Threshold int
+	// Rate is the most batches sent per second, 0 is unlimited.
+	Rate float64
+	// MaxInFlight is the most batches published at once, 1 by default.
+	MaxInFlight int
+}
+
+// batch is the models of one query published in one transaction.
+type batch struct {
+	query      string
+	dataModels []ballistic.DataModel
+}
+
+// unpaced is ready at once, the pace of the catch-up without a rate.
+var unpaced = func() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}()
+
+// backlogLen returns the number of records in both pools.
+func (s *Sender) backlogLen() int {
+	n := 0
+	for _, b := range s.backlogs() {
+		n += b.Len
+	}
+	return n
+}
+
+// ejectBatches ejects a round of batches, SendLimit records of the queries without a destination
+// and BatchSize records of every query with one. Ordered destinations are left to the ticks,
+// batches in flight at once may commit out of order.
+func (s *Sender) ejectBatches() []batch {
+	var batches []batch
+	for query, dataModels := range s.ejectShared(nil) {
+		batches = append(batches, batch{query: query, dataModels: dataModels})
+	}
+	for query, r := range s.scheduled() {
+		if r.Ordered {
+			continue
+		}
+		for _, dataModels := range s.ejectRoute(query, r) {
+			batches = append(batches, batch{query: query, dataModels: dataModels})
+		}
+	}
+	return batches
+}
+
+// catchUp sends batches back to back while the backlog exceeds CatchUp.Threshold
+// and the publishes succeed. It reports whether the sender was stopped meanwhile.
+func (s *Sender) catchUp(ctx context.Context) (stopped bool) {
+	c := s.cfg.CatchUp
+	if c.Threshold <= 0 || (s.batches != nil && s.batches.len() > 0) {
+		return false
+	}
+	backlog := s.backlogLen()
+	if backlog <= c.Threshold {
+		return false
+	}
+
+	atomic.StoreInt32(&s.isCatchingUp, 1)
+	defer atomic.StoreInt32(&s.isCatchingUp, 0)
+	s.logger.Infow("catching up the backlog", "backlog", backlog)
+
+	pace := unpaced
+	if c.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / c.Rate))
+		defer t.Stop()
+		pace = t.C
+	}
+
+	var (
+		wg       sync.WaitGroup
+		failures int32
+		inFlight = make(chan struct{}, c.MaxInFlight)
+		halt     func()
+		sent     int
+	)
+
+drain:
+	for atomic.LoadInt32(&failures) == 0 && s.backlogLen() > c.Threshold {
+		batches := s.ejectBatches()
+		if len(batches) == 0 {
+			break
+		}
+
+		for i, b := range batches {
+			// A failure ends the catch-up, the batches left go back to the pools
+			if atomic.LoadInt32(&failures) != 0 || !s.wait(ctx, pace, &halt) || !s.acquire(ctx, inFlight, &halt) {
+				for _, rest := range batches[i:] {
+					s.fallback(rest.dataModels, s.cfg.UseMemoryFallback)
+				}
+				break drain
+			}
+
+			wg.Add(1)
+			sent++
+			go func(b batch) {
+				defer wg.Done()
+				defer func() { <-inFlight }()
+				if s.sendBatch(ctx, b.query, b.dataModels, s.cfg.UseMemoryFallback) != nil {
+					atomic.StoreInt32(&failures, 1)
+				}
+			}(b)
+		}
+	}
+	wg.Wait()
+
+	s.logger.Infow("caught up the backlog", "batches", sent, "backlog", s.backlogLen())
+	if halt != nil {
+		halt()
+		return true
+	}
+	return false
+}
+
+// wait blocks until the pace fires, false if the sender is stopped first.
+func (s *Sender) wait(ctx context.Context, pace <-chan time.Time, halt *func()) bool {
+	select {
+	case <-pace:
+		return true
+	case sendTail := <-s.stopSig:
+		*halt = func() { s.stop(ctx, sendTail) }
+	case <-ctx.Done():
+		*halt = func() { s.stop(context.Background(), false) }
+	}
+	return false
+}
+
+// acquire takes a slot of the batches in flight, false if the sender is stopped first.
+func (s *Sender) acquire(ctx context.Context, inFlight chan struct{}, halt *func()) bool {
+	select {
+	case inFlight <- struct{}{}:
+		return true
+	case sendTail := <-s.stopSig:
+		*halt = func() { s.stop(ctx, sendTail) }
+	case <-ctx.Done():
+		*halt = func() { s.stop(context.Background(), false) }
+	}
+	return false
+}
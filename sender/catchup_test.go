@@ -0,0 +1,125 @@
+package sender
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCatchUp(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setDelay(20 * time.Millisecond)
+	s := NewSender(connect, Config{
+		FileWorkspace: tempDir,
+		SendInterval:  time.Hour,
+		SendLimit:     10,
+		CatchUp:       CatchUp{Threshold: 20, MaxInFlight: 3},
+	})
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s.Push(&testModel{Q: "a", N: i}))
+	}
+
+	// The backlog is drained down to the threshold, three batches at once
+	assert.False(t, s.catchUp(context.Background()))
+	assert.Len(t, db.rows("a"), 80)
+	assert.Equal(t, 20, s.backlog("a").Len)
+	assert.Equal(t, 3, db.maxActive())
+	assert.False(t, s.Status().CatchingUp)
+
+	// Under the threshold there is nothing to catch up
+	assert.False(t, s.catchUp(context.Background()))
+	assert.Len(t, db.rows("a"), 80)
+}
+
+func TestCatchUpFailure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	s := NewSender(connect, Config{
+		FileWorkspace: tempDir,
+		SendInterval:  time.Hour,
+		SendLimit:     10,
+		CatchUp:       CatchUp{Threshold: 20, MaxInFlight: 3},
+	})
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s.Push(&testModel{Q: "a", N: i}))
+	}
+
+	// A failed publish ends the catch-up with every record back in the backlog
+	assert.False(t, s.catchUp(context.Background()))
+	assert.Empty(t, db.rows("a"))
+	assert.Equal(t, 100, s.backlog("a").Len)
+}
+
+func TestCatchUpRate(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	s := NewSender(connect, Config{
+		FileWorkspace: tempDir,
+		SendInterval:  time.Hour,
+		SendLimit:     10,
+		CatchUp:       CatchUp{Threshold: 1, Rate: 50, MaxInFlight: 10},
+	})
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, s.Push(&testModel{Q: "a", N: i}))
+	}
+
+	// Five batches at 50 per second take 100ms
+	start := time.Now()
+	assert.False(t, s.catchUp(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	assert.Len(t, db.rows("a"), 50)
+}
+
+func TestCatchUpStop(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	s := NewSender(connect, Config{
+		FileWorkspace: tempDir,
+		SendInterval:  time.Hour,
+		SendLimit:     10,
+		CatchUp:       CatchUp{Threshold: 1, Rate: 10},
+	})
+
+	go s.RunPusher(context.Background())
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s.Push(&testModel{Q: "a", N: i}))
+	}
+	require.Eventually(t, func() bool {
+		return s.Status().CatchingUp
+	}, time.Second, 5*time.Millisecond)
+
+	// Stopping does not wait for the catch-up, the tail is sent
+	start := time.Now()
+	s.Stop(true)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Len(t, db.rows("a"), 100)
+}
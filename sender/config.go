@@ -1,6 +1,8 @@
 package sender
 
 import (
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
 	"io/ioutil"
 	"time"
 )
@@ -14,8 +16,56 @@ type Config struct {
 	FileWorkspace      string
 	FleMaxCaraptedFile int
 	ShowSuccessfulInfo bool
+	// Registry constructs the models of the queues found in FileWorkspace on startup.
+	Registry *ballistic.Registry
+	// FileCodec encodes the records of new queue files, file.Raw by default.
+	// Rows without a binary encoding of their own are pushed with PushValue.
+	FileCodec file.Codec
+	// FileCompressor compresses the records of new queue files in blocks of FileBlockSize bytes.
+	FileCompressor file.Compressor
+	FileBlockSize  int
+	// FlushBytes sends the records of a query as soon as their size reaches it, 0 disables it.
+	// The records of a query are always sent as soon as they reach SendLimit.
+	FlushBytes int
+	// MaxLatency sends the records of a query before SendInterval once the oldest waits that long,
+	// 0 disables it.
+	MaxLatency time.Duration
+	// Destinations override the batch limits and the interval of a query, keyed by its SQL
+	// or its registered model name. Their records are sent apart from SendLimit.
+	Destinations map[string]Destination
+	// Strategy shares SendLimit between the queues of different SQL, RoundRobin by default.
+	Strategy Strategy
+	// MemoryQuota is the most records kept in memory when writing to disk fails,
+	// the records of the lowest priority are dropped first. 0 is unlimited.
+	MemoryQuota int
+	// DedupWindow drops the records pushed with the idempotency key of a record pushed
+	// within the window, see ballistic.Keyed. 0 disables it. The keys of the records pending
+	// in the spools are remembered on restore, the ones of records sent before a restart are not.
+	DedupWindow time.Duration
+	// DeduplicationToken sends the batches of keyed records with an insert_deduplication_token
+	// derived from their keys, so ClickHouse drops a batch retried after an unknown outcome.
+	// It enables SealBatches, a failed batch is retried as is with its id as the token.
+	DeduplicationToken bool
+	// SealBatches fixes the records of a batch when it is formed and keeps it in the workspace,
+	// a failed batch is retried as is before new ones, with its id as the deduplication token.
+	// The models must be in the Registry for the batches to be read back after a restart.
+	SealBatches bool
+	// MaxBatchAttempts moves a sealed batch to the dead letters of the workspace after that many
+	// failed attempts of this run, 0 retries it forever.
+	MaxBatchAttempts int
+	// Limit is the rate of all the records sent.
+	Limit Limit
+	// CatchUp drains a backlog over its threshold with batches sent back to back.
+	CatchUp CatchUp
+	// Async makes Push return before the record is written, see Async.
+	Async Async
+	// FileKeys encrypts the records of the queue files, files of older keys are rotated on open.
+	FileKeys file.KeyProvider
 }
 
+// minInterval is the shortest send interval.
+const minInterval = 100 * time.Millisecond
+
 // ConfigDefault is the default config
 var ConfigDefault = Config{
 	UseMemoryFallback:  true,
@@ -24,6 +74,8 @@ var ConfigDefault = Config{
 	ShowSuccessfulInfo: false,
 	SendInterval:       10 * time.Second,
 	SendLimit:          1000,
+	Registry:           ballistic.DefaultRegistry,
+	Strategy:           RoundRobin,
 }
 
 // Helper function to set default values
@@ -40,12 +92,45 @@ func configDefault(config ...Config) Config {
 		cfg.FileWorkspace, _ = ioutil.TempDir("", "ballistic")
 	}
 
+	if cfg.Registry == nil {
+		cfg.Registry = ConfigDefault.Registry
+	}
+
+	if cfg.Strategy == nil {
+		cfg.Strategy = ConfigDefault.Strategy
+	}
+
 	if cfg.SendLimit == 0 {
 		cfg.SendLimit = 1
 	}
 
-	if cfg.SendInterval < 100*time.Millisecond {
-		cfg.SendInterval = 100 * time.Millisecond
+	if cfg.SendInterval < minInterval {
+		cfg.SendInterval = minInterval
+	}
+
+	if cfg.MaxLatency > 0 && cfg.MaxLatency < minInterval {
+		cfg.MaxLatency = minInterval
+	}
+
+	// A batch formed anew on a retry would carry another token
+	if cfg.DeduplicationToken {
+		cfg.SealBatches = true
+	}
+
+	if cfg.CatchUp.MaxInFlight <= 0 {
+		cfg.CatchUp.MaxInFlight = 1
+	}
+
+	if cfg.Async.Size > 0 && cfg.Async.MaxGroup <= 0 {
+		cfg.Async.MaxGroup = cfg.Async.Size
+	}
+
+	if len(cfg.Destinations) > 0 {
+		destinations := make(map[string]Destination, len(cfg.Destinations))
+		for key, d := range cfg.Destinations {
+			destinations[key] = destinationDefault(d, cfg)
+		}
+		cfg.Destinations = destinations
 	}
 
 	return cfg
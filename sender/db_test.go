@@ -0,0 +1,176 @@
+package sender
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+)
+
+// fakeDB records the committed transactions of the sender.
+type fakeDB struct {
+	mx      sync.Mutex
+	batches []fakeBatch
+	// queries are the queries of every commit, failed ones included.
+	queries []string
+	// fail is returned by the commits while set.
+	fail error
+	// delay slows the commits down, peak counts the most commits at once.
+	delay        time.Duration
+	active, peak int
+}
+
+type fakeBatch struct {
+	query string
+	rows  [][]driver.Value
+}
+
+func newFakeDB() (*fakeDB, *sql.DB) {
+	db := &fakeDB{}
+	return db, sql.OpenDB(fakeConnector{db: db})
+}
+
+func (db *fakeDB) setFail(err error) {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+	db.fail = err
+}
+
+func (db *fakeDB) setDelay(delay time.Duration) {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+	db.delay = delay
+}
+
+func (db *fakeDB) maxActive() int {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+	return db.peak
+}
+
+func (db *fakeDB) committed() []fakeBatch {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+	return append([]fakeBatch(nil), db.batches...)
+}
+
+func (db *fakeDB) attempts() []string {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+	return append([]string(nil), db.queries...)
+}
+
+// rows returns the first value of the committed rows of the query.
+func (db *fakeDB) rows(query string) []int64 {
+	var rows []int64
+	for _, b := range db.committed() {
+		if b.query != query {
+			continue
+		}
+		for _, row := range b.rows {
+			rows = append(rows, row[0].(int64))
+		}
+	}
+	return rows
+}
+
+type fakeConnector struct {
+	db *fakeDB
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("use the connector")
+}
+
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTx
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.tx = &fakeTx{conn: c}
+	return c.tx, nil
+}
+
+type fakeTx struct {
+	conn    *fakeConn
+	batches []fakeBatch
+}
+
+func (tx *fakeTx) Commit() error {
+	db := tx.conn.db
+	db.mx.Lock()
+	db.active++
+	if db.active > db.peak {
+		db.peak = db.active
+	}
+	delay := db.delay
+	db.mx.Unlock()
+
+	time.Sleep(delay)
+
+	db.mx.Lock()
+	defer db.mx.Unlock()
+	db.active--
+
+	tx.conn.tx = nil
+	for _, b := range tx.batches {
+		db.queries = append(db.queries, b.query)
+	}
+	if db.fail != nil {
+		return db.fail
+	}
+	db.batches = append(db.batches, tx.batches...)
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+	rows  [][]driver.Value
+}
+
+func (s *fakeStmt) Close() error {
+	if s.conn.tx != nil && len(s.rows) > 0 {
+		s.conn.tx.batches = append(s.conn.tx.batches, fakeBatch{query: s.query, rows: s.rows})
+	}
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.rows = append(s.rows, append([]driver.Value(nil), args...))
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
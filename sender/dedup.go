@@ -0,0 +1,136 @@
+package sender
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/farwydi/ballistic"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// dedup remembers the idempotency keys pushed within a window.
+type dedup struct {
+	mx     sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+	// order holds the keys by push time, to forget them once out of the window.
+	order []seenKey
+	head  int
+	// memory holds the keys of the records kept in memory, they are not durable.
+	memory map[string]bool
+}
+
+type seenKey struct {
+	key  string
+	time time.Time
+}
+
+// newDedup returns the dedup of the window, nil if it is disabled.
+func newDedup(window time.Duration) *dedup {
+	if window <= 0 {
+		return nil
+	}
+	return &dedup{window: window, seen: map[string]time.Time{}, memory: map[string]bool{}}
+}
+
+// first reports whether the key wasn't seen within the window and remembers it.
+func (d *dedup) first(key string, now time.Time) bool {
+	if d == nil || key == "" {
+		return true
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	d.expire(now)
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	d.seen[key] = now
+	d.order = append(d.order, seenKey{key: key, time: now})
+	return true
+}
+
+// forget forgets the key, the push of its record failed.
+func (d *dedup) forget(key string) {
+	if d == nil || key == "" {
+		return
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	delete(d.seen, key)
+	delete(d.memory, key)
+}
+
+// keep marks the key of a record kept in memory.
+func (d *dedup) keep(key string) {
+	if d == nil || key == "" {
+		return
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if _, ok := d.seen[key]; ok {
+		d.memory[key] = true
+	}
+}
+
+// inMemory reports whether the record of the key was kept in memory.
+func (d *dedup) inMemory(key string) bool {
+	if d == nil || key == "" {
+		return false
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.memory[key]
+}
+
+func (d *dedup) expire(now time.Time) {
+	for d.head < len(d.order) && now.Sub(d.order[d.head].time) >= d.window {
+		k := d.order[d.head]
+		if t, ok := d.seen[k.key]; ok && t.Equal(k.time) {
+			delete(d.seen, k.key)
+			delete(d.memory, k.key)
+		}
+		d.order[d.head] = seenKey{}
+		d.head++
+	}
+	if d.head > len(d.order)/2 {
+		d.order = append(d.order[:0], d.order[d.head:]...)
+		d.head = 0
+	}
+}
+
+// deduplicationToken returns the token of a batch derived from the keys of its records,
+// empty if a record has no key.
+func deduplicationToken(dataModels []ballistic.DataModel) string {
+	h := sha256.New()
+	for _, dataModel := range dataModels {
+		key := ballistic.KeyOf(dataModel)
+		if key == "" {
+			return ""
+		}
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+var (
+	valuesClause   = regexp.MustCompile(`(?i)\sVALUES\b`)
+	settingsClause = regexp.MustCompile(`(?i)\sSETTINGS\s`)
+)
+
+// withSetting adds the ClickHouse setting to the SETTINGS clause of the insert,
+// the query is unchanged if it has no VALUES clause.
+func withSetting(query, name, value string) string {
+	loc := valuesClause.FindStringIndex(query)
+	if loc == nil {
+		return query
+	}
+
+	setting := name + " = '" + value + "'"
+	if settingsClause.MatchString(query[:loc[0]]) {
+		return query[:loc[0]] + ", " + setting + query[loc[0]:]
+	}
+	return query[:loc[0]] + " SETTINGS " + setting + query[loc[0]:]
+}
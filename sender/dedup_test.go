@@ -0,0 +1,172 @@
+package sender
+
+import (
+	"context"
+	"github.com/farwydi/ballistic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type keyedModel struct {
+	testModel
+	K string `json:"-"`
+}
+
+func (m *keyedModel) IdempotencyKey() string {
+	return m.K
+}
+
+func (m *keyedModel) SetIdempotencyKey(key string) {
+	m.K = key
+}
+
+func TestDedup(t *testing.T) {
+	d := newDedup(time.Minute)
+	now := time.Unix(0, 0)
+
+	assert.True(t, d.first("a", now))
+	assert.False(t, d.first("a", now.Add(30*time.Second)))
+	assert.True(t, d.first("b", now.Add(30*time.Second)))
+	assert.True(t, d.first("", now))
+
+	// Keys are forgotten once out of the window
+	assert.True(t, d.first("a", now.Add(time.Minute)))
+	assert.False(t, d.first("b", now.Add(time.Minute)))
+	assert.Len(t, d.seen, 2)
+
+	d.forget("a")
+	assert.True(t, d.first("a", now.Add(time.Minute)))
+
+	var disabled *dedup
+	assert.True(t, disabled.first("a", now))
+	assert.True(t, disabled.first("a", now))
+}
+
+func TestDedupPush(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	s := NewSender(nil, Config{FileWorkspace: tempDir, SendLimit: 100, DedupWindow: time.Minute})
+	for _, key := range []string{"k1", "k2", "k1", ""} {
+		require.NoError(t, s.Push(&keyedModel{testModel: testModel{Q: "a"}, K: key}))
+	}
+	assert.Equal(t, 3, s.backlog("a").Len)
+	assert.Equal(t, int64(1), s.Status().Duplicates)
+
+	// The keys are kept with the records on disk
+	models, err := s.filePool.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 3)
+	assert.Equal(t, "k2", ballistic.KeyOf(models[1]))
+}
+
+func TestDedupRestore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("a", 1, func() ballistic.DataModel {
+		return &keyedModel{testModel: testModel{Q: "a"}}
+	}))
+	cfg := Config{FileWorkspace: tempDir, Registry: registry, SendLimit: 100, DedupWindow: time.Minute}
+
+	s := NewSender(nil, cfg)
+	for _, key := range []string{"k1", "k2"} {
+		require.NoError(t, s.Push(&keyedModel{testModel: testModel{Q: "a"}, K: key}))
+	}
+
+	// The keys pending in the spool are remembered after a restart
+	s = NewSender(nil, cfg)
+	for _, key := range []string{"k1", "k3"} {
+		require.NoError(t, s.Push(&keyedModel{testModel: testModel{Q: "a"}, K: key}))
+	}
+	assert.Equal(t, int64(1), s.Status().Duplicates)
+	assert.Equal(t, 3, s.backlog("a").Len)
+}
+
+func TestDeduplicationToken(t *testing.T) {
+	assert.Equal(t, "INSERT INTO t (a) SETTINGS x = '1' VALUES (?)",
+		withSetting("INSERT INTO t (a) VALUES (?)", "x", "1"))
+	assert.Equal(t, "insert into t SETTINGS async_insert = 1, x = '1'\nvalues (?)",
+		withSetting("insert into t SETTINGS async_insert = 1\nvalues (?)", "x", "1"))
+	assert.Equal(t, "INSERT INTO t FORMAT Native", withSetting("INSERT INTO t FORMAT Native", "x", "1"))
+
+	keyed := func(keys ...string) []ballistic.DataModel {
+		models := make([]ballistic.DataModel, len(keys))
+		for i, key := range keys {
+			models[i] = &keyedModel{testModel: testModel{Q: "INSERT INTO t (n) VALUES (?)", N: i}, K: key}
+		}
+		return models
+	}
+	assert.Equal(t, deduplicationToken(keyed("a", "b")), deduplicationToken(keyed("a", "b")))
+	assert.NotEqual(t, deduplicationToken(keyed("a", "b")), deduplicationToken(keyed("ab")))
+	assert.Empty(t, deduplicationToken(keyed("a", "")))
+
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	s := NewSender(connect, Config{FileWorkspace: tempDir, SendLimit: 100, DeduplicationToken: true})
+
+	// A retried batch of the same keys carries the same token
+	require.NoError(t, s.publish(context.Background(), "INSERT INTO t (n) VALUES (?)", keyed("a", "b")))
+	require.NoError(t, s.publish(context.Background(), "INSERT INTO t (n) VALUES (?)", keyed("a", "b")))
+	require.NoError(t, s.publish(context.Background(), "INSERT INTO t (n) VALUES (?)", keyed("a", "")))
+
+	batches := db.committed()
+	require.Len(t, batches, 3)
+	token := deduplicationToken(keyed("a", "b"))
+	assert.Equal(t, "INSERT INTO t (n) SETTINGS insert_deduplication_token = '"+token+"' VALUES (?)", batches[0].query)
+	assert.Equal(t, batches[0].query, batches[1].query)
+	assert.Equal(t, "INSERT INTO t (n) VALUES (?)", batches[2].query)
+}
+
+func TestDeduplicationTokenRetry(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("t", 1, func() ballistic.DataModel {
+		return &keyedModel{testModel: testModel{Q: insertT}}
+	}))
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	s := NewSender(connect, Config{
+		FileWorkspace:      tempDir,
+		Registry:           registry,
+		SendInterval:       time.Hour,
+		SendLimit:          5,
+		DeduplicationToken: true,
+	})
+	for i, key := range []string{"a", "b", "c"} {
+		require.NoError(t, s.Push(&keyedModel{testModel: testModel{Q: insertT, N: i}, K: key}))
+	}
+	s.send(context.Background(), time.Now(), 0)
+
+	// Newer records don't join the failed batch, its retry carries the same token
+	require.NoError(t, s.Push(&keyedModel{testModel: testModel{Q: insertT, N: 3}, K: "d"}))
+	db.setFail(nil)
+	s.send(context.Background(), time.Now(), 0)
+
+	attempts := db.attempts()
+	require.GreaterOrEqual(t, len(attempts), 2)
+	assert.Contains(t, attempts[0], "insert_deduplication_token")
+	assert.Equal(t, attempts[0], attempts[1])
+	assert.Equal(t, []int64{0, 1, 2}, values(db.committed()[0]))
+}
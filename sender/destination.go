@@ -0,0 +1,304 @@
+package sender
+
+import (
+	"context"
+	"github.com/farwydi/ballistic"
+	"time"
+)
+
+// Destination overrides the sending of the records of one query.
+type Destination struct {
+	// BatchSize is the most records sent at once, SendLimit by default.
+	// The records are sent as soon as they reach it.
+	BatchSize int
+	// MaxBatchBytes splits the records into transactions of at most this binary size, 0 is unlimited.
+	MaxBatchBytes int
+	// FlushBytes sends the records as soon as their size reaches it, Config.FlushBytes by default.
+	FlushBytes int
+	// Interval between the sends, SendInterval by default.
+	Interval time.Duration
+	// MaxLatency sends before the interval once the oldest record waits that long,
+	// Config.MaxLatency by default.
+	MaxLatency time.Duration
+	// Limit is the rate of the records of the query sent, on top of Config.Limit.
+	Limit Limit
+	// Ordered delivers the records of one priority in the order they were pushed, see Sender.
+	Ordered bool
+}
+
+// destinationDefault fills the destination from the sender config.
+func destinationDefault(d Destination, cfg Config) Destination {
+	if d.BatchSize <= 0 {
+		d.BatchSize = cfg.SendLimit
+	}
+
+	if d.FlushBytes <= 0 {
+		d.FlushBytes = cfg.FlushBytes
+	}
+
+	if d.Interval <= 0 {
+		d.Interval = cfg.SendInterval
+	}
+
+	if d.MaxLatency <= 0 {
+		d.MaxLatency = cfg.MaxLatency
+	}
+
+	if d.Interval < minInterval {
+		d.Interval = minInterval
+	}
+
+	if d.MaxLatency > 0 && d.MaxLatency < minInterval {
+		d.MaxLatency = minInterval
+	}
+
+	return d
+}
+
+// route is the destination of a query scheduled on its own.
+type route struct {
+	Destination
+	next    time.Time
+	limiter *limiter
+}
+
+// route returns the route of the query of the model, nil if it has no destination.
+// Destinations are looked up by SQL and then by the registered model name.
+func (s *Sender) route(model ballistic.DataModel) *route {
+	query := model.SQL()
+
+	s.routesMx.Lock()
+	defer s.routesMx.Unlock()
+
+	r, ok := s.routes[query]
+	if ok {
+		return r
+	}
+
+	d, ok := s.cfg.Destinations[query]
+	if !ok {
+		if name, _, found := s.cfg.Registry.Lookup(model); found {
+			d, ok = s.cfg.Destinations[name]
+		}
+	}
+	if ok {
+		now := time.Now()
+		r = &route{Destination: d, next: now.Add(d.Interval), limiter: newLimiter(d.Limit, now)}
+	}
+	s.routes[query] = r
+	return r
+}
+
+// ordered reports whether the query has an ordered destination.
+func (s *Sender) ordered(query string) bool {
+	s.routesMx.Lock()
+	defer s.routesMx.Unlock()
+	r := s.routes[query]
+	return r != nil && r.Ordered
+}
+
+// shared reports whether the query has no destination and is sent within SendLimit.
+func (s *Sender) shared(query string) bool {
+	s.routesMx.Lock()
+	defer s.routesMx.Unlock()
+	return s.routes[query] == nil
+}
+
+// scheduled returns the routes of the queries with a destination.
+func (s *Sender) scheduled() map[string]*route {
+	s.routesMx.Lock()
+	defer s.routesMx.Unlock()
+
+	routes := make(map[string]*route, len(s.routes))
+	for query, r := range s.routes {
+		if r != nil {
+			routes[query] = r
+		}
+	}
+	return routes
+}
+
+// tick returns the period of the pusher, the shortest interval or latency.
+func (s *Sender) tick() time.Duration {
+	tick := s.cfg.SendInterval
+	if s.cfg.MaxLatency > 0 && s.cfg.MaxLatency < tick {
+		tick = s.cfg.MaxLatency
+	}
+	for _, d := range s.cfg.Destinations {
+		if d.Interval < tick {
+			tick = d.Interval
+		}
+		if d.MaxLatency > 0 && d.MaxLatency < tick {
+			tick = d.MaxLatency
+		}
+	}
+	return tick
+}
+
+// backlog returns the backlog of the query in both pools.
+func (s *Sender) backlog(query string) QueueStats {
+	memory, file := s.memoryPool.Backlog(query), s.filePool.Backlog(query)
+
+	b := QueueStats{
+		Len:    memory.Len + file.Len,
+		Bytes:  memory.Bytes + file.Bytes,
+		Oldest: memory.Oldest,
+	}
+	if b.Oldest.IsZero() || (!file.Oldest.IsZero() && file.Oldest.Before(b.Oldest)) {
+		b.Oldest = file.Oldest
+	}
+	return b
+}
+
+// backlogs returns the backlog of every query in both pools.
+func (s *Sender) backlogs() map[string]QueueStats {
+	queries := map[string]QueueStats{}
+	for _, pool := range []*Pool{s.memoryPool, s.filePool} {
+		for query := range pool.Stats() {
+			if _, ok := queries[query]; !ok {
+				queries[query] = s.backlog(query)
+			}
+		}
+	}
+	return queries
+}
+
+// full reports whether the backlog reaches the records or the bytes of a batch.
+func full(b QueueStats, records, bytes int) bool {
+	return b.Len > 0 && (b.Len >= records || (bytes > 0 && b.Bytes >= int64(bytes)))
+}
+
+// late reports whether the oldest record of the backlog waits longer than the latency.
+// The slack keeps the ticker jitter from delaying a send by a tick.
+func late(b QueueStats, latency time.Duration, now time.Time, slack time.Duration) bool {
+	return latency > 0 && b.Len > 0 && !b.Oldest.IsZero() && now.Add(slack).Sub(b.Oldest) >= latency
+}
+
+// limits returns the batch records and bytes of the query.
+func (s *Sender) limits(query string) (records, bytes int) {
+	s.routesMx.Lock()
+	r := s.routes[query]
+	s.routesMx.Unlock()
+
+	if r == nil {
+		return s.cfg.SendLimit, s.cfg.FlushBytes
+	}
+	return r.BatchSize, r.FlushBytes
+}
+
+// sizer returns the size of a model for the pools, nil if no flush depends on the size.
+func (s *Sender) sizer() func(model ballistic.DataModel) int {
+	bytes := s.cfg.FlushBytes > 0 || s.cfg.Limit.BytesPerSecond > 0
+	for _, d := range s.cfg.Destinations {
+		bytes = bytes || d.FlushBytes > 0 || d.Limit.BytesPerSecond > 0
+	}
+	if !bytes {
+		return nil
+	}
+	return modelSize
+}
+
+// modelSize returns the binary size of the model.
+func modelSize(model ballistic.DataModel) int {
+	data, err := model.MarshalBinary()
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// sendRoute sends up to BatchSize records of the query.
+// The batches of an ordered destination following a failed one are held behind it.
+func (s *Sender) sendRoute(ctx context.Context, query string, r *route) error {
+	var err error
+	for _, batch := range s.ejectRoute(query, r) {
+		if err != nil && r.Ordered {
+			s.holdBatch(query, batch, s.cfg.UseMemoryFallback)
+			continue
+		}
+		if berr := s.sendBatch(ctx, query, batch, s.cfg.UseMemoryFallback); berr != nil && err == nil {
+			err = berr
+		}
+	}
+	return err
+}
+
+// ejectRoute ejects up to BatchSize records of the query the limits allow, memory first,
+// split into batches of at most MaxBatchBytes. An ordered destination ejects from file first,
+// its memory records are newer.
+func (s *Sender) ejectRoute(query string, r *route) [][]ballistic.DataModel {
+	now, size := time.Now(), averageSize(s.backlog(query))
+	limit := minAllowance(r.BatchSize, minAllowance(r.limiter.allow(now, size), s.limiter.allow(now, size)))
+	if limit == 0 {
+		return nil
+	}
+
+	first, second := s.memoryPool, s.filePool
+	if r.Ordered {
+		first, second = s.filePool, s.memoryPool
+	}
+
+	dataModels, err := first.EjectQuery(query, limit)
+	if err != nil {
+		s.logger.Warnw("problem ejecting queue", "query", query, "error", err)
+		s.failed(err)
+	}
+
+	if rest := limit - len(dataModels); rest > 0 {
+		ejectModels, err := second.EjectQuery(query, rest)
+		if err != nil {
+			s.logger.Warnw("problem ejecting queue", "query", query, "error", err)
+			s.failed(err)
+		}
+		dataModels = append(dataModels, ejectModels...)
+	}
+	spend(dataModels, s.limiter, r.limiter)
+	return splitBatch(dataModels, r.MaxBatchBytes)
+}
+
+// averageSize returns the average binary size of the records of the backlog.
+func averageSize(b QueueStats) float64 {
+	if b.Len == 0 {
+		return 0
+	}
+	return float64(b.Bytes) / float64(b.Len)
+}
+
+// throttled reports whether the limiters hold back a batch of the records of the backlog,
+// the pusher is woken once they let it through.
+func (s *Sender) throttled(now time.Time, b QueueStats, records int, limiters ...*limiter) bool {
+	var wait time.Duration
+	for _, l := range limiters {
+		if w := l.wait(now, records, averageSize(b)); w > wait {
+			wait = w
+		}
+	}
+	if wait <= 0 {
+		return false
+	}
+	time.AfterFunc(wait, s.wake)
+	return true
+}
+
+// splitBatch splits the models into batches of at most maxBytes of binary size,
+// a model larger than maxBytes is a batch of its own.
+func splitBatch(dataModels []ballistic.DataModel, maxBytes int) [][]ballistic.DataModel {
+	if len(dataModels) == 0 {
+		return nil
+	}
+	if maxBytes <= 0 {
+		return [][]ballistic.DataModel{dataModels}
+	}
+
+	var batches [][]ballistic.DataModel
+	start, size := 0, 0
+	for i, dataModel := range dataModels {
+		n := modelSize(dataModel)
+		if i > start && size+n > maxBytes {
+			batches = append(batches, dataModels[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	return append(batches, dataModels[start:])
+}
@@ -0,0 +1,90 @@
+package sender
+
+import (
+	"context"
+	"github.com/farwydi/ballistic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDestinations(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("metrics", 1, func() ballistic.DataModel {
+		return &otherModel{}
+	}))
+
+	db, connect := newFakeDB()
+	start := time.Now()
+	s := NewSender(connect, Config{
+		FileWorkspace: tempDir,
+		Registry:      registry,
+		SendLimit:     100,
+		SendInterval:  time.Minute,
+		Destinations: map[string]Destination{
+			// By SQL
+			"alerts": {BatchSize: 2, Interval: 200 * time.Millisecond},
+			// By model name, every record is larger than half the batch bytes
+			"metrics": {MaxBatchBytes: 40, MaxLatency: 5 * time.Second},
+		},
+	})
+	s.nextShared = start.Add(time.Minute)
+	assert.Equal(t, 200*time.Millisecond, s.tick())
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Push(&testModel{Q: "alerts", N: i}))
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Push(&otherModel{testModel{Q: "insert metrics", N: i}}))
+		require.NoError(t, s.Push(&testModel{Q: "shared", N: i}))
+	}
+
+	// Full batches of alerts are sent right away
+	ctx := context.Background()
+	assert.True(t, s.send(ctx, start, 0))
+	assert.Equal(t, []int64{0, 1}, db.rows("alerts"))
+	assert.False(t, s.send(ctx, start, 0))
+	assert.Equal(t, []int64{0, 1, 2, 3}, db.rows("alerts"))
+
+	// The rest after the interval
+	s.send(ctx, start.Add(100*time.Millisecond), 0)
+	assert.Equal(t, []int64{0, 1, 2, 3}, db.rows("alerts"))
+	s.send(ctx, start.Add(250*time.Millisecond), 0)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, db.rows("alerts"))
+
+	// Metrics go after their max latency, one record per transaction
+	s.send(ctx, start.Add(6*time.Second), 0)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, db.rows("alerts"))
+	assert.Equal(t, []int64{0, 1, 2}, db.rows("insert metrics"))
+	assert.Empty(t, db.rows("shared"))
+
+	metrics := 0
+	for _, b := range db.committed() {
+		if b.query == "insert metrics" {
+			metrics++
+			assert.Len(t, b.rows, 1)
+		}
+	}
+	assert.Equal(t, 3, metrics)
+
+	// The rest wait for the shared interval
+	s.send(ctx, start.Add(61*time.Second), 0)
+	assert.Equal(t, []int64{0, 1, 2}, db.rows("shared"))
+}
+
+func TestSplitBatch(t *testing.T) {
+	models := []ballistic.DataModel{&testModel{N: 1}, &testModel{N: 2}, &testModel{N: 3}}
+	assert.Len(t, splitBatch(models, 0), 1)
+	assert.Len(t, splitBatch(models, 1), 3)
+	assert.Equal(t, [][]ballistic.DataModel{models[:2], models[2:]}, splitBatch(models, 30))
+	assert.Nil(t, splitBatch(nil, 10))
+}
@@ -0,0 +1,141 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOrderedMemory fails a durable push to an ordered destination holding records in memory,
+// written to the file pool the record would be sent before them.
+var ErrOrderedMemory = fmt.Errorf("newer records of the ordered destination are held in memory")
+
+// DurabilityError is returned by PushDurable when the record is not on stable storage.
+// Written is set if the record was written to the file pool but not synced,
+// it is sent like the other records then.
+type DurabilityError struct {
+	Err     error
+	Written bool
+}
+
+func (e *DurabilityError) Error() string {
+	if e.Written {
+		return fmt.Sprintf("record written but not synced: %v", e.Err)
+	}
+	return fmt.Sprintf("record not written: %v", e.Err)
+}
+
+func (e *DurabilityError) Unwrap() error {
+	return e.Err
+}
+
+// PushDurable pushes the model to the file pool and returns once it is synced to stable storage,
+// the pushes waiting meanwhile share the next sync. It never falls back to memory nor goes through
+// the ring of Async, a *DurabilityError is returned if the record is not durable.
+// A duplicate within DedupWindow is dropped like by Push, nil is returned once its earlier copy
+// is synced and ErrInMemory if the copy was kept in memory. Bypassing the ring, the record may be
+// written before the ones pushed asynchronously earlier, also to an ordered destination,
+// durable pushes in the order of the ring are made with PushAsync and Future.WaitDurable.
+func (s *Sender) PushDurable(ctx context.Context, model ballistic.DataModel) error {
+	if atomic.LoadInt32(&s.isShutdown) == 1 {
+		return &DurabilityError{Err: ErrShutdown}
+	}
+
+	query := model.SQL()
+	key := ballistic.KeyOf(model)
+	if !s.dedup.first(key, time.Now()) {
+		atomic.AddInt64(&s.duplicates, 1)
+		// The earlier copy is durable once its queue is synced, unless it was kept in memory
+		if s.dedup.inMemory(key) {
+			return &DurabilityError{Err: ErrInMemory}
+		}
+		if err := s.commits.sync(ctx, query); err != nil {
+			return &DurabilityError{Err: err, Written: true}
+		}
+		return nil
+	}
+
+	if r := s.route(model); r != nil && r.Ordered && s.memoryPool.Backlog(query).Len > 0 {
+		s.dedup.forget(key)
+		return &DurabilityError{Err: ErrOrderedMemory}
+	}
+
+	if err := s.filePool.Push(model); err != nil {
+		s.dedup.forget(key)
+		return &DurabilityError{Err: err}
+	}
+	defer s.flushFull(query)
+
+	if err := s.commits.sync(ctx, query); err != nil {
+		return &DurabilityError{Err: err, Written: true}
+	}
+	return nil
+}
+
+// groupCommit syncs the queues written by the durable pushes, a round syncs the queues
+// written while the previous one was syncing.
+type groupCommit struct {
+	syncQuery func(query string) error
+
+	mx      sync.Mutex
+	next    *commitRound
+	running bool
+}
+
+type commitRound struct {
+	queries map[string]bool
+	errs    map[string]error
+	done    chan struct{}
+}
+
+func newGroupCommit(syncQuery func(query string) error) *groupCommit {
+	return &groupCommit{syncQuery: syncQuery}
+}
+
+// sync waits for a round syncing the queue of the query after it was written.
+func (g *groupCommit) sync(ctx context.Context, query string) error {
+	g.mx.Lock()
+	if g.next == nil {
+		g.next = &commitRound{queries: map[string]bool{}, done: make(chan struct{})}
+	}
+	round := g.next
+	round.queries[query] = true
+	if !g.running {
+		g.running = true
+		go g.run()
+	}
+	g.mx.Unlock()
+
+	select {
+	case <-round.done:
+		return round.errs[query]
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run syncs the rounds until none is waiting.
+func (g *groupCommit) run() {
+	for {
+		g.mx.Lock()
+		round := g.next
+		g.next = nil
+		if round == nil {
+			g.running = false
+			g.mx.Unlock()
+			return
+		}
+		g.mx.Unlock()
+
+		round.errs = map[string]error{}
+		for query := range round.queries {
+			if err := g.syncQuery(query); err != nil {
+				round.errs[query] = err
+			}
+		}
+		close(round.done)
+	}
+}
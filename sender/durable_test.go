@@ -0,0 +1,134 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPushDurable(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	_, connect := newFakeDB()
+	s := NewSender(connect, Config{FileWorkspace: tempDir})
+
+	var syncs int32
+	syncQuery := s.commits.syncQuery
+	s.commits.syncQuery = func(query string) error {
+		atomic.AddInt32(&syncs, 1)
+		time.Sleep(10 * time.Millisecond)
+		return syncQuery(query)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, s.PushDurable(context.Background(), &testModel{Q: insertT, N: i}))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, s.filePool.Backlog(insertT).Len)
+	// The pushes waiting for a sync share the next one
+	assert.Less(t, atomic.LoadInt32(&syncs), int32(50))
+}
+
+func TestPushDurableDuplicate(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	_, connect := newFakeDB()
+	s := NewSender(connect, Config{FileWorkspace: tempDir, DedupWindow: time.Minute})
+	var syncs int32
+	s.commits.syncQuery = func(string) error {
+		atomic.AddInt32(&syncs, 1)
+		return nil
+	}
+
+	// The earlier copy is synced
+	require.NoError(t, s.Push(&keyedModel{testModel: testModel{Q: insertT}, K: "k1"}))
+	assert.NoError(t, s.PushDurable(context.Background(), &keyedModel{testModel: testModel{Q: insertT}, K: "k1"}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&syncs))
+	assert.Equal(t, 1, s.filePool.Backlog(insertT).Len)
+
+	var durabilityErr *DurabilityError
+	s.commits.syncQuery = func(string) error {
+		return assert.AnError
+	}
+	err = s.PushDurable(context.Background(), &keyedModel{testModel: testModel{Q: insertT}, K: "k1"})
+	require.ErrorAs(t, err, &durabilityErr)
+	assert.True(t, durabilityErr.Written)
+
+	// A workspace that is a file fails the writes, the earlier copy is kept in memory
+	workspace := filepath.Join(tempDir, "file")
+	require.NoError(t, ioutil.WriteFile(workspace, nil, os.ModePerm))
+	s = NewSender(connect, Config{FileWorkspace: workspace, UseMemoryFallback: true, DedupWindow: time.Minute})
+	require.NoError(t, s.Push(&keyedModel{testModel: testModel{Q: insertT}, K: "k2"}))
+	err = s.PushDurable(context.Background(), &keyedModel{testModel: testModel{Q: insertT}, K: "k2"})
+	require.ErrorAs(t, err, &durabilityErr)
+	assert.False(t, durabilityErr.Written)
+	assert.ErrorIs(t, err, ErrInMemory)
+	assert.Equal(t, int64(1), s.Status().Duplicates)
+}
+
+func TestPushDurableFailure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	// A workspace that is a file fails the writes
+	workspace := filepath.Join(tempDir, "file")
+	require.NoError(t, ioutil.WriteFile(workspace, nil, os.ModePerm))
+	_, connect := newFakeDB()
+	s := NewSender(connect, Config{FileWorkspace: workspace, UseMemoryFallback: true})
+
+	var durabilityErr *DurabilityError
+	err = s.PushDurable(context.Background(), &testModel{Q: insertT})
+	require.ErrorAs(t, err, &durabilityErr)
+	assert.False(t, durabilityErr.Written)
+	assert.Zero(t, s.memoryPool.Backlog(insertT).Len)
+
+	// The sync fails
+	s = NewSender(connect, orderedConfig(t, tempDir))
+	s.commits.syncQuery = func(string) error {
+		return assert.AnError
+	}
+	err = s.PushDurable(context.Background(), &testModel{Q: insertT})
+	require.ErrorAs(t, err, &durabilityErr)
+	assert.True(t, durabilityErr.Written)
+	assert.True(t, errors.Is(err, assert.AnError))
+	assert.Equal(t, 1, s.filePool.Backlog(insertT).Len)
+
+	// Newer records of an ordered destination follow the ones in memory
+	require.NoError(t, s.memoryPool.Push(&testModel{Q: insertT}))
+	err = s.PushDurable(context.Background(), &testModel{Q: insertT})
+	require.ErrorAs(t, err, &durabilityErr)
+	assert.False(t, durabilityErr.Written)
+	assert.ErrorIs(t, err, ErrOrderedMemory)
+	assert.Equal(t, 1, s.filePool.Backlog(insertT).Len)
+
+	go s.RunPusher(context.Background())
+	s.Stop(false)
+	err = s.PushDurable(context.Background(), &testModel{Q: insertT})
+	require.ErrorAs(t, err, &durabilityErr)
+	assert.ErrorIs(t, err, ErrShutdown)
+}
@@ -0,0 +1,80 @@
+package sender
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFlushTriggers(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		push   int
+		expect int
+	}{
+		// Full batches are sent back to back, the rest waits for the interval
+		{name: "records", cfg: Config{SendLimit: 10}, push: 95, expect: 90},
+		// A record is 15 bytes
+		{name: "bytes", cfg: Config{SendLimit: 1000, FlushBytes: 70}, push: 5, expect: 5},
+		{name: "latency", cfg: Config{SendLimit: 1000, MaxLatency: 100 * time.Millisecond}, push: 3, expect: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir, err := ioutil.TempDir("", "ballistic")
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, os.RemoveAll(tempDir))
+			}()
+
+			db, connect := newFakeDB()
+			cfg := tt.cfg
+			cfg.FileWorkspace = tempDir
+			cfg.SendInterval = time.Hour
+			s := NewSender(connect, cfg)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go s.RunPusher(ctx)
+
+			for i := 0; i < tt.push; i++ {
+				require.NoError(t, s.Push(&testModel{Q: "a", N: i}))
+			}
+
+			require.Eventually(t, func() bool {
+				return len(db.rows("a")) == tt.expect
+			}, 2*time.Second, 10*time.Millisecond)
+			time.Sleep(50 * time.Millisecond)
+			assert.Len(t, db.rows("a"), tt.expect)
+			assert.Equal(t, tt.push-tt.expect, s.backlog("a").Len)
+		})
+	}
+}
+
+func TestFlushAfterFailure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	s := NewSender(connect, Config{FileWorkspace: tempDir, SendInterval: time.Hour, SendLimit: 2})
+
+	// A failed send is not retried back to back
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Push(&testModel{Q: "a", N: i}))
+	}
+	assert.False(t, s.send(context.Background(), time.Now(), 0))
+	assert.Equal(t, 4, s.backlog("a").Len)
+
+	db.setFail(nil)
+	assert.True(t, s.send(context.Background(), time.Now(), 0))
+	assert.False(t, s.send(context.Background(), time.Now(), 0))
+	assert.Len(t, db.rows("a"), 4)
+}
@@ -0,0 +1,128 @@
+package sender
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Status is a snapshot of the sender state.
+type Status struct {
+	Running  bool `json:"running"`
+	Shutdown bool `json:"shutdown"`
+	// CatchingUp is set while the backlog is drained, see Config.CatchUp.
+	CatchingUp bool `json:"catching_up"`
+
+	Memory map[string]QueueStats `json:"memory"`
+	File   map[string]QueueStats `json:"file"`
+
+	// OldestRecordAge is the age of the oldest backlog across both pools, approximate like QueueStats.Oldest.
+	OldestRecordAge time.Duration `json:"-"`
+	OldestRecordSec float64       `json:"oldest_record_age_seconds"`
+
+	// LastPublish and LastErrorTime are zero until the first publish and error.
+	LastPublish   time.Time `json:"last_publish"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+
+	// SealedBatches counts the sealed batches held for a retry.
+	SealedBatches int `json:"sealed_batches,omitempty"`
+	// UnreadBatches counts the sealed batches left in the workspace by a previous run
+	// that couldn't be read, like the ones of models missing from the Registry.
+	// They are not retried until read by a restart.
+	UnreadBatches int `json:"unread_batches,omitempty"`
+	// Duplicates counts the records dropped by the DedupWindow.
+	Duplicates int64 `json:"duplicates"`
+	// Ingress counts the records pushed asynchronously not written yet, see Config.Async.
+	Ingress int `json:"ingress"`
+	// Dropped counts the records dropped from the full ring of the asynchronous pushes.
+	Dropped int64 `json:"dropped"`
+
+	// WorkspaceBytes is the size of the spools, sealed batches and dead letters of the workspace.
+	WorkspaceBytes int64  `json:"workspace_bytes"`
+	WorkspaceError string `json:"workspace_error,omitempty"`
+}
+
+// Status returns the current state of the sender.
+func (s *Sender) Status() Status {
+	st := Status{
+		Running:    atomic.LoadInt32(&s.isRunning) == 1,
+		Shutdown:   atomic.LoadInt32(&s.isShutdown) == 1,
+		CatchingUp: atomic.LoadInt32(&s.isCatchingUp) == 1,
+		Duplicates: atomic.LoadInt64(&s.duplicates),
+		Ingress:    len(s.ingress),
+		Dropped:    atomic.LoadInt64(&s.dropped),
+		Memory:     s.memoryPool.Stats(),
+		File:       s.filePool.Stats(),
+	}
+
+	now := time.Now()
+	for _, stats := range []map[string]QueueStats{st.Memory, st.File} {
+		for _, qs := range stats {
+			if qs.Len == 0 || qs.Oldest.IsZero() {
+				continue
+			}
+			if age := now.Sub(qs.Oldest); age > st.OldestRecordAge {
+				st.OldestRecordAge = age
+			}
+		}
+	}
+	st.OldestRecordSec = st.OldestRecordAge.Seconds()
+
+	if s.batches != nil {
+		st.SealedBatches = s.batches.len()
+		st.UnreadBatches = s.batches.unreadLen()
+	}
+
+	s.stateMx.Lock()
+	st.LastPublish = s.lastPublish
+	if s.lastError != nil {
+		st.LastError = s.lastError.Error()
+		st.LastErrorTime = s.lastErrorTime
+	}
+	s.stateMx.Unlock()
+
+	size, err := workspaceUsage(s.cfg.FileWorkspace)
+	if err != nil {
+		st.WorkspaceError = err.Error()
+	}
+	st.WorkspaceBytes = size
+
+	return st
+}
+
+// HealthHandler returns a handler exposing the sender state:
+//
+//	/live   200 while the pusher is running
+//	/ready  200 while the pusher is running, the sender is not shut down
+//	        and the oldest record is younger than maxBacklogAge (0 disables the check)
+//	/status the Status as JSON
+func (s *Sender) HealthHandler(maxBacklogAge time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live", func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.LoadInt32(&s.isRunning) == 0 {
+			http.Error(w, "pusher is not running", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, _ *http.Request) {
+		st := s.Status()
+		switch {
+		case !st.Running:
+			http.Error(w, "pusher is not running", http.StatusServiceUnavailable)
+		case st.Shutdown:
+			http.Error(w, "sender is shutdown", http.StatusServiceUnavailable)
+		case maxBacklogAge > 0 && st.OldestRecordAge > maxBacklogAge:
+			http.Error(w, "backlog is too old: "+st.OldestRecordAge.String(), http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Status())
+	})
+	return mux
+}
@@ -0,0 +1,85 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type testModel struct {
+	Q string
+	N int
+}
+
+func (t *testModel) SQL() string {
+	return t.Q
+}
+
+func (t *testModel) ToExec() []interface{} {
+	return []interface{}{t.N}
+}
+
+func (t *testModel) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, t)
+}
+
+func (t testModel) MarshalBinary() (data []byte, err error) {
+	return json.Marshal(t)
+}
+
+func TestHealthHandler(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	s := NewSender(nil, Config{
+		FileWorkspace: tempDir,
+		SendInterval:  time.Hour,
+		SendLimit:     100,
+	})
+	h := s.HealthHandler(50 * time.Millisecond)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, get("/live").Code)
+
+	go s.RunPusher(context.Background())
+	require.Eventually(t, func() bool {
+		return get("/live").Code == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, get("/ready").Code)
+
+	require.NoError(t, s.Push(&testModel{Q: "a", N: 1}))
+	require.NoError(t, s.Push(&testModel{Q: "a", N: 2}))
+	require.NoError(t, s.Push(&testModel{Q: "b", N: 3}))
+
+	var st Status
+	require.NoError(t, json.NewDecoder(get("/status").Body).Decode(&st))
+	assert.True(t, st.Running)
+	assert.False(t, st.Shutdown)
+	assert.Equal(t, 2, st.File["a"].Len)
+	assert.Equal(t, 1, st.File["b"].Len)
+	assert.NotZero(t, st.WorkspaceBytes)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/ready").Code)
+
+	s.Stop(false)
+	assert.Eventually(t, func() bool {
+		return get("/live").Code == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, s.Status().Shutdown)
+}
@@ -0,0 +1,156 @@
+package sender
+
+import (
+	"github.com/farwydi/ballistic"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is the rate of the records sent, a token bucket holding one second of the rate.
+// Records held back stay in the queues, the tail sent on Stop is not limited.
+type Limit struct {
+	// RowsPerSecond is the most records sent per second, 0 is unlimited.
+	RowsPerSecond float64
+	// BytesPerSecond is the most binary size sent per second, 0 is unlimited.
+	BytesPerSecond float64
+}
+
+// limiter holds the token buckets of a Limit.
+type limiter struct {
+	mx    sync.Mutex
+	rows  *bucket
+	bytes *bucket
+}
+
+// newLimiter returns the limiter of the limit, nil if it is unlimited.
+func newLimiter(l Limit, now time.Time) *limiter {
+	if l.RowsPerSecond <= 0 && l.BytesPerSecond <= 0 {
+		return nil
+	}
+	return &limiter{
+		rows:  newBucket(l.RowsPerSecond, now),
+		bytes: newBucket(l.BytesPerSecond, now),
+	}
+}
+
+// allow returns the records that can be sent now, each of the size on average, -1 if unlimited.
+func (l *limiter) allow(now time.Time, size float64) int {
+	if l == nil {
+		return -1
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	return minAllowance(l.rows.allow(now, 1), l.bytes.allow(now, size))
+}
+
+// take spends the tokens of the records sent, the buckets go in debt if they overdraw.
+func (l *limiter) take(rows int, bytes int64) {
+	if l == nil {
+		return
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.rows.take(float64(rows))
+	l.bytes.take(float64(bytes))
+}
+
+// wait returns the time until the records, each of the size on average, can be sent.
+func (l *limiter) wait(now time.Time, records int, size float64) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	rows, bytes := l.rows.wait(now, float64(records)), l.bytes.wait(now, float64(records)*size)
+	if bytes > rows {
+		return bytes
+	}
+	return rows
+}
+
+// spend takes the tokens of the models sent from the limiters.
+func spend(dataModels []ballistic.DataModel, limiters ...*limiter) {
+	var bytes int64
+	for _, l := range limiters {
+		if l != nil && l.bytes != nil {
+			for _, dataModel := range dataModels {
+				bytes += int64(modelSize(dataModel))
+			}
+			break
+		}
+	}
+	for _, l := range limiters {
+		l.take(len(dataModels), bytes)
+	}
+}
+
+// bucket refills at the rate up to one second of it, nil is unlimited.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, now time.Time) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: rate, tokens: rate, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// need returns the tokens of an item of the size, a full bucket lets any item through.
+func (b *bucket) need(size float64) float64 {
+	return math.Min(math.Max(size, 1), b.rate)
+}
+
+// allow returns the items of the size the tokens cover, -1 if unlimited.
+func (b *bucket) allow(now time.Time, size float64) int {
+	if b == nil {
+		return -1
+	}
+	b.refill(now)
+	if b.tokens < b.need(size) {
+		return 0
+	}
+	return int(math.Max(1, math.Floor(b.tokens/math.Max(size, 1))))
+}
+
+func (b *bucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+func (b *bucket) wait(now time.Time, size float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	missing := b.need(size) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing / b.rate * float64(time.Second)))
+}
+
+// minAllowance returns the smaller allowance, -1 is unlimited.
+func minAllowance(a, b int) int {
+	if a < 0 {
+		return b
+	}
+	if b < 0 {
+		return a
+	}
+	return minInt(a, b)
+}
@@ -0,0 +1,105 @@
+package sender
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newLimiter(Limit{RowsPerSecond: 10, BytesPerSecond: 100}, now)
+	assert.Nil(t, newLimiter(Limit{}, now))
+
+	// A record of 15 bytes, the bytes limit 6 of them
+	assert.Equal(t, 6, l.allow(now, 15))
+	l.take(6, 90)
+	assert.Equal(t, 0, l.allow(now, 15))
+	assert.Equal(t, 50*time.Millisecond, l.wait(now, 1, 15))
+	// Waits for no more than a full bucket
+	assert.Equal(t, 900*time.Millisecond, l.wait(now, 1000, 15))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, 6, l.allow(now, 10))
+
+	// A record larger than the bucket is let through once it is full, in debt
+	now = now.Add(time.Second)
+	assert.Equal(t, 1, l.allow(now, 500))
+	l.take(1, 500)
+	assert.Equal(t, 5*time.Second, l.wait(now, 1, 500))
+
+	var unlimited *limiter
+	assert.Equal(t, -1, unlimited.allow(now, 15))
+	assert.Zero(t, unlimited.wait(now, 10, 15))
+}
+
+func TestSendLimit(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	s := NewSender(connect, Config{
+		FileWorkspace: tempDir,
+		SendInterval:  time.Hour,
+		SendLimit:     20,
+		Limit:         Limit{RowsPerSecond: 200},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.RunPusher(ctx)
+
+	start := time.Now()
+	for i := 0; i < 300; i++ {
+		require.NoError(t, s.Push(&testModel{Q: "a", N: i}))
+	}
+
+	// A second of the rate goes at once, the records held back stay on disk
+	require.Eventually(t, func() bool {
+		return len(db.rows("a")) >= 200
+	}, time.Second, 5*time.Millisecond)
+	assert.Less(t, len(db.rows("a")), 300)
+	assert.Equal(t, 300-len(db.rows("a")), s.filePool.Backlog("a").Len)
+
+	require.Eventually(t, func() bool {
+		return len(db.rows("a")) == 300
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestDestinationLimit(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	_, connect := newFakeDB()
+	s := NewSender(connect, Config{
+		FileWorkspace: tempDir,
+		SendInterval:  time.Hour,
+		SendLimit:     1000,
+		Destinations: map[string]Destination{
+			"b": {BatchSize: 100, Limit: Limit{BytesPerSecond: 150}},
+		},
+	})
+
+	for i := 0; i < 30; i++ {
+		require.NoError(t, s.Push(&testModel{Q: "b", N: i % 10}))
+	}
+
+	// A record is 15 bytes
+	r := s.scheduled()["b"]
+	batches := s.ejectRoute("b", r)
+	require.Len(t, batches, 1)
+	assert.Len(t, batches[0], 10)
+	assert.Empty(t, s.ejectRoute("b", r))
+	assert.Equal(t, 20, s.backlog("b").Len)
+}
@@ -0,0 +1,117 @@
+package sender
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func orderedConfig(t *testing.T, workspace string) Config {
+	cfg := sealedConfig(t, workspace)
+	cfg.SealBatches = false
+	cfg.DeduplicationToken = false
+	cfg.Destinations = map[string]Destination{
+		// Every record is a transaction of its own
+		"t": {BatchSize: 3, MaxBatchBytes: 1, Ordered: true},
+	}
+	return cfg
+}
+
+func TestOrderedFailure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	s := NewSender(connect, orderedConfig(t, tempDir))
+
+	for i := 0; i < 6; i++ {
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+
+	// The first batch fails, the ones after it are held behind
+	now := time.Now()
+	assert.False(t, s.send(context.Background(), now, 0))
+	assert.Equal(t, 3, s.Status().SealedBatches)
+	assert.Equal(t, 3, s.backlog(insertT).Len)
+
+	// The query waits for its held batches
+	require.NoError(t, s.Push(&testModel{Q: insertT, N: 6}))
+	now = now.Add(time.Hour)
+	s.send(context.Background(), now, 0)
+	assert.Equal(t, 3, s.Status().SealedBatches)
+	assert.Equal(t, 4, s.backlog(insertT).Len)
+
+	db.setFail(nil)
+	for s.backlog(insertT).Len > 0 || s.Status().SealedBatches > 0 {
+		now = now.Add(time.Hour)
+		s.send(context.Background(), now, 0)
+	}
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6}, db.rows(insertT))
+}
+
+func TestOrderedMemoryFallback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	s := NewSender(connect, orderedConfig(t, tempDir))
+
+	require.NoError(t, s.Push(&testModel{Q: insertT, N: 0}))
+	require.NoError(t, s.Push(&testModel{Q: insertT, N: 1}))
+	// The disk failed for this one
+	require.NoError(t, s.memoryPool.Push(&testModel{Q: insertT, N: 2}))
+
+	// Newer records follow it to memory
+	require.NoError(t, s.Push(&testModel{Q: insertT, N: 3}))
+	assert.Equal(t, 2, s.filePool.Backlog(insertT).Len)
+	assert.Equal(t, 2, s.memoryPool.Backlog(insertT).Len)
+
+	now := time.Now()
+	for s.backlog(insertT).Len > 0 {
+		now = now.Add(time.Hour)
+		s.send(context.Background(), now, 0)
+	}
+	assert.Equal(t, []int64{0, 1, 2, 3}, db.rows(insertT))
+
+	// Once drained the records go to disk again
+	require.NoError(t, s.Push(&testModel{Q: insertT, N: 4}))
+	assert.Equal(t, 1, s.filePool.Backlog(insertT).Len)
+}
+
+func TestOrderedStop(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	db.setFail(assert.AnError)
+	s := NewSender(connect, orderedConfig(t, tempDir))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Push(&testModel{Q: insertT, N: i}))
+	}
+	s.send(context.Background(), time.Now(), 0)
+
+	// The tail is held behind the failed batches for the next run
+	go s.RunPusher(context.Background())
+	s.Stop(true)
+	assert.Empty(t, db.committed())
+
+	db.setFail(nil)
+	s = NewSender(connect, orderedConfig(t, tempDir))
+	assert.Equal(t, 4, s.Status().SealedBatches)
+	s.send(context.Background(), time.Now(), 0)
+	assert.Equal(t, []int64{0, 1, 2, 3}, db.rows(insertT))
+}
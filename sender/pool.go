@@ -2,25 +2,240 @@ package sender
 
 import (
 	"github.com/farwydi/ballistic"
+	"sort"
 	"sync"
+	"time"
 )
 
 type NewQueueFunc = func(model ballistic.DataModel) (ballistic.Queue, error)
 
+// NewTypedQueueFunc opens the queue of the model.
+type NewTypedQueueFunc[T ballistic.DataModel] func(model T) (ballistic.TypedQueue[T], error)
+
+// Pool keeps a queue of any models per SQL, the queues are adapted to TypedQueue.
+type Pool = TypedPool[ballistic.DataModel]
+
 func NewPool(newQueue NewQueueFunc) ballistic.Pool {
-	return &Pool{
+	return NewTypedPool(func(model ballistic.DataModel) (ballistic.TypedQueue[ballistic.DataModel], error) {
+		queue, err := newQueue(model)
+		if err != nil {
+			return nil, err
+		}
+		return ballistic.TypedAdapter[ballistic.DataModel]{Queue: queue}, nil
+	})
+}
+
+func NewTypedPool[T ballistic.DataModel](newQueue NewTypedQueueFunc[T]) *TypedPool[T] {
+	return &TypedPool[T]{
 		newQueue:  newQueue,
-		openQueue: map[string]ballistic.Queue{},
+		openQueue: map[string]ballistic.TypedQueue[T]{},
+		ages:      map[string]*queueAges{},
+		strategy:  RoundRobin,
+		now:       time.Now,
 	}
 }
 
-type Pool struct {
-	newQueue  NewQueueFunc
+// TypedPool keeps a queue of models of type T per SQL.
+type TypedPool[T ballistic.DataModel] struct {
+	newQueue  NewTypedQueueFunc[T]
 	ofsMx     sync.Mutex
-	openQueue map[string]ballistic.Queue
+	openQueue map[string]ballistic.TypedQueue[T]
+	ages      map[string]*queueAges
+	strategy  Strategy
+	round     int
+	now       func() time.Time
+	size      func(model T) int
+}
+
+// ageResolution merges the push times of the records pushed within it.
+const ageResolution = time.Second
+
+// backlog tracks the push times and the sizes of the pending records of a queue.
+type backlog struct {
+	buckets []ageBucket
+	total   int
+	bytes   int64
+}
+
+type ageBucket struct {
+	time  time.Time
+	n     int
+	bytes int64
+}
+
+func (b *backlog) pushed(now time.Time, n int, bytes int64) {
+	if last := len(b.buckets) - 1; last >= 0 && now.Sub(b.buckets[last].time) < ageResolution {
+		b.buckets[last].n += n
+		b.buckets[last].bytes += bytes
+	} else {
+		b.buckets = append(b.buckets, ageBucket{time: now, n: n, bytes: bytes})
+	}
+	b.total += n
+	b.bytes += bytes
+}
+
+// trim forgets the oldest records down to the length of the queue,
+// the records of a bucket count the same bytes.
+func (b *backlog) trim(n int) {
+	for b.total > n && len(b.buckets) > 0 {
+		first := &b.buckets[0]
+		drop := minInt(b.total-n, first.n)
+		bytes := first.bytes * int64(drop) / int64(first.n)
+		first.n -= drop
+		first.bytes -= bytes
+		b.total -= drop
+		b.bytes -= bytes
+		if first.n == 0 {
+			b.bytes -= first.bytes
+			b.buckets = b.buckets[1:]
+		}
+	}
+}
+
+func (b *backlog) oldest() time.Time {
+	if len(b.buckets) == 0 {
+		return time.Time{}
+	}
+	return b.buckets[0].time
+}
+
+// queueAges tracks the backlog of every priority of a queue, each level is ejected oldest first
+// while the highest priorities go first.
+type queueAges struct {
+	levels map[ballistic.Priority]*backlog
+}
+
+func (a *queueAges) pushed(now time.Time, priority ballistic.Priority, n int, bytes int64) {
+	if a.levels == nil {
+		a.levels = map[ballistic.Priority]*backlog{}
+	}
+	b, ok := a.levels[priority]
+	if !ok {
+		b = &backlog{}
+		a.levels[priority] = b
+	}
+	b.pushed(now, n, bytes)
+}
+
+// trim trims the levels down to the lengths of the priorities of the queue.
+func (a *queueAges) trim(lens map[ballistic.Priority]int) {
+	for priority, b := range a.levels {
+		b.trim(lens[priority])
+	}
+}
+
+func (a *queueAges) oldest() time.Time {
+	var oldest time.Time
+	for _, b := range a.levels {
+		if t := b.oldest(); !t.IsZero() && (oldest.IsZero() || t.Before(oldest)) {
+			oldest = t
+		}
+	}
+	return oldest
+}
+
+func (a *queueAges) bytes() int64 {
+	var bytes int64
+	for _, b := range a.levels {
+		bytes += b.bytes
+	}
+	return bytes
 }
 
-func (p *Pool) getQueue(model ballistic.DataModel) (ballistic.Queue, error) {
+// levelsOf returns the number of records of every priority of the queue,
+// a queue without priorities holds PriorityNormal records.
+func levelsOf[T any](queue ballistic.TypedQueue[T]) map[ballistic.Priority]int {
+	if e, ok := queue.(evictable[T]); ok {
+		return e.Levels()
+	}
+	return map[ballistic.Priority]int{ballistic.PriorityNormal: queue.Len()}
+}
+
+// priorityIn returns the priority the model is kept with in the queue.
+func priorityIn[T ballistic.DataModel](queue ballistic.TypedQueue[T], model T) ballistic.Priority {
+	if _, ok := queue.(evictable[T]); ok {
+		return ballistic.PriorityOf(model)
+	}
+	return ballistic.PriorityNormal
+}
+
+// SetSizer sets the function measuring the models pushed, for the Bytes of the backlog.
+func (p *TypedPool[T]) SetSizer(size func(model T) int) {
+	p.ofsMx.Lock()
+	defer p.ofsMx.Unlock()
+	p.size = size
+}
+
+// SetStrategy sets the strategy sharing the eject limit between the queues, RoundRobin by default.
+func (p *TypedPool[T]) SetStrategy(strategy Strategy) {
+	p.ofsMx.Lock()
+	defer p.ofsMx.Unlock()
+	p.strategy = strategy
+}
+
+// QueueStats describes the backlog of a single query.
+// Oldest is the push time of the oldest pending record, approximate to ageResolution,
+// records of a restored queue count as pushed when it was opened. It is zero if the queue is empty.
+type QueueStats struct {
+	Len    int       `json:"len"`
+	Oldest time.Time `json:"oldest"`
+	// Bytes is the size of the pending records measured by the sizer of the pool,
+	// records of a restored queue are not measured.
+	Bytes int64 `json:"bytes,omitempty"`
+	// CompressionRatio is reported by queues compressing their records.
+	CompressionRatio float64 `json:"compression_ratio,omitempty"`
+}
+
+type compressed interface {
+	CompressionRatio() float64
+}
+
+// compressionRatio returns the compression ratio of the queue, 0 if it doesn't compress.
+func compressionRatio(queue interface{}) float64 {
+	if a, ok := queue.(ballistic.TypedAdapter[ballistic.DataModel]); ok {
+		queue = a.Queue
+	}
+	if c, ok := queue.(compressed); ok {
+		return c.CompressionRatio()
+	}
+	return 0
+}
+
+// Stats returns the backlog of every open queue keyed by SQL.
+func (p *TypedPool[T]) Stats() map[string]QueueStats {
+	p.ofsMx.Lock()
+	defer p.ofsMx.Unlock()
+
+	stats := make(map[string]QueueStats, len(p.openQueue))
+	for query, queue := range p.openQueue {
+		stats[query] = QueueStats{
+			Len:              queue.Len(),
+			Oldest:           p.ages[query].oldest(),
+			Bytes:            p.ages[query].bytes(),
+			CompressionRatio: compressionRatio(queue),
+		}
+	}
+	return stats
+}
+
+// Backlog returns the backlog of the query.
+func (p *TypedPool[T]) Backlog(query string) QueueStats {
+	p.ofsMx.Lock()
+	defer p.ofsMx.Unlock()
+
+	queue, ok := p.openQueue[query]
+	if !ok {
+		return QueueStats{}
+	}
+	return QueueStats{
+		Len:              queue.Len(),
+		Oldest:           p.ages[query].oldest(),
+		Bytes:            p.ages[query].bytes(),
+		CompressionRatio: compressionRatio(queue),
+	}
+}
+
+func (p *TypedPool[T]) getQueue(model T) (ballistic.TypedQueue[T], error) {
 	var err error
 	queue, isInit := p.openQueue[model.SQL()]
 	if !isInit {
@@ -30,49 +245,203 @@ func (p *Pool) getQueue(model ballistic.DataModel) (ballistic.Queue, error) {
 		}
 
 		p.openQueue[model.SQL()] = queue
+		p.ages[model.SQL()] = &queueAges{}
+		for priority, n := range levelsOf(queue) {
+			if n > 0 {
+				p.ages[model.SQL()].pushed(p.now(), priority, n, 0)
+			}
+		}
 	}
 
 	return queue, nil
 }
 
-func (p *Pool) Append(models []ballistic.DataModel) error {
+// Open opens the queue of the model without pushing to it.
+func (p *TypedPool[T]) Open(model T) error {
+	p.ofsMx.Lock()
+	defer p.ofsMx.Unlock()
+
+	_, err := p.getQueue(model)
+	return err
+}
+
+func (p *TypedPool[T]) push(model T) error {
+	queue, err := p.getQueue(model)
+	if err != nil {
+		return err
+	}
+
+	err = queue.Push(model)
+	if err != nil {
+		return err
+	}
+
+	var size int
+	if p.size != nil {
+		size = p.size(model)
+	}
+	p.ages[model.SQL()].pushed(p.now(), priorityIn(queue, model), 1, int64(size))
+
+	return nil
+}
+
+func (p *TypedPool[T]) Append(models []T) error {
 	p.ofsMx.Lock()
 	defer p.ofsMx.Unlock()
 
 	for _, model := range models {
-		queue, err := p.getQueue(model)
+		err := p.push(model)
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
 
-		err = queue.Push(model)
+// PushAll pushes the models with the pool locked once, the models of a query at once.
+// The errors are those of the models not pushed by index, nil if all were pushed.
+func (p *TypedPool[T]) PushAll(models []T) (errs []error) {
+	p.ofsMx.Lock()
+	defer p.ofsMx.Unlock()
+
+	return pushGrouped(models, func(model T) string {
+		return model.SQL()
+	}, func(query string, group []T) []error {
+		queue, err := p.getQueue(group[0])
 		if err != nil {
+			return repeatError(err, len(group))
+		}
+
+		errs := ballistic.PushAll(queue, group)
+		type pushed struct{ n, size int }
+		levels := map[ballistic.Priority]*pushed{}
+		for i, model := range group {
+			if errs != nil && errs[i] != nil {
+				continue
+			}
+			priority := priorityIn(queue, model)
+			if levels[priority] == nil {
+				levels[priority] = &pushed{}
+			}
+			levels[priority].n++
+			if p.size != nil {
+				levels[priority].size += p.size(model)
+			}
+		}
+		for priority, l := range levels {
+			p.ages[query].pushed(p.now(), priority, l.n, int64(l.size))
+		}
+		return errs
+	})
+}
+
+// Sync syncs the queue of the query, see ballistic.Syncer. The pool is not locked
+// during the sync, the records pushed meanwhile may be synced or not.
+func (p *TypedPool[T]) Sync(query string) error {
+	syncers, err := p.syncers(query)
+	if err != nil {
+		return err
+	}
+	for _, s := range syncers {
+		if err := s.Sync(); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-func (p *Pool) Push(model ballistic.DataModel) (err error) {
+func (p *TypedPool[T]) syncers(query string) ([]ballistic.Syncer, error) {
 	p.ofsMx.Lock()
 	defer p.ofsMx.Unlock()
 
-	queue, err := p.getQueue(model)
-	if err != nil {
-		return err
+	queue, ok := p.openQueue[query]
+	if !ok {
+		return nil, nil
+	}
+	if q, ok := queue.(interface {
+		syncers() ([]ballistic.Syncer, error)
+	}); ok {
+		return q.syncers()
 	}
+	if s, ok := queue.(ballistic.Syncer); ok {
+		return []ballistic.Syncer{s}, nil
+	}
+	return nil, ballistic.ErrNoSync
+}
 
-	return queue.Push(model)
+// pushGrouped pushes the models grouped by key in the order the keys come first,
+// the errors of the groups are merged by the index of the models.
+func pushGrouped[T any, K comparable](models []T, keyOf func(model T) K, push func(key K, group []T) []error) (errs []error) {
+	var keys []K
+	index := map[K][]int{}
+	for i, model := range models {
+		key := keyOf(model)
+		if _, ok := index[key]; !ok {
+			keys = append(keys, key)
+		}
+		index[key] = append(index[key], i)
+	}
+
+	for _, key := range keys {
+		group := make([]T, len(index[key]))
+		for k, i := range index[key] {
+			group[k] = models[i]
+		}
+		for k, err := range push(key, group) {
+			if err == nil {
+				continue
+			}
+			if errs == nil {
+				errs = make([]error, len(models))
+			}
+			errs[index[key][k]] = err
+		}
+	}
+	return errs
 }
 
-func (p *Pool) Eject(limit int) (models []ballistic.DataModel, err error) {
+func repeatError(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (p *TypedPool[T]) Push(model T) (err error) {
+	p.ofsMx.Lock()
+	defer p.ofsMx.Unlock()
+
+	return p.push(model)
+}
+
+func (p *TypedPool[T]) Eject(limit int) (models []T, err error) {
+	return p.EjectWhere(limit, nil)
+}
+
+// EjectQuery ejects up to limit records of the query.
+func (p *TypedPool[T]) EjectQuery(query string, limit int) (models []T, err error) {
+	return p.EjectWhere(limit, func(q string) bool {
+		return q == query
+	})
+}
+
+// EjectWhere ejects up to limit records of the queries matching, a nil match matches all.
+// The match is called with the pool locked. A queue failing doesn't stop the others,
+// the records ejected are returned with the first error.
+func (p *TypedPool[T]) EjectWhere(limit int, match func(query string) bool) (models []T, err error) {
 	p.ofsMx.Lock()
 	defer p.ofsMx.Unlock()
 
+	// Visit the queues in a stable order, the strategy decides who goes first
+	queries := make([]string, 0, len(p.openQueue))
 	maxLimit := 0
-	for _, queue := range p.openQueue {
-		maxLimit += queue.Len()
+	for query, queue := range p.openQueue {
+		if match == nil || match(query) {
+			queries = append(queries, query)
+			maxLimit += queue.Len()
+		}
 	}
 
 	if limit > maxLimit {
@@ -87,22 +456,33 @@ func (p *Pool) Eject(limit int) (models []ballistic.DataModel, err error) {
 		return nil, nil
 	}
 
-	models = make([]ballistic.DataModel, 0, limit)
-	for _, queue := range p.openQueue {
-		ejectModels, err := queue.Eject(limit - len(models))
-		if err != nil {
-			return nil, err
+	sort.Strings(queries)
+
+	states := make([]QueueState, len(queries))
+	for i, query := range queries {
+		states[i] = QueueState{
+			SQL:    query,
+			Len:    p.openQueue[query].Len(),
+			Oldest: p.ages[query].oldest(),
 		}
+	}
+	shares := p.strategy.Share(p.round, limit, states)
+	p.round++
 
-		for _, em := range ejectModels {
-			if em != nil {
-				models = append(models, em.(ballistic.DataModel))
-			}
+	models = make([]T, 0, limit)
+	for i, query := range queries {
+		if shares[i] <= 0 {
+			continue
 		}
 
-		if len(models) >= limit {
-			return models, nil
+		queue := p.openQueue[query]
+		ejectModels, ejectErr := queue.Eject(minInt(shares[i], limit-len(models)))
+		p.ages[query].trim(levelsOf(queue))
+		if ejectErr != nil && err == nil {
+			err = ejectErr
 		}
+
+		models = append(models, ejectModels...)
 	}
-	return models, nil
+	return models, err
 }
@@ -0,0 +1,116 @@
+package sender
+
+import (
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type poolModel struct {
+	query string
+	n     int
+}
+
+func (m *poolModel) SQL() string                       { return m.query }
+func (m *poolModel) ToExec() []interface{}             { return []interface{}{m.n} }
+func (m *poolModel) MarshalBinary() ([]byte, error)    { return nil, nil }
+func (m *poolModel) UnmarshalBinary(data []byte) error { return nil }
+
+func TestTypedPool(t *testing.T) {
+	p := NewTypedPool(func(model *poolModel) (ballistic.TypedQueue[*poolModel], error) {
+		return memory.NewTypedQueue[*poolModel](), nil
+	})
+
+	require.NoError(t, p.Append([]*poolModel{{query: "a", n: 1}, {query: "b", n: 2}}))
+	require.NoError(t, p.Push(&poolModel{query: "a", n: 3}))
+	assert.Equal(t, 2, p.Stats()["a"].Len)
+
+	models, err := p.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 3)
+	sum := 0
+	for _, m := range models {
+		sum += m.n
+	}
+	assert.Equal(t, 6, sum)
+
+	// The untyped pool adapts untyped queues
+	pool := NewPool(func(_ ballistic.DataModel) (ballistic.Queue, error) {
+		return memory.NewQueue(), nil
+	})
+	var _ ballistic.Pool = pool
+	require.NoError(t, pool.Push(&poolModel{query: "a", n: 1}))
+	ejected, err := pool.Eject(1)
+	require.NoError(t, err)
+	assert.Equal(t, []ballistic.DataModel{&poolModel{query: "a", n: 1}}, ejected)
+}
+
+func TestEjectQuery(t *testing.T) {
+	p := NewTypedPool(func(model *testModel) (ballistic.TypedQueue[*testModel], error) {
+		return memory.NewTypedQueue[*testModel](), nil
+	})
+
+	require.NoError(t, p.Append([]*testModel{{Q: "a", N: 1}, {Q: "b", N: 2}}))
+	require.NoError(t, p.Push(&testModel{Q: "a", N: 3}))
+	assert.Equal(t, 2, p.Backlog("a").Len)
+
+	models, err := p.EjectQuery("a", -1)
+	require.NoError(t, err)
+	assert.Equal(t, []*testModel{{Q: "a", N: 1}, {Q: "a", N: 3}}, models)
+
+	models, err = p.Eject(-1)
+	require.NoError(t, err)
+	assert.Equal(t, []*testModel{{Q: "b", N: 2}}, models)
+}
+
+func TestBacklog(t *testing.T) {
+	var b backlog
+	now := time.Unix(0, 0)
+	b.pushed(now, 2, 20)
+	b.pushed(now.Add(100*time.Millisecond), 2, 40)
+	b.pushed(now.Add(2*time.Second), 1, 5)
+	assert.Equal(t, int64(65), b.bytes)
+	assert.Len(t, b.buckets, 2)
+
+	b.trim(3)
+	assert.Equal(t, 3, b.total)
+	assert.Equal(t, int64(35), b.bytes)
+	assert.Equal(t, now, b.oldest())
+
+	b.trim(1)
+	assert.Equal(t, int64(5), b.bytes)
+	assert.Equal(t, now.Add(2*time.Second), b.oldest())
+
+	b.trim(0)
+	assert.Zero(t, b.bytes)
+	assert.True(t, b.oldest().IsZero())
+}
+
+// brokenQueue returns its records with an error, like a file queue quarantining a record.
+type brokenQueue struct {
+	*memory.TypedQueue[*testModel]
+}
+
+func (q brokenQueue) Eject(limit int) ([]*testModel, error) {
+	models, _ := q.TypedQueue.Eject(limit)
+	return models, assert.AnError
+}
+
+func TestTypedPoolEjectError(t *testing.T) {
+	p := NewTypedPool(func(model *testModel) (ballistic.TypedQueue[*testModel], error) {
+		if model.Q == "a" {
+			return brokenQueue{memory.NewTypedQueue[*testModel]()}, nil
+		}
+		return memory.NewTypedQueue[*testModel](), nil
+	})
+	require.NoError(t, p.Append([]*testModel{{Q: "a", N: 1}, {Q: "b", N: 2}}))
+
+	// The records ejected next to the error aren't dropped, the other queues are ejected too
+	models, err := p.Eject(-1)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ElementsMatch(t, []*testModel{{Q: "a", N: 1}, {Q: "b", N: 2}}, models)
+	assert.Zero(t, p.Backlog("a").Len)
+}
@@ -0,0 +1,256 @@
+package sender
+
+import (
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenLevelFunc opens the queue of the records of one priority.
+type OpenLevelFunc[T ballistic.DataModel] func(priority ballistic.Priority) (ballistic.TypedQueue[T], error)
+
+// PriorityQueue keeps a queue per priority of the records of one query,
+// higher priorities are ejected first and lower ones evicted first.
+type PriorityQueue[T ballistic.DataModel] struct {
+	open   OpenLevelFunc[T]
+	levels map[ballistic.Priority]ballistic.TypedQueue[T]
+	// order holds the priorities of the levels, highest first.
+	order []ballistic.Priority
+}
+
+// NewPriorityQueue opens the queues of PriorityNormal and of the priorities given,
+// the others are opened on the first push.
+func NewPriorityQueue[T ballistic.DataModel](open OpenLevelFunc[T], priorities ...ballistic.Priority) (*PriorityQueue[T], error) {
+	q := &PriorityQueue[T]{
+		open:   open,
+		levels: map[ballistic.Priority]ballistic.TypedQueue[T]{},
+	}
+	for _, priority := range append([]ballistic.Priority{ballistic.PriorityNormal}, priorities...) {
+		if _, err := q.level(priority); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func (q *PriorityQueue[T]) level(priority ballistic.Priority) (ballistic.TypedQueue[T], error) {
+	queue, ok := q.levels[priority]
+	if ok {
+		return queue, nil
+	}
+
+	queue, err := q.open(priority)
+	if err != nil {
+		return nil, err
+	}
+	q.levels[priority] = queue
+	q.order = append(q.order, priority)
+	sort.Slice(q.order, func(i, j int) bool {
+		return q.order[i] > q.order[j]
+	})
+	return queue, nil
+}
+
+func (q *PriorityQueue[T]) Push(model T) error {
+	queue, err := q.level(ballistic.PriorityOf(model))
+	if err != nil {
+		return err
+	}
+	return queue.Push(model)
+}
+
+// PushAll pushes the models of every priority at once, see ballistic.BatchQueue.
+func (q *PriorityQueue[T]) PushAll(models []T) (errs []error) {
+	return pushGrouped(models, func(model T) ballistic.Priority {
+		return ballistic.PriorityOf(model)
+	}, func(priority ballistic.Priority, group []T) []error {
+		queue, err := q.level(priority)
+		if err != nil {
+			return repeatError(err, len(group))
+		}
+		return ballistic.PushAll(queue, group)
+	})
+}
+
+// Eject ejects up to limit records, the highest priorities first.
+func (q *PriorityQueue[T]) Eject(limit int) (models []T, err error) {
+	for _, priority := range q.order {
+		if limit >= 0 && len(models) >= limit {
+			break
+		}
+		n := -1
+		if limit >= 0 {
+			n = limit - len(models)
+		}
+
+		ejectModels, err := q.levels[priority].Eject(n)
+		models = append(models, ejectModels...)
+		if err != nil {
+			return models, err
+		}
+	}
+	return models, nil
+}
+
+// Sync syncs the queues of all the priorities, see ballistic.Syncer.
+func (q *PriorityQueue[T]) Sync() error {
+	syncers, err := q.syncers()
+	if err != nil {
+		return err
+	}
+	for _, s := range syncers {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncers returns the queues of the priorities, it fails if one is not a ballistic.Syncer.
+func (q *PriorityQueue[T]) syncers() ([]ballistic.Syncer, error) {
+	syncers := make([]ballistic.Syncer, 0, len(q.order))
+	for _, priority := range q.order {
+		s, ok := q.levels[priority].(ballistic.Syncer)
+		if !ok {
+			return nil, ballistic.ErrNoSync
+		}
+		syncers = append(syncers, s)
+	}
+	return syncers, nil
+}
+
+func (q *PriorityQueue[T]) Len() int {
+	n := 0
+	for _, queue := range q.levels {
+		n += queue.Len()
+	}
+	return n
+}
+
+// Levels returns the number of records of every priority.
+func (q *PriorityQueue[T]) Levels() map[ballistic.Priority]int {
+	levels := make(map[ballistic.Priority]int, len(q.levels))
+	for priority, queue := range q.levels {
+		levels[priority] = queue.Len()
+	}
+	return levels
+}
+
+// Evict drops up to n of the oldest records of the priority.
+func (q *PriorityQueue[T]) Evict(priority ballistic.Priority, n int) ([]T, error) {
+	queue, ok := q.levels[priority]
+	if !ok || n <= 0 {
+		return nil, nil
+	}
+	return queue.Eject(n)
+}
+
+// CompressionRatio returns the compression ratio of the levels weighted by their records.
+func (q *PriorityQueue[T]) CompressionRatio() float64 {
+	var ratio float64
+	n := 0
+	for _, queue := range q.levels {
+		ratio += compressionRatio(queue) * float64(queue.Len())
+		n += queue.Len()
+	}
+	if n == 0 {
+		return compressionRatio(q.levels[ballistic.PriorityNormal])
+	}
+	return ratio / float64(n)
+}
+
+// evictable is a queue of records of several priorities.
+type evictable[T any] interface {
+	Levels() map[ballistic.Priority]int
+	Evict(priority ballistic.Priority, n int) ([]T, error)
+}
+
+// Evict drops n records of all the queues, the lowest priorities first
+// and the oldest records of a priority first. Queues without priorities hold PriorityNormal records.
+func (p *TypedPool[T]) Evict(n int) (models []T, err error) {
+	p.ofsMx.Lock()
+	defer p.ofsMx.Unlock()
+
+	levels := map[ballistic.Priority]map[string]int{}
+	for query, queue := range p.openQueue {
+		for priority, count := range levelsOf(queue) {
+			if count == 0 {
+				continue
+			}
+			if levels[priority] == nil {
+				levels[priority] = map[string]int{}
+			}
+			levels[priority][query] = count
+		}
+	}
+
+	priorities := make([]ballistic.Priority, 0, len(levels))
+	for priority := range levels {
+		priorities = append(priorities, priority)
+	}
+	sort.Slice(priorities, func(i, j int) bool {
+		return priorities[i] < priorities[j]
+	})
+
+	for _, priority := range priorities {
+		queries := make([]string, 0, len(levels[priority]))
+		for query := range levels[priority] {
+			queries = append(queries, query)
+		}
+		sort.Strings(queries)
+
+		for _, query := range queries {
+			if len(models) >= n {
+				return models, nil
+			}
+
+			queue := p.openQueue[query]
+			limit := minInt(n-len(models), levels[priority][query])
+			var evicted []T
+			if e, ok := queue.(evictable[T]); ok {
+				evicted, err = e.Evict(priority, limit)
+			} else {
+				evicted, err = queue.Eject(limit)
+			}
+			p.ages[query].trim(levelsOf(queue))
+			models = append(models, evicted...)
+			if err != nil {
+				return models, err
+			}
+		}
+	}
+	return models, nil
+}
+
+// prioritySuffix returns the suffix of the queue file name of the priority.
+func prioritySuffix(priority ballistic.Priority) string {
+	if priority == ballistic.PriorityNormal {
+		return ""
+	}
+	return fmt.Sprintf("-p%d", priority)
+}
+
+// priorities returns the priorities with a queue file of the query in the workspace.
+func priorities(workspace, query string) []ballistic.Priority {
+	paths, err := file.List(workspace)
+	if err != nil {
+		return nil
+	}
+
+	prefix := file.QueueName(query) + "-p"
+	var found []ballistic.Priority
+	for _, path := range paths {
+		name, t, n, err := file.ParseFileName(filepath.Base(path))
+		if err != nil || t != "bd" || n != 0 || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if priority, err := strconv.Atoi(name[len(prefix):]); err == nil {
+			found = append(found, ballistic.Priority(priority))
+		}
+	}
+	return found
+}
@@ -0,0 +1,152 @@
+package sender
+
+import (
+	"encoding/json"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"github.com/farwydi/ballistic/queue/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type priorityModel struct {
+	testModel
+	P ballistic.Priority
+}
+
+func (m *priorityModel) Priority() ballistic.Priority {
+	return m.P
+}
+
+func (m priorityModel) MarshalBinary() (data []byte, err error) {
+	return json.Marshal(m)
+}
+
+func (m *priorityModel) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, m)
+}
+
+func numbers(models []ballistic.DataModel) []int {
+	n := make([]int, len(models))
+	for i, model := range models {
+		n[i] = model.(*priorityModel).N
+	}
+	return n
+}
+
+func TestPriorityQueue(t *testing.T) {
+	pool := NewTypedPool(func(ballistic.DataModel) (ballistic.TypedQueue[ballistic.DataModel], error) {
+		return NewPriorityQueue(func(ballistic.Priority) (ballistic.TypedQueue[ballistic.DataModel], error) {
+			return ballistic.TypedAdapter[ballistic.DataModel]{Queue: memory.NewQueue()}, nil
+		})
+	})
+
+	push := func(q string, n int, p ballistic.Priority) {
+		require.NoError(t, pool.Push(&priorityModel{testModel: testModel{Q: q, N: n}, P: p}))
+	}
+	push("a", 1, ballistic.PriorityLow)
+	push("a", 2, ballistic.PriorityNormal)
+	push("a", 3, ballistic.PriorityHigh)
+	push("a", 4, ballistic.PriorityLow)
+	push("b", 5, ballistic.PriorityLow)
+	push("b", 6, ballistic.PriorityHigh)
+	assert.Equal(t, 4, pool.Backlog("a").Len)
+
+	// Eviction drops the lowest priority of all queries first, the oldest first
+	evicted, err := pool.Evict(4)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 4, 5, 2}, numbers(evicted))
+	assert.Equal(t, 1, pool.Backlog("a").Len)
+
+	push("a", 7, ballistic.PriorityNormal)
+	push("a", 8, ballistic.PriorityHigh)
+
+	// A query is drained high before low
+	models, err := pool.EjectQuery("a", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 8}, numbers(models))
+	models, err = pool.Eject(-1)
+	require.NoError(t, err)
+	assert.Equal(t, []int{7, 6}, numbers(models))
+}
+
+func TestPriorityBacklog(t *testing.T) {
+	pool := NewTypedPool(func(ballistic.DataModel) (ballistic.TypedQueue[ballistic.DataModel], error) {
+		return NewPriorityQueue(func(ballistic.Priority) (ballistic.TypedQueue[ballistic.DataModel], error) {
+			return ballistic.TypedAdapter[ballistic.DataModel]{Queue: memory.NewQueue()}, nil
+		})
+	})
+	pool.SetSizer(func(ballistic.DataModel) int { return 10 })
+	clock := time.Unix(0, 0)
+	pool.now = func() time.Time { return clock }
+
+	require.NoError(t, pool.Push(&priorityModel{testModel: testModel{Q: "a", N: 1}, P: ballistic.PriorityLow}))
+	clock = clock.Add(time.Minute)
+	assert.Nil(t, pool.PushAll([]ballistic.DataModel{
+		&priorityModel{testModel: testModel{Q: "a", N: 2}, P: ballistic.PriorityHigh},
+		&priorityModel{testModel: testModel{Q: "a", N: 3}, P: ballistic.PriorityHigh},
+	}))
+
+	// The newer high priority records go first, the age of the low priority one stays
+	models, err := pool.EjectQuery("a", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, numbers(models))
+	assert.Equal(t, QueueStats{Len: 1, Oldest: time.Unix(0, 0), Bytes: 10}, pool.Backlog("a"))
+
+	_, err = pool.EjectQuery("a", -1)
+	require.NoError(t, err)
+	assert.Equal(t, QueueStats{}, pool.Backlog("a"))
+}
+
+func TestPriorityFiles(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("a", 1, func() ballistic.DataModel {
+		return &priorityModel{testModel: testModel{Q: "a"}}
+	}))
+	cfg := Config{FileWorkspace: tempDir, Registry: registry}
+
+	s := NewSender(nil, cfg)
+	for i, p := range []ballistic.Priority{ballistic.PriorityLow, ballistic.PriorityNormal, ballistic.PriorityHigh} {
+		require.NoError(t, s.filePool.Push(&priorityModel{testModel: testModel{Q: "a", N: i}, P: p}))
+	}
+	for _, suffix := range []string{"", "-p1", "-p-1"} {
+		assert.FileExists(t, filepath.Join(tempDir, file.QueueName("a")+suffix+"_0.bd"))
+	}
+
+	// The queues of every priority are restored
+	s = NewSender(nil, cfg)
+	assert.Equal(t, 3, s.filePool.Backlog("a").Len)
+	models, err := s.filePool.Eject(-1)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1, 0}, numbers(models))
+}
+
+func TestMemoryQuota(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	s := NewSender(nil, Config{FileWorkspace: tempDir, MemoryQuota: 3})
+	for i, p := range []ballistic.Priority{ballistic.PriorityHigh, ballistic.PriorityLow, ballistic.PriorityNormal, ballistic.PriorityHigh, ballistic.PriorityLow} {
+		require.NoError(t, s.memoryPool.Push(&priorityModel{testModel: testModel{Q: "a", N: i}, P: p}))
+		s.evict()
+	}
+
+	assert.Equal(t, 3, s.memoryPool.Backlog("a").Len)
+	models, err := s.memoryPool.Eject(-1)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 3, 2}, numbers(models))
+}
@@ -0,0 +1,227 @@
+package sender
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReplayConfig defines the config for Replay.
+type ReplayConfig struct {
+	Logger   Logger
+	Registry *ballistic.Registry
+	// Model decodes every file with this registered model,
+	// otherwise the model is matched by the file name.
+	Model string
+	// BatchSize is the number of records published in one transaction.
+	BatchSize int
+	// RowsPerSecond limits the publishing rate, 0 is unlimited.
+	RowsPerSecond int
+	// Keys decrypts encrypted files.
+	Keys file.KeyProvider
+	// DryRun decodes the records without publishing them.
+	DryRun   bool
+	Progress func(p ReplayProgress)
+}
+
+// ReplayProgress is reported after every batch and at the end of every file.
+type ReplayProgress struct {
+	File    string
+	Model   string
+	Sent    int
+	Pending int
+	Done    bool
+	// Err is the framing error of a broken file, its complete records are still sent.
+	Err error
+}
+
+// ReplayStats sums up a replay.
+type ReplayStats struct {
+	Files   int
+	Records int
+	Batches int
+}
+
+// Replay publishes the pending records of spool files to the database, using the same transaction
+// as the sender. Paths are workspace directories, their sealed batches included, or single .bd/.carapted files.
+// The dead letters of a workspace are replayed by giving their directory.
+// The files are only read, so replaying a file twice inserts its records twice.
+func Replay(ctx context.Context, connect *sql.DB, paths []string, config ReplayConfig) (ReplayStats, error) {
+	var stats ReplayStats
+
+	if config.Registry == nil {
+		config.Registry = ballistic.DefaultRegistry
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = ConfigDefault.SendLimit
+	}
+	if config.Logger == nil {
+		config.Logger, _ = NewStdLogger()
+	}
+
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return stats, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		list, err := file.List(path)
+		if err != nil {
+			return stats, err
+		}
+		files = append(files, list...)
+
+		// The sealed batches hold records taken out of the spools
+		batches, err := file.List(filepath.Join(path, batchDir))
+		if err != nil && !os.IsNotExist(err) {
+			return stats, err
+		}
+		files = append(files, batches...)
+	}
+
+	r := replayer{
+		connect: connect,
+		cfg:     config,
+		start:   time.Now(),
+	}
+	for _, path := range files {
+		err := r.replayFile(ctx, path, &stats)
+		if err != nil {
+			return stats, fmt.Errorf("%s: %w", path, err)
+		}
+		stats.Files++
+	}
+
+	return stats, nil
+}
+
+type replayer struct {
+	connect *sql.DB
+	cfg     ReplayConfig
+	start   time.Time
+	sent    int
+}
+
+// keys returns the optional key provider of WalkFile.
+func (r *replayer) keys() []file.KeyProvider {
+	if r.cfg.Keys == nil {
+		return nil
+	}
+	return []file.KeyProvider{r.cfg.Keys}
+}
+
+func (r *replayer) replayFile(ctx context.Context, path string, stats *ReplayStats) error {
+	name := r.cfg.Model
+	if name == "" {
+		var err error
+		name, err = file.MatchModel(path, r.cfg.Registry)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Validate the name before reading the file
+	if _, err := r.cfg.Registry.New(name); err != nil {
+		return err
+	}
+
+	info, err := file.WalkFile(path, nil, r.keys()...)
+	if err != nil {
+		return err
+	}
+
+	progress := ReplayProgress{
+		File:    path,
+		Model:   name,
+		Pending: info.Pending,
+	}
+
+	batch := make([]ballistic.DataModel, 0, r.cfg.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := r.publish(ctx, batch); err != nil {
+			return err
+		}
+
+		stats.Batches++
+		stats.Records += len(batch)
+		progress.Sent += len(batch)
+		if r.cfg.Progress != nil {
+			r.cfg.Progress(progress)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	info, err = file.WalkFile(path, func(rec file.Record) error {
+		if rec.Consumed {
+			return nil
+		}
+
+		if rec.Err != nil {
+			return fmt.Errorf("record at %d: %w", rec.Offset, rec.Err)
+		}
+
+		model, err := file.Decode(r.cfg.Registry, name, info.Header, rec)
+		if err != nil {
+			return fmt.Errorf("record at %d: %w", rec.Offset, err)
+		}
+
+		batch = append(batch, model)
+		if len(batch) < r.cfg.BatchSize {
+			return nil
+		}
+		return flush()
+	}, r.keys()...)
+	if err != nil {
+		return err
+	}
+
+	err = flush()
+	if err != nil {
+		return err
+	}
+
+	progress.Done = true
+	progress.Err = info.Err
+	if r.cfg.Progress != nil {
+		r.cfg.Progress(progress)
+	}
+
+	return nil
+}
+
+func (r *replayer) publish(ctx context.Context, batch []ballistic.DataModel) error {
+	if r.cfg.RowsPerSecond > 0 {
+		// Wait until the rows sent so far fit into the rate
+		due := r.start.Add(time.Duration(r.sent) * time.Second / time.Duration(r.cfg.RowsPerSecond))
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	r.sent += len(batch)
+
+	if r.cfg.DryRun {
+		return nil
+	}
+
+	return publish(ctx, r.connect, r.cfg.Logger, batch[0].SQL(), batch)
+}
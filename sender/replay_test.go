@@ -0,0 +1,74 @@
+package sender
+
+import (
+	"context"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type otherModel struct {
+	testModel
+}
+
+func TestReplayDryRun(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	for i := 0; i < 5; i++ {
+		q, err := file.NewQueueByModel(&testModel{Q: "a"}, file.Config{Workspace: tempDir})
+		require.NoError(t, err)
+		defer q.Close()
+		require.NoError(t, q.Push(&testModel{Q: "a", N: i}))
+	}
+	q, err := file.NewQueueByModel(&testModel{Q: "b"}, file.Config{Workspace: tempDir})
+	require.NoError(t, err)
+	defer q.Close()
+	require.NoError(t, q.Push(&testModel{Q: "b", N: 10}))
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("a", 1, func() ballistic.DataModel {
+		return &testModel{Q: "a"}
+	}))
+
+	_, err = Replay(context.Background(), nil, []string{tempDir}, ReplayConfig{
+		Registry: registry,
+		DryRun:   true,
+	})
+	assert.Error(t, err, "queue b has no registered model")
+
+	require.NoError(t, registry.Register("b", 1, func() ballistic.DataModel {
+		return &otherModel{testModel{Q: "b"}}
+	}))
+
+	var done []ReplayProgress
+	start := time.Now()
+	stats, err := Replay(context.Background(), nil, []string{tempDir}, ReplayConfig{
+		Registry:      registry,
+		BatchSize:     2,
+		RowsPerSecond: 100,
+		DryRun:        true,
+		Progress: func(p ReplayProgress) {
+			if p.Done {
+				done = append(done, p)
+			}
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ReplayStats{Files: 2, Records: 6, Batches: 4}, stats)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+
+	require.Len(t, done, 2)
+	for _, p := range done {
+		assert.Equal(t, p.Pending, p.Sent)
+		assert.NoError(t, p.Err)
+	}
+}
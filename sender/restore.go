@@ -0,0 +1,78 @@
+package sender
+
+import (
+	"github.com/farwydi/ballistic/queue/file"
+	"path/filepath"
+	"time"
+)
+
+// restore opens the file queues left in the workspace by a previous run,
+// so their records are sent without waiting for a push of the same query.
+// Spools without a registered model and broken spools are reported,
+// files unknown to the manifest of the workspace are not ours and left alone.
+func (s *Sender) restore() {
+	paths, err := file.ListQueues(s.cfg.FileWorkspace)
+	if err != nil {
+		s.logger.Warnw("problem listing the workspace", "workspace", s.cfg.FileWorkspace, "error", err)
+		return
+	}
+
+	for _, path := range paths {
+		_, t, n, err := file.ParseFileName(filepath.Base(path))
+		if err != nil {
+			continue
+		}
+
+		if t != "bd" || n != 0 {
+			s.logger.Warnw("broken spool in the workspace, recover it with the ballistic tool", "file", path)
+			continue
+		}
+
+		name, err := file.MatchModel(path, s.cfg.Registry)
+		if err != nil {
+			s.logger.Warnw("unknown spool in the workspace", "file", path, "error", err)
+			continue
+		}
+
+		model, err := s.cfg.Registry.New(name)
+		if err != nil {
+			s.logger.Warnw("unknown spool in the workspace", "file", path, "error", err)
+			continue
+		}
+
+		s.route(model)
+		err = s.filePool.Open(model)
+		if err != nil {
+			s.logger.Warnw("problem restoring a spool", "file", path, "model", name, "error", err)
+			continue
+		}
+		s.seedDedup(path)
+
+		if s.cfg.ShowSuccessfulInfo {
+			s.logger.Infow("spool restored", "file", path, "model", name)
+		}
+	}
+}
+
+// seedDedup remembers the keys of the pending records of the spool, they were pushed before the restart.
+func (s *Sender) seedDedup(path string) {
+	if s.dedup == nil {
+		return
+	}
+
+	var keys []file.KeyProvider
+	if s.cfg.FileKeys != nil {
+		keys = append(keys, s.cfg.FileKeys)
+	}
+
+	now := time.Now()
+	_, err := file.WalkFile(path, func(rec file.Record) error {
+		if !rec.Consumed && rec.Err == nil {
+			s.dedup.first(rec.Key, now)
+		}
+		return nil
+	}, keys...)
+	if err != nil {
+		s.logger.Warnw("problem reading the keys of a spool", "file", path, "error", err)
+	}
+}
@@ -0,0 +1,93 @@
+package sender
+
+import (
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type testLogger struct {
+	mx   sync.Mutex
+	warn []string
+}
+
+func (l *testLogger) Infow(string, ...interface{}) {}
+
+func (l *testLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.warn = append(l.warn, fmt.Sprint(append([]interface{}{msg}, keysAndValues...)...))
+}
+
+func (l *testLogger) Errorw(string, ...interface{}) {}
+
+func TestRestoreOnStartup(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	for _, model := range []*testModel{{Q: "a", N: 1}, {Q: "a", N: 2}, {Q: "b", N: 3}} {
+		q, err := file.NewQueueByModel(model, file.Config{Workspace: tempDir})
+		require.NoError(t, err)
+		require.NoError(t, q.Push(model))
+	}
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("a", 1, func() ballistic.DataModel {
+		return &testModel{Q: "a"}
+	}))
+
+	logger := &testLogger{}
+	s := NewSender(nil, Config{
+		Logger:        logger,
+		FileWorkspace: tempDir,
+		Registry:      registry,
+	})
+
+	stats := s.filePool.Stats()
+	assert.Equal(t, 2, stats["a"].Len)
+	assert.Len(t, stats, 1)
+
+	require.Len(t, logger.warn, 1)
+	assert.Contains(t, logger.warn[0], "unknown spool")
+	assert.Contains(t, logger.warn[0], file.QueueName("b"))
+
+	models, err := s.filePool.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 2)
+	assert.Equal(t, 1, models[0].(*testModel).N)
+	assert.Equal(t, 2, models[1].(*testModel).N)
+}
+
+func TestRestoreForeignFiles(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	// Files of another program sharing the workspace
+	foreign := []string{filepath.Join(tempDir, "other_0.bd"), filepath.Join(tempDir, "other_0.carapted")}
+	for _, path := range foreign {
+		require.NoError(t, ioutil.WriteFile(path, []byte("not a spool"), 0644))
+	}
+
+	logger := &testLogger{}
+	s := NewSender(nil, Config{Logger: logger, FileWorkspace: tempDir})
+	assert.Empty(t, s.filePool.Stats())
+	assert.Empty(t, logger.warn)
+	for _, path := range foreign {
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "not a spool", string(data))
+	}
+}
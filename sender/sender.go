@@ -3,14 +3,19 @@ package sender
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/farwydi/ballistic"
 	"github.com/farwydi/ballistic/queue/file"
 	"github.com/farwydi/ballistic/queue/memory"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// ErrShutdown is returned by the pushes to a stopped sender.
+var ErrShutdown = fmt.Errorf("sender is shutdown")
+
 func NewSender(connect *sql.DB, config ...Config) *Sender {
 	// Set default config
 	cfg := configDefault(config...)
@@ -20,36 +25,143 @@ func NewSender(connect *sql.DB, config ...Config) *Sender {
 		logger, _ = NewStdLogger()
 	}
 
-	return &Sender{
+	fileCfg := file.Config{
+		Workspace:  cfg.FileWorkspace,
+		MaxHistory: 0,
+		Registry:   cfg.Registry,
+		Codec:      cfg.FileCodec,
+		Compressor: cfg.FileCompressor,
+		BlockSize:  cfg.FileBlockSize,
+		Keys:       cfg.FileKeys,
+	}
+
+	s := &Sender{
 		cfg: cfg,
-		filePool: NewPool(
-			func(model ballistic.DataModel) (ballistic.Queue, error) {
-				return file.NewQueueByModel(model, file.Config{
-					Workspace:  cfg.FileWorkspace,
-					MaxHistory: 0,
-				})
-			},
-		),
-		memoryPool: NewPool(func(_ ballistic.DataModel) (ballistic.Queue, error) {
-			return memory.NewQueue(), nil
+		filePool: NewTypedPool(func(model ballistic.DataModel) (ballistic.TypedQueue[ballistic.DataModel], error) {
+			// Records of another priority than normal go to files of their own
+			return NewPriorityQueue(func(priority ballistic.Priority) (ballistic.TypedQueue[ballistic.DataModel], error) {
+				cfg := fileCfg
+				cfg.Suffix = prioritySuffix(priority)
+				queue, err := file.NewQueueByModel(model, cfg)
+				if err != nil {
+					return nil, err
+				}
+				return ballistic.TypedAdapter[ballistic.DataModel]{Queue: queue}, nil
+			}, priorities(cfg.FileWorkspace, model.SQL())...)
+		}),
+		memoryPool: NewTypedPool(func(_ ballistic.DataModel) (ballistic.TypedQueue[ballistic.DataModel], error) {
+			return NewPriorityQueue(func(ballistic.Priority) (ballistic.TypedQueue[ballistic.DataModel], error) {
+				return ballistic.TypedAdapter[ballistic.DataModel]{Queue: memory.NewQueue()}, nil
+			})
 		}),
 		stopSig: make(chan bool),
 		connect: connect,
 		logger:  logger,
+		routes:  map[string]*route{},
+		wakeSig: make(chan struct{}, 1),
+		limiter: newLimiter(cfg.Limit, time.Now()),
+		dedup:   newDedup(cfg.DedupWindow),
+	}
+
+	s.commits = newGroupCommit(s.filePool.Sync)
+
+	s.filePool.SetStrategy(cfg.Strategy)
+	s.memoryPool.SetStrategy(cfg.Strategy)
+	if size := s.sizer(); size != nil {
+		s.filePool.SetSizer(size)
+		s.memoryPool.SetSizer(size)
+	}
+
+	if s.sealsBatches() {
+		var err error
+		s.batches, err = newBatchStore(cfg.FileWorkspace, fileCfg)
+		if err == nil {
+			err = s.batches.load()
+		}
+		if err != nil {
+			s.logger.Warnw("problem restoring the sealed batches", "workspace", cfg.FileWorkspace, "error", err)
+		}
 	}
+
+	s.restore()
+
+	if cfg.Async.Size > 0 {
+		s.ingress = make(chan asyncRecord, cfg.Async.Size)
+		s.writerDone = make(chan struct{})
+		go s.runWriter()
+	}
+
+	return s
 }
 
+// Sender spools the pushed models to the file queues of the workspace and sends them in batches.
+//
+// The records are sent in no particular order unless their destination is Ordered. The records
+// of one priority of an ordered destination commit in the order their pushes returned:
+//   - a failed batch is sealed and retried as is before any newer record of the query,
+//     the batches formed after it are held behind it, also across restarts;
+//   - once a record of the query falls back to memory the newer ones follow it there
+//     until the memory is drained, and the file records are sent first;
+//   - the catch-up leaves the destination alone, a single batch of it is in flight;
+//   - with Async the order is the one of the ring, PushDurable writes at once and may overtake
+//     the records still in the ring, PushAsync and Future.WaitDurable keep them in order.
+//
+// A batch dead-lettered after MaxBatchAttempts is skipped, and higher priorities overtake lower ones.
 type Sender struct {
 	cfg Config
 
 	logger Logger
 
-	filePool   ballistic.Pool
-	memoryPool ballistic.Pool
+	filePool   *Pool
+	memoryPool *Pool
 
 	isShutdown int32
-	stopSig    chan bool
-	connect    *sql.DB
+	isRunning  int32
+	// isCatchingUp is set while the pusher drains the backlog, see CatchUp.
+	isCatchingUp int32
+	stopSig      chan bool
+	connect      *sql.DB
+
+	// routes holds the destination of every query pushed, nil for the queries sent within SendLimit.
+	routesMx   sync.Mutex
+	routes     map[string]*route
+	nextShared time.Time
+	// wakeSig wakes the pusher to send a full backlog before the tick.
+	wakeSig chan struct{}
+	// limiter is the rate of all the records sent, nil if unlimited.
+	limiter *limiter
+	// batches keeps the sealed batches until committed, nil unless SealBatches.
+	batches *batchStore
+	// dedup drops the records pushed again within DedupWindow, nil if disabled.
+	dedup      *dedup
+	duplicates int64
+	// commits syncs the file queues of the durable pushes.
+	commits *groupCommit
+
+	// ingress is the ring of the records pushed asynchronously, nil unless Async.
+	ingress       chan asyncRecord
+	ingressMx     sync.RWMutex
+	ingressClosed bool
+	writerDone    chan struct{}
+	dropped       int64
+
+	stateMx       sync.Mutex
+	lastPublish   time.Time
+	lastError     error
+	lastErrorTime time.Time
+}
+
+func (s *Sender) published() {
+	s.stateMx.Lock()
+	s.lastPublish = time.Now()
+	s.stateMx.Unlock()
+}
+
+func (s *Sender) failed(err error) {
+	s.stateMx.Lock()
+	s.lastError = err
+	s.lastErrorTime = time.Now()
+	s.stateMx.Unlock()
 }
 
 func (s *Sender) Stop(sendTail bool) {
@@ -62,28 +174,182 @@ func (s *Sender) Stop(sendTail bool) {
 	<-s.stopSig
 }
 
+// Push pushes the model to the file pool, or to the ring of the writer with Async.
+// A model the file queue rejects, too large or failing to marshal, doesn't fall back to memory.
 func (s *Sender) Push(model ballistic.DataModel) error {
 	if atomic.LoadInt32(&s.isShutdown) == 1 {
-		return fmt.Errorf("sender is shutdown")
+		return ErrShutdown
+	}
+	if s.ingress != nil {
+		return s.enqueue(context.Background(), asyncRecord{model: model})
+	}
+
+	key := ballistic.KeyOf(model)
+	if !s.dedup.first(key, time.Now()) {
+		atomic.AddInt64(&s.duplicates, 1)
+		return nil
+	}
+
+	r := s.route(model)
+	defer s.flushFull(model.SQL())
+
+	// Records of an ordered destination stay behind the ones it keeps in memory
+	if r != nil && r.Ordered && s.memoryPool.Backlog(model.SQL()).Len > 0 {
+		_ = s.memoryPool.Push(model)
+		s.dedup.keep(key)
+		s.evict()
+		return nil
 	}
 
 	err := s.filePool.Push(model)
 	if err != nil {
-		if s.cfg.UseMemoryFallback {
+		var recordErr *file.RecordError
+		if s.cfg.UseMemoryFallback && !errors.As(err, &recordErr) {
 			s.logger.Warnw("writing to disk failed", "error", err)
 
 			// the memory queue does not return an error
 			_ = s.memoryPool.Push(model)
+			s.dedup.keep(key)
+			s.evict()
 			return nil
 		}
-		return fmt.Errorf("writing to disk failed: %v", err)
+		s.dedup.forget(key)
+		return fmt.Errorf("writing to disk failed: %w", err)
 	}
 	return nil
 }
 
+// PushValue pushes a row without a binary encoding of its own like Push, the row is encoded
+// by FileCodec, JSON with the Raw codec, see file.Coded. The rows of restored spools are
+// registered with file.CodedFactory of the same codec.
+func (s *Sender) PushValue(row file.Row) error {
+	return s.Push(file.NewCoded(row, s.cfg.FileCodec))
+}
+
+// PushBatch pushes the models like Push with every pool locked once, the models of a query
+// are written at once. The errors are those of the models not pushed by index, nil if all were.
+// A model the file queue rejects, too large or failing to marshal, doesn't fall back to memory.
+// The models are written at once with Async too.
+func (s *Sender) PushBatch(models []ballistic.DataModel) (errs []error) {
+	if atomic.LoadInt32(&s.isShutdown) == 1 {
+		return repeatError(ErrShutdown, len(models))
+	}
+	errs, _ = s.pushBatch(models)
+	return errs
+}
+
+// pushBatch pushes the models like PushBatch, inMemory reports by index the models
+// kept in memory, it is nil if none were.
+func (s *Sender) pushBatch(models []ballistic.DataModel) (errs []error, inMemory []bool) {
+	fail := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(models))
+		}
+		errs[i] = err
+	}
+	toMemoryAt := func(i int) {
+		if inMemory == nil {
+			inMemory = make([]bool, len(models))
+		}
+		inMemory[i] = true
+	}
+
+	var (
+		queries   []string
+		toFile    []ballistic.DataModel
+		fileIndex []int
+		toMemory  []ballistic.DataModel
+		now       = time.Now()
+		sticky    = map[string]bool{}
+	)
+	for i, model := range models {
+		if !s.dedup.first(ballistic.KeyOf(model), now) {
+			atomic.AddInt64(&s.duplicates, 1)
+			continue
+		}
+
+		query := model.SQL()
+		ordered, seen := sticky[query]
+		if !seen {
+			queries = append(queries, query)
+			// Records of an ordered destination stay behind the ones it keeps in memory
+			r := s.route(model)
+			ordered = r != nil && r.Ordered && s.memoryPool.Backlog(query).Len > 0
+			sticky[query] = ordered
+		}
+		if ordered {
+			toMemory = append(toMemory, model)
+			toMemoryAt(i)
+			continue
+		}
+		toFile = append(toFile, model)
+		fileIndex = append(fileIndex, i)
+	}
+
+	var diskErr error
+	for k, err := range s.filePool.PushAll(toFile) {
+		if err == nil {
+			continue
+		}
+
+		var recordErr *file.RecordError
+		if s.cfg.UseMemoryFallback && !errors.As(err, &recordErr) {
+			diskErr = err
+			toMemory = append(toMemory, toFile[k])
+			toMemoryAt(fileIndex[k])
+			continue
+		}
+		s.dedup.forget(ballistic.KeyOf(toFile[k]))
+		fail(fileIndex[k], fmt.Errorf("writing to disk failed: %w", err))
+	}
+
+	if diskErr != nil {
+		s.logger.Warnw("writing to disk failed", "error", diskErr)
+	}
+	if len(toMemory) > 0 {
+		// the memory queue does not return an error
+		_ = s.memoryPool.Append(toMemory)
+		for _, model := range toMemory {
+			s.dedup.keep(ballistic.KeyOf(model))
+		}
+		s.evict()
+	}
+
+	for _, query := range queries {
+		s.flushFull(query)
+	}
+	return errs, inMemory
+}
+
+// flushFull wakes the pusher if the backlog of the query fills a batch.
+func (s *Sender) flushFull(query string) {
+	records, bytes := s.limits(query)
+	if full(s.backlog(query), records, bytes) {
+		s.wake()
+	}
+}
+
+// wake makes the pusher send the full backlogs without waiting for the tick.
+func (s *Sender) wake() {
+	select {
+	case s.wakeSig <- struct{}{}:
+	default:
+	}
+}
+
 func (s *Sender) publish(ctx context.Context, query string, dataModels []ballistic.DataModel) error {
+	if s.cfg.DeduplicationToken {
+		if token := deduplicationToken(dataModels); token != "" {
+			query = withSetting(query, "insert_deduplication_token", token)
+		}
+	}
+	return publish(ctx, s.connect, s.logger, query, dataModels)
+}
+
+// publish inserts the models in one transaction.
+func publish(ctx context.Context, connect *sql.DB, logger Logger, query string, dataModels []ballistic.DataModel) error {
 	panicked := true
-	tx, err := s.connect.BeginTx(ctx, nil)
+	tx, err := connect.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -91,7 +357,7 @@ func (s *Sender) publish(ctx context.Context, query string, dataModels []ballist
 		// Make sure to rollback when panic, Block error or Commit error
 		if panicked || err != nil {
 			if err := tx.Rollback(); err != nil {
-				s.logger.Errorw("problem when rolling back a transaction", "error", err)
+				logger.Errorw("problem when rolling back a transaction", "error", err)
 			}
 		}
 	}()
@@ -127,10 +393,43 @@ func (s *Sender) publish(ctx context.Context, query string, dataModels []ballist
 	return err
 }
 
+// evict drops the records of the memory pool over MemoryQuota, the lowest priorities first.
+func (s *Sender) evict() {
+	if s.cfg.MemoryQuota <= 0 {
+		return
+	}
+
+	n := 0
+	for _, qs := range s.memoryPool.Stats() {
+		n += qs.Len
+	}
+	if n <= s.cfg.MemoryQuota {
+		return
+	}
+
+	evicted, err := s.memoryPool.Evict(n - s.cfg.MemoryQuota)
+	if err != nil {
+		s.logger.Warnw("problem evicting from memory", "error", err)
+	}
+
+	lost := map[ballistic.Priority]int{}
+	for _, dataModel := range evicted {
+		lost[ballistic.PriorityOf(dataModel)]++
+	}
+	for priority, n := range lost {
+		s.logger.Errorw("data lost! memory quota exceeded",
+			"quota", s.cfg.MemoryQuota,
+			"priority", priority,
+			"lost", n,
+		)
+	}
+}
+
 func (s *Sender) fallback(dataModels []ballistic.DataModel, memorySafe bool) {
 	if err := s.filePool.Append(dataModels); err != nil {
 		if memorySafe {
 			_ = s.memoryPool.Append(dataModels)
+			s.evict()
 			s.logger.Warnw("error when fallback a write to disk", "error", err)
 			return
 		}
@@ -142,44 +441,239 @@ func (s *Sender) fallback(dataModels []ballistic.DataModel, memorySafe bool) {
 	}
 }
 
-func (s *Sender) send(ctx context.Context) {
+// send sends the records of the queries whose interval passed, whose backlog fills a batch
+// or whose oldest record waits longer than the max latency. It reports whether a backlog
+// still fills a batch after the sends succeeded, so it is sent right away unless throttled.
+func (s *Sender) send(ctx context.Context, now time.Time, slack time.Duration) (again bool) {
+	// New batches of a query wait for its held ones
+	blocked := s.retrySealed(ctx)
+
+	sharedDue := !now.Add(slack).Before(s.nextShared)
+	backlogs := s.backlogs()
+	for query, b := range backlogs {
+		if s.shared(query) && !blocked[query] {
+			sharedDue = sharedDue || full(b, s.cfg.SendLimit, s.cfg.FlushBytes) || late(b, s.cfg.MaxLatency, now, slack)
+		}
+	}
+
+	if sharedDue {
+		s.nextShared = now.Add(s.cfg.SendInterval)
+		if s.sendShared(ctx, blocked) == nil {
+			for query, b := range s.backlogs() {
+				if s.shared(query) && !blocked[query] && full(b, s.cfg.SendLimit, s.cfg.FlushBytes) {
+					again = again || !s.throttled(now, b, s.cfg.SendLimit, s.limiter)
+				}
+			}
+		}
+	}
+
+	for query, r := range s.scheduled() {
+		b := backlogs[query]
+		if b.Len == 0 || blocked[query] {
+			continue
+		}
+		if full(b, r.BatchSize, r.FlushBytes) || !now.Add(slack).Before(r.next) || late(b, r.MaxLatency, now, slack) {
+			r.next = now.Add(r.Interval)
+			if s.sendRoute(ctx, query, r) == nil {
+				if b := s.backlog(query); full(b, r.BatchSize, r.FlushBytes) {
+					again = again || !s.throttled(now, b, r.BatchSize, s.limiter, r.limiter)
+				}
+			}
+		}
+	}
+	return again
+}
+
+// sendShared sends up to SendLimit records of the queries without a destination, but the blocked ones.
+func (s *Sender) sendShared(ctx context.Context, blocked map[string]bool) error {
+	var err error
+	for query, dataModels := range s.ejectShared(blocked) {
+		if berr := s.sendBatch(ctx, query, dataModels, s.cfg.UseMemoryFallback); berr != nil && err == nil {
+			err = berr
+		}
+	}
+	return err
+}
+
+// ejectShared ejects up to SendLimit records of the queries without a destination
+// the limit allows, memory first. The blocked queries are left out.
+func (s *Sender) ejectShared(blocked map[string]bool) map[string][]ballistic.DataModel {
+	match := func(query string) bool {
+		return s.shared(query) && !blocked[query]
+	}
+
+	var backlog QueueStats
+	for query, b := range s.backlogs() {
+		if match(query) {
+			backlog.Len += b.Len
+			backlog.Bytes += b.Bytes
+		}
+	}
+	limit := minAllowance(s.cfg.SendLimit, s.limiter.allow(time.Now(), averageSize(backlog)))
+	if limit == 0 {
+		return nil
+	}
+
 	extractSize := 0
 	safes := map[string][]ballistic.DataModel{}
-	ejectModels, _ := s.memoryPool.Eject(s.cfg.SendLimit)
+	ejectModels, _ := s.memoryPool.EjectWhere(limit, match)
 	extractSize += len(ejectModels)
 	for _, dataModel := range ejectModels {
 		query := dataModel.SQL()
 		safes[query] = append(safes[query], dataModel)
 	}
 
-	extractCount := s.cfg.SendLimit - extractSize
+	extractCount := limit - extractSize
 	if extractCount > 0 {
-		ejectModels, err := s.filePool.Eject(extractCount)
-		extractSize += len(ejectModels)
+		ejectModels, err := s.filePool.EjectWhere(extractCount, match)
 		if err != nil {
 			s.logger.Warnw("problem ejecting queue from disk", "error", err)
+			s.failed(err)
 		}
+		extractSize += len(ejectModels)
 		for _, dataModel := range ejectModels {
 			query := dataModel.SQL()
 			safes[query] = append(safes[query], dataModel)
 		}
 	}
 
-	for query, dataModels := range safes {
-		err := s.publish(ctx, query, dataModels)
-		if err != nil {
-			s.logger.Warnw("publication ended with an error", "error", err)
-			s.fallback(dataModels, s.cfg.UseMemoryFallback)
-		} else {
-			if s.cfg.ShowSuccessfulInfo {
-				s.logger.Infow("successfully sent", "count", len(dataModels))
+	for _, dataModels := range safes {
+		spend(dataModels, s.limiter)
+	}
+	return safes
+}
+
+// sendBatch publishes the models in one transaction, they fall back to the pools on an error.
+// With SealBatches and for ordered destinations the models are sealed into a batch retried as is instead.
+func (s *Sender) sendBatch(ctx context.Context, query string, dataModels []ballistic.DataModel, memorySafe bool) error {
+	if batch := s.seal(query, dataModels); batch != nil {
+		return s.sendSealed(ctx, batch)
+	}
+
+	err := s.publish(ctx, query, dataModels)
+	if err != nil {
+		s.logger.Warnw("publication ended with an error", "error", err)
+		s.failed(err)
+		s.fallback(dataModels, memorySafe)
+		return err
+	}
+
+	s.published()
+	if s.cfg.ShowSuccessfulInfo {
+		s.logger.Infow("successfully sent", "count", len(dataModels))
+	}
+	return nil
+}
+
+// sendSealed publishes the sealed batch with its id as the deduplication token.
+// On an error the batch is held for a retry, or dead-lettered after MaxBatchAttempts.
+func (s *Sender) sendSealed(ctx context.Context, batch *sealedBatch) error {
+	query := batch.query
+	if s.cfg.DeduplicationToken {
+		query = withSetting(query, "insert_deduplication_token", batch.id)
+	}
+
+	batch.attempts++
+	err := publish(ctx, s.connect, s.logger, query, batch.dataModels)
+	if err != nil {
+		s.logger.Warnw("publication ended with an error", "batch", batch.id, "attempts", batch.attempts, "error", err)
+		s.failed(err)
+
+		if s.cfg.MaxBatchAttempts > 0 && batch.attempts >= s.cfg.MaxBatchAttempts {
+			s.logger.Errorw("batch dead-lettered", "batch", batch.id, "attempts", batch.attempts, "records", len(batch.dataModels))
+			if derr := s.batches.deadLetter(batch); derr != nil {
+				s.logger.Errorw("problem dead-lettering a batch", "batch", batch.id, "error", derr)
 			}
+			return err
+		}
+		s.batches.hold(batch)
+		return err
+	}
+
+	s.published()
+	if cerr := s.batches.commit(batch); cerr != nil {
+		s.logger.Warnw("problem removing a committed batch", "batch", batch.id, "error", cerr)
+	}
+	if s.cfg.ShowSuccessfulInfo {
+		s.logger.Infow("successfully sent", "batch", batch.id, "count", len(batch.dataModels))
+	}
+	return nil
+}
+
+// persistSealed writes the held batches kept in memory before the sender stops.
+func (s *Sender) persistSealed() {
+	if s.batches == nil {
+		return
+	}
+	if lost, err := s.batches.persist(); err != nil {
+		s.logger.Errorw("data lost! fatal error writing the sealed batches when stopping sender",
+			"error", err,
+			"lost", lost,
+		)
+	}
+}
+
+// sealsBatches reports whether some batches are sealed, with SealBatches or ordered destinations.
+func (s *Sender) sealsBatches() bool {
+	if s.cfg.SealBatches {
+		return true
+	}
+	for _, d := range s.cfg.Destinations {
+		if d.Ordered {
+			return true
 		}
 	}
+	return false
+}
+
+// seal seals the models of the query into a batch, nil if the batches of the query aren't sealed
+// or the batch can't be written. A batch of an ordered destination is kept in memory then.
+func (s *Sender) seal(query string, dataModels []ballistic.DataModel) *sealedBatch {
+	ordered := s.ordered(query)
+	if s.batches == nil || !(s.cfg.SealBatches || ordered) {
+		return nil
+	}
+
+	batch, err := s.batches.seal(query, dataModels, ordered)
+	if err != nil {
+		s.logger.Warnw("problem sealing a batch", "query", query, "error", err)
+	}
+	return batch
+}
+
+// holdBatch seals the models of the query into a batch held behind the ones of the query
+// without sending it, the models fall back to the pools if the batch isn't sealed.
+func (s *Sender) holdBatch(query string, dataModels []ballistic.DataModel, memorySafe bool) {
+	if batch := s.seal(query, dataModels); batch != nil {
+		s.batches.hold(batch)
+		return
+	}
+	s.fallback(dataModels, memorySafe)
+}
+
+// retrySealed retries the held batches oldest first, the batches of a query wait
+// once one of it fails. It returns the queries with a failed batch.
+func (s *Sender) retrySealed(ctx context.Context) map[string]bool {
+	blocked := map[string]bool{}
+	if s.batches == nil {
+		return blocked
+	}
+
+	for _, batch := range s.batches.takeAll() {
+		if blocked[batch.query] {
+			s.batches.hold(batch)
+			continue
+		}
+		if err := s.sendSealed(ctx, batch); err != nil {
+			blocked[batch.query] = true
+		}
+	}
+	return blocked
 }
 
 func (s *Sender) stop(ctx context.Context, sendTail bool) {
 	atomic.StoreInt32(&s.isShutdown, 1)
+	s.closeIngress()
 
 	ejectModels, _ := s.memoryPool.Eject(-1)
 	if !sendTail {
@@ -191,46 +685,72 @@ func (s *Sender) stop(ctx context.Context, sendTail bool) {
 				)
 			}
 		}
+		s.persistSealed()
 		close(s.stopSig)
 		return
 	}
 
-	safes := map[string][]ballistic.DataModel{}
+	// Batches failing again stay sealed on disk for the next run
+	blocked := s.retrySealed(ctx)
 
-	// From memory
-	for _, dataModel := range ejectModels {
-		query := dataModel.SQL()
-		safes[query] = append(safes[query], dataModel)
-	}
+	safes := map[string][]ballistic.DataModel{}
 
-	// From file
-	ejectModels, err := s.filePool.Eject(-1)
+	// From file, the memory holds the newer records of ordered destinations
+	fileModels, err := s.filePool.Eject(-1)
 	if err != nil {
 		s.logger.Warnw("problem ejecting queue from disk", "error", err)
 	}
-	for _, dataModel := range ejectModels {
+	for _, dataModel := range append(fileModels, ejectModels...) {
 		query := dataModel.SQL()
 		safes[query] = append(safes[query], dataModel)
 	}
 
+	routes := s.scheduled()
 	for query, dataModels := range safes {
-		err := s.publish(ctx, query, dataModels)
-		if err != nil {
-			s.logger.Warnw("publication ended with an error", "error", err)
-			s.fallback(dataModels, false)
+		maxBytes := 0
+		if r, ok := routes[query]; ok {
+			maxBytes = r.MaxBatchBytes
+		}
+		for _, batch := range splitBatch(dataModels, maxBytes) {
+			if blocked[query] {
+				s.holdBatch(query, batch, false)
+				continue
+			}
+			if s.sendBatch(ctx, query, batch, false) != nil && s.ordered(query) {
+				blocked[query] = true
+			}
 		}
 	}
 
+	s.persistSealed()
 	close(s.stopSig)
 }
 
 func (s *Sender) RunPusher(ctx context.Context) {
-	t := time.NewTicker(s.cfg.SendInterval)
+	atomic.StoreInt32(&s.isRunning, 1)
+	defer atomic.StoreInt32(&s.isRunning, 0)
+
+	tick := s.tick()
+	s.nextShared = time.Now().Add(s.cfg.SendInterval)
+	t := time.NewTicker(tick)
 	defer t.Stop()
 	for {
 		select {
-		case <-t.C:
-			s.send(ctx)
+		case now := <-t.C:
+			if s.send(ctx, now, tick/2) {
+				s.wake()
+			}
+			if s.catchUp(ctx) {
+				return
+			}
+		case <-s.wakeSig:
+			// Draining goes on through the wake signal, so a stop isn't held back
+			if s.send(ctx, time.Now(), tick/2) {
+				s.wake()
+			}
+			if s.catchUp(ctx) {
+				return
+			}
 		case sendTail := <-s.stopSig:
 			s.stop(ctx, sendTail)
 			return
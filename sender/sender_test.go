@@ -2,6 +2,13 @@ package sender
 
 import (
 	"context"
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -25,3 +32,103 @@ func TestSenderStopByCtx(t *testing.T) {
 
 	s.Stop(false)
 }
+
+// valueRow is not a BinaryMarshaler.
+type valueRow struct {
+	N int
+}
+
+func (r *valueRow) SQL() string {
+	return insertT
+}
+
+func (r *valueRow) ToExec() []interface{} {
+	return []interface{}{int64(r.N)}
+}
+
+func TestPushValue(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("t", 1, file.CodedFactory(&valueRow{}, file.Packed)))
+	cfg := Config{FileWorkspace: tempDir, Registry: registry, FileCodec: file.Packed, SendLimit: 10}
+
+	s := NewSender(nil, cfg)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.PushValue(&valueRow{N: i}))
+	}
+	assert.Equal(t, 3, s.filePool.Backlog(insertT).Len)
+
+	// The rows are restored and sent
+	db, connect := newFakeDB()
+	s = NewSender(connect, cfg)
+	assert.Equal(t, 3, s.filePool.Backlog(insertT).Len)
+	s.send(context.Background(), time.Now(), 0)
+	assert.Equal(t, []int64{0, 1, 2}, db.rows(insertT))
+}
+
+func TestPushRecordError(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	s := NewSender(nil, Config{FileWorkspace: tempDir, UseMemoryFallback: true})
+	model := &testModel{Q: insertT + strings.Repeat(" ", 1<<16)}
+
+	err = s.Push(model)
+	var recordErr *file.RecordError
+	assert.ErrorAs(t, err, &recordErr)
+	assert.Zero(t, s.memoryPool.Backlog(model.SQL()).Len)
+	assert.Zero(t, s.filePool.Backlog(model.SQL()).Len)
+}
+
+func TestPushBatch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(tempDir))
+	}()
+
+	db, connect := newFakeDB()
+	s := NewSender(connect, Config{FileWorkspace: tempDir})
+
+	insertU := "INSERT INTO u (n) VALUES (?)"
+	var models []ballistic.DataModel
+	for i := 0; i < 6; i++ {
+		q := insertT
+		if i%2 == 1 {
+			q = insertU
+		}
+		models = append(models, &testModel{Q: q, N: i})
+	}
+	// Too large for a record, it doesn't fall back to memory
+	models[2] = &testModel{Q: insertT + strings.Repeat(" ", 1<<16), N: 2}
+
+	errs := s.PushBatch(models)
+	require.Len(t, errs, len(models))
+	var recordErr *file.RecordError
+	assert.ErrorAs(t, errs[2], &recordErr)
+	for i, err := range errs {
+		if i != 2 {
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, 2, s.filePool.Backlog(insertT).Len)
+	assert.Equal(t, 3, s.filePool.Backlog(insertU).Len)
+	assert.Zero(t, s.memoryPool.Backlog(models[2].SQL()).Len)
+
+	assert.Nil(t, s.PushBatch([]ballistic.DataModel{&testModel{Q: insertT, N: 6}}))
+	now := time.Now()
+	for s.backlog(insertT).Len > 0 || s.backlog(insertU).Len > 0 {
+		now = now.Add(time.Hour)
+		s.send(context.Background(), now, 0)
+	}
+	assert.Equal(t, []int64{0, 4, 6}, db.rows(insertT))
+	assert.Equal(t, []int64{1, 3, 5}, db.rows(insertU))
+}
@@ -0,0 +1,135 @@
+package sender
+
+import (
+	"sort"
+	"time"
+)
+
+// QueueState describes a queue of a Pool to the Strategy.
+type QueueState struct {
+	SQL string
+	Len int
+	// Oldest is the push time of the oldest pending record.
+	Oldest time.Time
+}
+
+// Strategy shares the eject limit of a Pool between its queues.
+type Strategy interface {
+	// Share returns the number of records to eject from each queue, at most limit in total.
+	// Round counts the calls, so strategies rotate the queues served first without keeping state.
+	Share(round, limit int, queues []QueueState) []int
+}
+
+var (
+	// RoundRobin shares the limit evenly between the queues with records.
+	RoundRobin Strategy = weighted{}
+	// Proportional shares the limit in proportion to the backlog of the queues.
+	Proportional Strategy = proportional{}
+	// OldestFirst ejects the oldest records first, across all queues.
+	OldestFirst Strategy = oldestFirst{}
+)
+
+// Weighted shares the limit in proportion to the weights of the queues by SQL,
+// queues without a weight have the weight 1.
+func Weighted(weights map[string]int) Strategy {
+	return weighted{weights: weights}
+}
+
+type weighted struct {
+	weights map[string]int
+}
+
+func (s weighted) Share(round, limit int, queues []QueueState) []int {
+	weights := make([]int, len(queues))
+	for i, q := range queues {
+		weights[i] = 1
+		if w, ok := s.weights[q.SQL]; ok && w > 0 {
+			weights[i] = w
+		}
+	}
+	return fill(round, limit, queues, weights)
+}
+
+type proportional struct{}
+
+func (proportional) Share(round, limit int, queues []QueueState) []int {
+	weights := make([]int, len(queues))
+	for i, q := range queues {
+		weights[i] = q.Len
+	}
+	return fill(round, limit, queues, weights)
+}
+
+type oldestFirst struct{}
+
+func (oldestFirst) Share(_, limit int, queues []QueueState) []int {
+	order := make([]int, len(queues))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return queues[order[a]].Oldest.Before(queues[order[b]].Oldest)
+	})
+
+	shares := make([]int, len(queues))
+	for _, i := range order {
+		if limit == 0 {
+			break
+		}
+		shares[i] = minInt(limit, queues[i].Len)
+		limit -= shares[i]
+	}
+	return shares
+}
+
+// fill shares the limit in proportion to the weights, the share of a queue left over
+// by a short one is shared between the others. Remainders go one by one
+// to the queues in turn, starting with the queue of the round.
+func fill(round, limit int, queues []QueueState, weights []int) []int {
+	shares := make([]int, len(queues))
+	active := make([]int, 0, len(queues))
+	for i, q := range queues {
+		if q.Len > 0 && weights[i] > 0 {
+			active = append(active, i)
+		}
+	}
+
+	for limit > 0 && len(active) > 0 {
+		total := 0
+		for _, i := range active {
+			total += weights[i]
+		}
+
+		given := 0
+		for _, i := range active {
+			share := minInt(limit*weights[i]/total, queues[i].Len-shares[i])
+			shares[i] += share
+			given += share
+		}
+
+		if given == 0 {
+			start := round % len(active)
+			for k := 0; k < len(active) && given < limit; k++ {
+				shares[active[(start+k)%len(active)]]++
+				given++
+			}
+		}
+		limit -= given
+
+		left := active[:0]
+		for _, i := range active {
+			if shares[i] < queues[i].Len {
+				left = append(left, i)
+			}
+		}
+		active = left
+	}
+	return shares
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
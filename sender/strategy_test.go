@@ -0,0 +1,128 @@
+package sender
+
+import (
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func states(lens ...int) []QueueState {
+	s := make([]QueueState, len(lens))
+	for i, n := range lens {
+		s[i] = QueueState{SQL: string(rune('a' + i)), Len: n}
+	}
+	return s
+}
+
+func TestStrategyShare(t *testing.T) {
+	// Short queues leave their share to the others
+	assert.Equal(t, []int{2, 49, 49}, RoundRobin.Share(0, 100, states(2, 1000, 1000)))
+	// Remainders rotate with the round
+	assert.Equal(t, []int{1, 0, 0}, RoundRobin.Share(0, 1, states(5, 5, 5)))
+	assert.Equal(t, []int{0, 1, 0}, RoundRobin.Share(1, 1, states(5, 5, 5)))
+	assert.Equal(t, []int{0, 0, 0}, RoundRobin.Share(0, 10, states(0, 0, 0)))
+
+	assert.Equal(t, []int{80, 20, 0}, Weighted(map[string]int{"a": 4}).Share(0, 100, states(1000, 1000, 0)))
+	assert.Equal(t, []int{90, 10}, Proportional.Share(0, 100, states(900, 100)))
+
+	queues := states(10, 10, 10)
+	queues[0].Oldest = time.Unix(3, 0)
+	queues[1].Oldest = time.Unix(1, 0)
+	queues[2].Oldest = time.Unix(2, 0)
+	assert.Equal(t, []int{0, 10, 5}, OldestFirst.Share(0, 15, queues))
+}
+
+func newMemoryPool() *TypedPool[*poolModel] {
+	return NewTypedPool(func(model *poolModel) (ballistic.TypedQueue[*poolModel], error) {
+		return memory.NewTypedQueue[*poolModel](), nil
+	})
+}
+
+// TestStarvation feeds a chatty queue far over the limit next to quiet ones
+// and counts the ticks a quiet queue with records waits to be served.
+func TestStarvation(t *testing.T) {
+	tests := []struct {
+		strategy Strategy
+		maxWait  int
+	}{
+		{strategy: RoundRobin, maxWait: 0},
+		{strategy: Weighted(map[string]int{"chatty": 10}), maxWait: 0},
+		{strategy: Proportional, maxWait: 4},
+	}
+	for _, tt := range tests {
+		p := newMemoryPool()
+		p.SetStrategy(tt.strategy)
+
+		quiet := []string{"q1", "q2", "q3"}
+		waits := map[string]int{}
+		maxWait := 0
+		for tick := 0; tick < 50; tick++ {
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, p.Push(&poolModel{query: "chatty"}))
+			}
+			for _, q := range quiet {
+				require.NoError(t, p.Push(&poolModel{query: q}))
+			}
+
+			models, err := p.Eject(100)
+			require.NoError(t, err)
+			require.Len(t, models, 100)
+
+			served := map[string]bool{}
+			for _, m := range models {
+				served[m.query] = true
+			}
+			assert.True(t, served["chatty"])
+			for _, q := range quiet {
+				if served[q] {
+					waits[q] = 0
+					continue
+				}
+				waits[q]++
+				if waits[q] > maxWait {
+					maxWait = waits[q]
+				}
+			}
+		}
+		assert.LessOrEqual(t, maxWait, tt.maxWait, "%T", tt.strategy)
+	}
+}
+
+func TestOldestFirst(t *testing.T) {
+	p := newMemoryPool()
+	p.SetStrategy(OldestFirst)
+	clock := time.Unix(0, 0)
+	p.now = func() time.Time {
+		return clock
+	}
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, p.Push(&poolModel{query: "chatty", n: i}))
+	}
+	clock = clock.Add(2 * time.Second)
+	require.NoError(t, p.Push(&poolModel{query: "quiet"}))
+
+	// The quiet record waits for the older ones only
+	for tick := 0; tick < 10; tick++ {
+		clock = clock.Add(2 * time.Second)
+		for i := 0; i < 50; i++ {
+			require.NoError(t, p.Push(&poolModel{query: "chatty"}))
+		}
+
+		models, err := p.Eject(100)
+		require.NoError(t, err)
+		require.Len(t, models, 100)
+		assert.Equal(t, tick*100, models[0].n)
+	}
+	assert.Equal(t, time.Unix(4, 0), p.Stats()["chatty"].Oldest)
+
+	models, err := p.Eject(100)
+	require.NoError(t, err)
+	require.Len(t, models, 100)
+	// Models come in the order of the queries
+	assert.Equal(t, "quiet", models[99].query)
+	assert.Equal(t, time.Unix(6, 0), p.Stats()["chatty"].Oldest)
+}
@@ -0,0 +1,137 @@
+package tagged
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+var errShort = errors.New("tagged: short data")
+
+// MarshalBinary encodes the tagged fields in column order:
+// varints for integers, fixed size floats, length prefixed strings, bytes and times.
+func (r *Row) MarshalBinary() ([]byte, error) {
+	s := r.value.Elem()
+	buf := make([]byte, 0, 8*len(r.meta.fields))
+	var tmp [binary.MaxVarintLen64]byte
+
+	for _, f := range r.meta.fields {
+		v := s.FieldByIndex(f.index)
+		switch f.kind {
+		case kindBool:
+			if v.Bool() {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case kindInt:
+			buf = append(buf, tmp[:binary.PutVarint(tmp[:], v.Int())]...)
+		case kindUint:
+			buf = append(buf, tmp[:binary.PutUvarint(tmp[:], v.Uint())]...)
+		case kindFloat32:
+			binary.BigEndian.PutUint32(tmp[:4], math.Float32bits(float32(v.Float())))
+			buf = append(buf, tmp[:4]...)
+		case kindFloat64:
+			binary.BigEndian.PutUint64(tmp[:8], math.Float64bits(v.Float()))
+			buf = append(buf, tmp[:8]...)
+		case kindString:
+			buf = appendBytes(buf, []byte(v.String()))
+		case kindBytes:
+			buf = appendBytes(buf, v.Bytes())
+		case kindTime:
+			data, err := v.Interface().(time.Time).MarshalBinary()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.column, err)
+			}
+			buf = appendBytes(buf, data)
+		}
+	}
+
+	return buf, nil
+}
+
+func appendBytes(buf, data []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(data)))]...)
+	return append(buf, data...)
+}
+
+// UnmarshalBinary decodes data encoded by MarshalBinary of the same struct type.
+func (r *Row) UnmarshalBinary(data []byte) error {
+	if r.meta == nil {
+		return errors.New("tagged: unmarshal into a row without a type, create it with New")
+	}
+
+	s := r.value.Elem()
+	for _, f := range r.meta.fields {
+		v := s.FieldByIndex(f.index)
+		switch f.kind {
+		case kindBool:
+			if len(data) < 1 {
+				return errShort
+			}
+			v.SetBool(data[0] != 0)
+			data = data[1:]
+		case kindInt:
+			x, n := binary.Varint(data)
+			if n <= 0 {
+				return errShort
+			}
+			if v.OverflowInt(x) {
+				return fmt.Errorf("tagged: %s overflows %s", f.column, v.Type())
+			}
+			v.SetInt(x)
+			data = data[n:]
+		case kindUint:
+			x, n := binary.Uvarint(data)
+			if n <= 0 {
+				return errShort
+			}
+			if v.OverflowUint(x) {
+				return fmt.Errorf("tagged: %s overflows %s", f.column, v.Type())
+			}
+			v.SetUint(x)
+			data = data[n:]
+		case kindFloat32:
+			if len(data) < 4 {
+				return errShort
+			}
+			v.SetFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(data))))
+			data = data[4:]
+		case kindFloat64:
+			if len(data) < 8 {
+				return errShort
+			}
+			v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(data)))
+			data = data[8:]
+		case kindString, kindBytes, kindTime:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errShort
+			}
+			b := data[n : n+int(size)]
+			data = data[n+int(size):]
+
+			switch f.kind {
+			case kindString:
+				v.SetString(string(b))
+			case kindBytes:
+				v.SetBytes(append([]byte(nil), b...))
+			case kindTime:
+				var t time.Time
+				if err := t.UnmarshalBinary(b); err != nil {
+					return fmt.Errorf("tagged: %s: %w", f.column, err)
+				}
+				v.Set(reflect.ValueOf(t))
+			}
+		}
+	}
+
+	if len(data) != 0 {
+		return fmt.Errorf("tagged: %d trailing bytes", len(data))
+	}
+	return nil
+}
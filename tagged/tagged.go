@@ -0,0 +1,256 @@
+// Package tagged builds a ballistic.DataModel from a struct with ch tags,
+// so the column order of the INSERT statement and of the arguments can't diverge.
+//
+//	type Event struct {
+//		Time time.Time `ch:"record_time"`
+//		Name string    `ch:"name"`
+//		Skip string    `ch:"-"`
+//	}
+//
+//	err := s.Push(tagged.Must("db.events", &Event{Time: time.Now(), Name: "click"}))
+//
+// Fields without the tag are not stored, the tagged fields of embedded structs are.
+// Embedded pointers to structs with tagged fields are refused. Supported kinds are bool, integers, floats,
+// strings, byte slices and time.Time. Bools are passed to the driver as 0 and 1.
+package tagged
+
+import (
+	"fmt"
+	"github.com/farwydi/ballistic"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagName is the struct tag holding the column name.
+const TagName = "ch"
+
+type fieldKind int
+
+const (
+	kindBool fieldKind = iota
+	kindInt
+	kindUint
+	kindFloat32
+	kindFloat64
+	kindString
+	kindBytes
+	kindTime
+)
+
+type field struct {
+	index  []int
+	column string
+	kind   fieldKind
+}
+
+// meta is the cached description of a struct type stored into a table.
+type meta struct {
+	typeOf reflect.Type
+	table  string
+	sql    string
+	fields []field
+}
+
+type metaKey struct {
+	typeOf reflect.Type
+	table  string
+}
+
+var (
+	metaCache sync.Map
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+func getMeta(typeOf reflect.Type, table string) (*meta, error) {
+	key := metaKey{typeOf: typeOf, table: table}
+	if m, ok := metaCache.Load(key); ok {
+		return m.(*meta), nil
+	}
+
+	fields, err := fieldsOf(typeOf, nil)
+	if err != nil {
+		return nil, err
+	}
+	m := &meta{
+		typeOf: typeOf,
+		table:  table,
+		fields: fields,
+	}
+
+	if len(m.fields) == 0 {
+		return nil, fmt.Errorf("%s has no fields tagged with %q", typeOf, TagName)
+	}
+
+	columns := make([]string, len(m.fields))
+	for i, f := range m.fields {
+		columns[i] = f.column
+	}
+	m.sql = "INSERT INTO " + table +
+		" (" + strings.Join(columns, ", ") + ")" +
+		" VALUES (" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+
+	actual, _ := metaCache.LoadOrStore(key, m)
+	return actual.(*meta), nil
+}
+
+// fieldsOf returns the tagged fields of the struct type, the fields of embedded structs
+// without a tag are promoted. Embedded pointers to structs with tagged fields are refused.
+func fieldsOf(typeOf reflect.Type, index []int) ([]field, error) {
+	var fields []field
+	for i := 0; i < typeOf.NumField(); i++ {
+		sf := typeOf.Field(i)
+		column, ok := sf.Tag.Lookup(TagName)
+		if column == "-" {
+			continue
+		}
+
+		if sf.Anonymous && !ok {
+			t := sf.Type
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() != reflect.Struct || t == timeType {
+				continue
+			}
+
+			embedded, err := fieldsOf(t, append(append([]int(nil), index...), sf.Index...))
+			if err != nil {
+				return nil, err
+			}
+			if len(embedded) > 0 && sf.Type.Kind() == reflect.Ptr {
+				return nil, fmt.Errorf("%s.%s: embedded pointers are not supported", typeOf, sf.Name)
+			}
+			fields = append(fields, embedded...)
+			continue
+		}
+		if !ok || sf.PkgPath != "" {
+			continue
+		}
+
+		kind, err := kindOf(sf.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", typeOf, sf.Name, err)
+		}
+
+		fields = append(fields, field{
+			index:  append(append([]int(nil), index...), sf.Index...),
+			column: column,
+			kind:   kind,
+		})
+	}
+	return fields, nil
+}
+
+func kindOf(t reflect.Type) (fieldKind, error) {
+	switch {
+	case t == timeType:
+		return kindTime, nil
+	case t == bytesType:
+		return kindBytes, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return kindBool, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return kindInt, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return kindUint, nil
+	case reflect.Float32:
+		return kindFloat32, nil
+	case reflect.Float64:
+		return kindFloat64, nil
+	case reflect.String:
+		return kindString, nil
+	}
+	return 0, fmt.Errorf("unsupported type %s", t)
+}
+
+// Row is a struct value stored into a table.
+type Row struct {
+	meta  *meta
+	value reflect.Value
+}
+
+// New wraps a pointer to a tagged struct into a model of the table.
+func New(table string, v interface{}) (*Row, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T is not a pointer to a struct", v)
+	}
+
+	m, err := getMeta(value.Elem().Type(), table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Row{
+		meta:  m,
+		value: value,
+	}, nil
+}
+
+// Must is like New but panics on error.
+func Must(table string, v interface{}) *Row {
+	row, err := New(table, v)
+	if err != nil {
+		panic(err)
+	}
+	return row
+}
+
+// Factory returns a registry factory of empty rows of the type of v.
+func Factory(table string, v interface{}) (ballistic.ModelFactory, error) {
+	row, err := New(table, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() ballistic.DataModel {
+		return row.New()
+	}, nil
+}
+
+// Value returns the wrapped pointer to the struct.
+func (r *Row) Value() interface{} {
+	return r.value.Interface()
+}
+
+// Unwrap is Value, it identifies the row type in a registry.
+func (r *Row) Unwrap() interface{} {
+	return r.value.Interface()
+}
+
+// New returns an empty row of the same type and table.
+func (r *Row) New() ballistic.DataModel {
+	return &Row{
+		meta:  r.meta,
+		value: reflect.New(r.meta.typeOf),
+	}
+}
+
+func (r *Row) SQL() string {
+	return r.meta.sql
+}
+
+func (r *Row) ToExec() []interface{} {
+	s := r.value.Elem()
+	args := make([]interface{}, len(r.meta.fields))
+	for i, f := range r.meta.fields {
+		v := s.FieldByIndex(f.index)
+		switch f.kind {
+		case kindBool:
+			if v.Bool() {
+				args[i] = uint8(1)
+			} else {
+				args[i] = uint8(0)
+			}
+		default:
+			args[i] = v.Interface()
+		}
+	}
+	return args
+}
@@ -0,0 +1,171 @@
+package tagged
+
+import (
+	"github.com/farwydi/ballistic"
+	"github.com/farwydi/ballistic/queue/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type event struct {
+	RecordTime time.Time `ch:"record_time"`
+	StringVal  string    `ch:"string_val"`
+	BoolVar    bool      `ch:"bool_var"`
+	Int32Val   int32     `ch:"int_32_val"`
+	UInt64Val  uint64    `ch:"u_int_64_val"`
+	Float32Val float32   `ch:"float_32_val"`
+	Float64Val float64   `ch:"float_64_val"`
+	Bytes      []byte    `ch:"bytes"`
+	Skipped    string    `ch:"-"`
+	Untagged   string
+}
+
+func TestRow(t *testing.T) {
+	now := time.Date(2021, 4, 29, 20, 1, 34, 561, time.UTC)
+	e := &event{
+		RecordTime: now,
+		StringVal:  "test",
+		BoolVar:    true,
+		Int32Val:   -7,
+		UInt64Val:  1 << 60,
+		Float32Val: 1.5,
+		Float64Val: 2.25,
+		Bytes:      []byte{1, 2},
+		Skipped:    "skipped",
+		Untagged:   "untagged",
+	}
+	row, err := New("test.table_1", e)
+	require.NoError(t, err)
+
+	assert.Equal(t, "INSERT INTO test.table_1 "+
+		"(record_time, string_val, bool_var, int_32_val, u_int_64_val, float_32_val, float_64_val, bytes) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)", row.SQL())
+	assert.Equal(t, []interface{}{
+		now, "test", uint8(1), int32(-7), uint64(1 << 60), float32(1.5), 2.25, []byte{1, 2},
+	}, row.ToExec())
+
+	data, err := row.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := row.New().(*Row)
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	expected := *e
+	expected.Skipped = ""
+	expected.Untagged = ""
+	assert.Equal(t, &expected, decoded.Value())
+
+	assert.Error(t, decoded.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, (&Row{}).UnmarshalBinary(data))
+
+	// The metadata is built once per type and table
+	other := Must("test.table_1", &event{})
+	assert.Same(t, row.meta, other.meta)
+	assert.NotSame(t, row.meta, Must("test.table_2", &event{}).meta)
+
+	_, err = New("t", event{})
+	assert.Error(t, err)
+	_, err = New("t", &struct {
+		C chan int `ch:"c"`
+	}{})
+	assert.Error(t, err)
+}
+
+type base struct {
+	RecordTime time.Time `ch:"record_time"`
+}
+
+type embedding struct {
+	base
+	StringVal string `ch:"string_val"`
+}
+
+type embeddingPtr struct {
+	*base
+	StringVal string `ch:"string_val"`
+}
+
+func TestRowEmbedded(t *testing.T) {
+	now := time.Date(2021, 4, 29, 20, 1, 34, 561, time.UTC)
+	row, err := New("t", &embedding{base: base{RecordTime: now}, StringVal: "test"})
+	require.NoError(t, err)
+	assert.Equal(t, "INSERT INTO t (record_time, string_val) VALUES (?, ?)", row.SQL())
+	assert.Equal(t, []interface{}{now, "test"}, row.ToExec())
+
+	data, err := row.MarshalBinary()
+	require.NoError(t, err)
+	decoded := row.New().(*Row)
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, row.Value(), decoded.Value())
+
+	_, err = New("t", &embeddingPtr{base: &base{}})
+	assert.Error(t, err)
+}
+
+func TestRowInFileQueue(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tempFile.Close())
+		assert.NoError(t, os.Remove(tempFile.Name()))
+	}()
+
+	factory, err := Factory("test.table_1", &event{})
+	require.NoError(t, err)
+	registry := ballistic.NewRegistry()
+	require.NoError(t, registry.Register("event", 1, factory))
+
+	name, _, ok := registry.Lookup(Must("test.table_1", &event{StringVal: "x"}))
+	assert.True(t, ok)
+	assert.Equal(t, "event", name)
+
+	// The rows of another table aren't decoded as events
+	_, _, ok = registry.Lookup(Must("test.table_2", &event{}))
+	assert.False(t, ok)
+	factory2, err := Factory("test.table_2", &event{})
+	require.NoError(t, err)
+	require.NoError(t, registry.Register("event2", 1, factory2))
+	name, _, ok = registry.Lookup(Must("test.table_2", &event{}))
+	assert.True(t, ok)
+	assert.Equal(t, "event2", name)
+	assert.ErrorIs(t, registry.Register("event3", 1, factory2), ballistic.ErrModelRegistered)
+
+	q, err := file.NewQueue(tempFile, Must("test.table_1", &event{}), file.Config{Registry: registry})
+	require.NoError(t, err)
+	assert.Equal(t, "event", q.Header().Model)
+	require.NoError(t, q.Push(Must("test.table_1", &event{StringVal: "a", Int32Val: 1})))
+	require.NoError(t, q.Push(Must("test.table_1", &event{StringVal: "b", Int32Val: 2})))
+
+	q, err = file.OpenQueue(tempFile, registry)
+	require.NoError(t, err)
+	models, err := q.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 2)
+	assert.Equal(t, "a", models[0].(*Row).Value().(*event).StringVal)
+	assert.Equal(t, int32(2), models[1].(*Row).Value().(*event).Int32Val)
+	assert.Equal(t, "INSERT INTO test.table_1 "+
+		"(record_time, string_val, bool_var, int_32_val, u_int_64_val, float_32_val, float_64_val, bytes) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)", models[1].(*Row).SQL())
+}
+
+func TestRowWithCodec(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "ballistic")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tempFile.Close())
+		assert.NoError(t, os.Remove(tempFile.Name()))
+	}()
+
+	// Codecs other than Raw encode the wrapped struct
+	q, err := file.NewQueue(tempFile, Must("test.table_1", &event{}), file.Config{Codec: file.Packed})
+	require.NoError(t, err)
+	require.NoError(t, q.Push(Must("test.table_1", &event{StringVal: "a", Untagged: "u"})))
+
+	models, err := q.Eject(-1)
+	require.NoError(t, err)
+	require.Len(t, models, 1)
+	assert.Equal(t, &event{StringVal: "a", Untagged: "u"}, models[0].(*Row).Value())
+}